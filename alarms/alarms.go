@@ -0,0 +1,82 @@
+package alarms
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alarm es una condición anormal activa del gateway: el PLC sin comunicación,
+// el enlace con Wialon caído o un tag con calidad mala.
+type Alarm struct {
+	ID      string    `json:"id"`
+	Message string    `json:"message"`
+	Since   time.Time `json:"since"`
+	Acked   bool      `json:"acked"` // un técnico la reconoció; vuelve a false si se despeja y reaparece
+}
+
+// Table guarda las alarmas activas. Quien evalúa las condiciones llama a Set
+// en cada escaneo; la alarma conserva la hora en que se activó.
+type Table struct {
+	mu     sync.Mutex
+	active map[string]*Alarm
+}
+
+func NewTable() *Table {
+	return &Table{active: make(map[string]*Alarm)}
+}
+
+// Set activa o despeja la alarma id; un nil no hace nada
+func (t *Table) Set(id string, active bool, message string, now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.active[id]
+	switch {
+	case !active:
+		delete(t.active, id)
+	case ok:
+		a.Message = message
+	default:
+		t.active[id] = &Alarm{ID: id, Message: message, Since: now}
+	}
+}
+
+// Ack reconoce una alarma activa; false si no está activa
+func (t *Table) Ack(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.active[id]
+	if ok {
+		a.Acked = true
+	}
+	return ok
+}
+
+// Active indica si la alarma id está activa
+func (t *Table) Active(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.active[id]
+	return ok
+}
+
+// List devuelve las alarmas activas, las más antiguas primero
+func (t *Table) List() []Alarm {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]Alarm, 0, len(t.active))
+	for _, a := range t.active {
+		list = append(list, *a)
+	}
+	slices.SortFunc(list, func(a, b Alarm) int {
+		if c := a.Since.Compare(b.Since); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return list
+}
@@ -0,0 +1,43 @@
+package alarms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTable_SetAndClear(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	tbl := NewTable()
+	tbl.Set("plc_comm", true, "PLC not responding", t0)
+	tbl.Set("tag_q1", true, "q1: stale", t0.Add(time.Minute))
+	tbl.Set("tag_q1", true, "q1: comm-failure", t0.Add(2*time.Minute))
+	tbl.Set("wialon", false, "", t0)
+
+	list := tbl.List()
+	if len(list) != 2 || list[0].ID != "plc_comm" || list[1].ID != "tag_q1" {
+		t.Fatalf("active alarms: %+v", list)
+	}
+	if !list[1].Since.Equal(t0.Add(time.Minute)) || list[1].Message != "q1: comm-failure" {
+		t.Errorf("an active alarm should keep its start and update its message: %+v", list[1])
+	}
+
+	if !tbl.Ack("plc_comm") || tbl.Ack("wialon") {
+		t.Fatalf("only active alarms can be acknowledged")
+	}
+	tbl.Set("plc_comm", true, "PLC not responding", t0.Add(time.Minute))
+	if list := tbl.List(); !list[0].Acked || !tbl.Active("plc_comm") {
+		t.Errorf("acknowledge should last while the alarm is active: %+v", list[0])
+	}
+
+	tbl.Set("plc_comm", false, "", t0.Add(3*time.Minute))
+	if list := tbl.List(); len(list) != 1 || list[0].ID != "tag_q1" {
+		t.Errorf("cleared alarm should be removed: %+v", list)
+	}
+	tbl.Set("plc_comm", true, "PLC not responding", t0.Add(4*time.Minute))
+	if list := tbl.List(); list[1].ID != "plc_comm" || list[1].Acked {
+		t.Errorf("an alarm that comes back needs a new acknowledge: %+v", list)
+	}
+
+	var none *Table
+	none.Set("x", true, "", t0)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mt-plc-control/clock"
+	"mt-plc-control/history"
+	"mt-plc-control/modbusClient"
+	"mt-plc-control/uplink"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxHistoryRange limita las consultas de histórico de la API local
+const maxHistoryRange = 7 * 24 * time.Hour
+
+// localAPI es la API HTTP/JSON para los técnicos en sitio: valores, histórico,
+// alarmas y comandos W por la misma ruta que los de Wialon.
+type localAPI struct {
+	ctx    context.Context // vida del gateway, para lo que sigue a un comando
+	device string          // IMEI del equipo
+	token  string
+	g      *gateway
+}
+
+type apiTag struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"` // input, output o analog
+	Writable bool   `json:"writable"`
+}
+
+type apiDevice struct {
+	ID   string   `json:"id"`
+	Tags []apiTag `json:"tags"`
+}
+
+type apiValue struct {
+	Device      string     `json:"device"`
+	Tag         string     `json:"tag"`
+	Value       float64    `json:"value"`
+	Quality     string     `json:"quality"`
+	QualityCode int        `json:"quality_code"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"` // última lectura del PLC
+	LastGood    *time.Time `json:"last_good,omitempty"`
+}
+
+type apiPoint struct {
+	Time  time.Time `json:"time"`
+	Tag   string    `json:"tag"`
+	Value float64   `json:"value"`
+}
+
+type apiCommand struct {
+	Tag   string `json:"tag"`
+	Value bool   `json:"value"`
+}
+
+type apiLink struct {
+	OK      bool   `json:"ok"`
+	Breaker string `json:"breaker,omitempty"` // solo Modbus, si hay breaker
+	Outbox  int    `json:"outbox"`            // solo Wialon: escaneos sin subir
+}
+
+type apiStatus struct {
+	Device string  `json:"device"`
+	Modbus apiLink `json:"modbus"`
+	Wialon apiLink `json:"wialon"`
+	Genset bool    `json:"genset"` // se aceptan comandos de grupo electrógeno
+}
+
+type apiGenset struct {
+	Action string `json:"action"` // start o stop
+}
+
+// handler sirve el dashboard en / (sin datos, no pide token) y la API en /api/
+func (a *localAPI) handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/status", a.status)
+	api.HandleFunc("GET /api/devices", a.devices)
+	api.HandleFunc("GET /api/values", a.values)
+	api.HandleFunc("GET /api/history", a.history)
+	api.HandleFunc("GET /api/alarms", a.alarms)
+	api.HandleFunc("POST /api/alarms/{id}/ack", a.ackAlarm)
+	api.HandleFunc("POST /api/commands", a.command)
+	api.HandleFunc("POST /api/genset", a.genset)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", a.authorize(api))
+	mux.Handle("/", dashboardHandler())
+	return mux
+}
+
+// authorize exige "Authorization: Bearer <API_TOKEN>"
+func (a *localAPI) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gateway"`)
+			apiError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *localAPI) now() time.Time {
+	return clock.Or(a.g.clock).Now()
+}
+
+// tags lista los tags configurados; los analógicos de 32 bits cuentan una vez
+func (a *localAPI) tags() []apiTag {
+	g := a.g
+	tags := make([]apiTag, 0)
+	for i, name := range g.addrRead.name {
+		kind := "input"
+		if strings.HasPrefix(g.addrRead.logo[i], "Q") {
+			kind = "output"
+		}
+		tags = append(tags, apiTag{Name: name, Kind: kind, Writable: slices.Contains(g.addrWrite.name, name)})
+	}
+	for i, name := range g.addrAnalog.name {
+		if g.addrAnalog.logo[i] == "0" {
+			tags = append(tags, apiTag{Name: name, Kind: "analog"})
+		}
+	}
+	return tags
+}
+
+func (a *localAPI) status(w http.ResponseWriter, _ *http.Request) {
+	g := a.g
+	st := apiStatus{
+		Device: a.device,
+		Modbus: apiLink{OK: !g.alarms.Active("plc_comm")},
+		Wialon: apiLink{OK: !g.alarms.Active("wialon"), Outbox: g.bf.Outbox()},
+		Genset: g.gensetCommands,
+	}
+	if g.plcConn.Breaker != nil {
+		st.Modbus.Breaker = g.plcConn.Breaker.State().String()
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (a *localAPI) devices(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, []apiDevice{{ID: a.device, Tags: a.tags()}})
+}
+
+func (a *localAPI) values(w http.ResponseWriter, _ *http.Request) {
+	values := make([]apiValue, 0)
+	for _, t := range a.tags() {
+		tag, ok := a.g.quality.Get(t.Name)
+		if !ok {
+			continue // todavía no se escaneó
+		}
+		values = append(values, apiValue{
+			Device:      a.device,
+			Tag:         t.Name,
+			Value:       tag.Value,
+			Quality:     tag.Quality.String(),
+			QualityCode: int(tag.Quality),
+			Timestamp:   optionalTime(tag.ReadAt),
+			LastGood:    optionalTime(tag.LastGood),
+		})
+	}
+	writeJSON(w, http.StatusOK, values)
+}
+
+// history devuelve el histórico local: ?tag=q1&tag=ai3&from=...&to=...&step=5m.
+// Por defecto la última hora de todos los tags.
+func (a *localAPI) history(w http.ResponseWriter, r *http.Request) {
+	if a.g.hist == nil {
+		apiError(w, http.StatusServiceUnavailable, "history disabled")
+		return
+	}
+	q := r.URL.Query()
+	to := a.now()
+	if s := q.Get("to"); s != "" {
+		t, err := parseExportTime(s, time.UTC)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "invalid to: "+err.Error())
+			return
+		}
+		to = t
+	}
+	from := to.Add(-time.Hour)
+	if s := q.Get("from"); s != "" {
+		t, err := parseExportTime(s, time.UTC)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "invalid from: "+err.Error())
+			return
+		}
+		from = t
+	}
+	if to.Sub(from) > maxHistoryRange {
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("range longer than %s", maxHistoryRange))
+		return
+	}
+	var step time.Duration
+	if s := q.Get("step"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "invalid step: "+err.Error())
+			return
+		}
+		step = d
+	}
+	points, err := a.g.hist.Query(q["tag"], from, to)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	points = history.Resample(points, step, time.UTC)
+	out := make([]apiPoint, len(points))
+	for i, p := range points {
+		out[i] = apiPoint{p.Time.UTC(), p.Tag, p.Value}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (a *localAPI) alarms(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, a.g.alarms.List())
+}
+
+func (a *localAPI) ackAlarm(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !a.g.alarms.Ack(id) {
+		apiError(w, http.StatusNotFound, "alarm not active: "+id)
+		return
+	}
+	log.Printf("local API: alarm %s acknowledged from %s", id, r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+// command escribe una salida como un comando W de Wialon: {"tag":"q1","value":true}
+func (a *localAPI) command(w http.ResponseWriter, r *http.Request) {
+	var cmd apiCommand
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&cmd); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid command: "+err.Error())
+		return
+	}
+	if cmd.Tag == "" {
+		apiError(w, http.StatusBadRequest, "invalid tag")
+		return
+	}
+	log.Printf("local API command from %s: %s=%t", r.RemoteAddr, cmd.Tag, cmd.Value)
+	err := a.execute(r, uplink.Command{Kind: uplink.Write, Writes: []uplink.OutputWrite{{Tag: cmd.Tag, Value: cmd.Value}}})
+	switch {
+	case errors.Is(err, errUnknownOutput):
+		apiError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		apiError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, cmd)
+}
+
+// execute corre cmd con prioridad de comando y pide un envío inmediato
+func (a *localAPI) execute(r *http.Request, cmd uplink.Command) error {
+	clk := clock.Or(a.g.clock)
+	ctx := modbusClient.WithPriority(r.Context(), modbusClient.PriorityCommand)
+	if err := a.g.execute(ctx, clk, cmd); err != nil {
+		return err
+	}
+	go a.g.afterCommand(a.ctx, clk)
+	return nil
+}
+
+// genset arranca o para el grupo electrógeno como un comando GS de Wialon
+func (a *localAPI) genset(w http.ResponseWriter, r *http.Request) {
+	if !a.g.gensetCommands {
+		apiError(w, http.StatusForbidden, "genset commands disabled")
+		return
+	}
+	var cmd apiGenset
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&cmd); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid command: "+err.Error())
+		return
+	}
+	action := strings.ToLower(cmd.Action)
+	if action != "start" && action != "stop" {
+		apiError(w, http.StatusBadRequest, "action must be start or stop")
+		return
+	}
+	log.Printf("local API genset %s from %s", action, r.RemoteAddr)
+	if err := a.execute(r, uplink.Command{Kind: uplink.Genset, Start: action == "start"}); err != nil {
+		apiError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, cmd)
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// lanAddr resuelve API_ADDR: "host:puerto", donde host puede ser una IP o el
+// nombre de la interfaz de la LAN (ej. eth0:8080). No se aceptan comodines.
+func lanAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsUnspecified() {
+			return "", fmt.Errorf("%s listens on every interface, name the LAN address or interface", addr)
+		}
+		return addr, nil
+	}
+	if host == "" {
+		return "", fmt.Errorf("%s listens on every interface, name the LAN address or interface", addr)
+	}
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return net.JoinHostPort(ipNet.IP.String(), port), nil
+		}
+	}
+	return "", fmt.Errorf("interface %s has no IPv4 address", host)
+}
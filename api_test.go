@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLanAddr(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:8080", ":8080", "[::]:8080"} {
+		if _, err := lanAddr(addr); err == nil {
+			t.Errorf("%s should be rejected", addr)
+		}
+	}
+	if got, err := lanAddr("192.168.8.2:8080"); err != nil || got != "192.168.8.2:8080" {
+		t.Errorf("IP address: %s, %v", got, err)
+	}
+	if _, err := lanAddr("nosuchif0:8080"); err == nil {
+		t.Errorf("unknown interface should fail")
+	}
+	if _, err := net.InterfaceByName("lo"); err == nil {
+		if got, err := lanAddr("lo:8080"); err != nil || got != "127.0.0.1:8080" {
+			t.Errorf("interface name: %s, %v", got, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package clock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock abstrae el tiempo para poder correr el gateway con un reloj falso en pruebas
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// SleepContext duerme d o hasta que se cancele ctx, y devuelve ctx.Err()
+	SleepContext(ctx context.Context, d time.Duration) error
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// Real usa el paquete time
+type Real struct{}
+
+func (Real) Now() time.Time                         { return time.Now() }
+func (Real) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (Real) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+func (Real) SleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r realTicker) Chan() <-chan time.Time { return r.t.C }
+func (r realTicker) Stop()                  { r.t.Stop() }
+
+// Or devuelve c, o el reloj real si c es nil
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real{}
+	}
+	return c
+}
+
+// Fake es un reloj que solo avanza con Advance o Sleep. Sleep no bloquea:
+// avanza el reloj, así los reintentos y esperas se prueban sin demoras reales.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed chan struct{}
+}
+
+type fakeTimer struct {
+	at     time.Time
+	period time.Duration // 0 para After
+	c      chan time.Time
+}
+
+func NewFake(t time.Time) *Fake {
+	return &Fake{now: t, changed: make(chan struct{})}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	f.Advance(d)
+}
+
+func (f *Fake) SleepContext(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.Advance(d)
+	return nil
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{at: f.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- f.now
+		return t.c
+	}
+	f.add(t)
+	return t.c
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{at: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.add(t)
+	return &fakeTicker{f, t}
+}
+
+// Advance mueve el reloj d y dispara, en orden, los timers y tickers vencidos.
+// Como time.Ticker, un ticker que no fue leído pierde los ticks intermedios.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	target := f.now.Add(d)
+	for len(f.timers) > 0 && !f.timers[0].at.After(target) {
+		t := f.timers[0]
+		f.timers = f.timers[1:]
+		f.now = t.at
+		select {
+		case t.c <- t.at:
+		default:
+		}
+		if t.period > 0 {
+			t.at = t.at.Add(t.period)
+			f.add(t)
+		}
+	}
+	f.now = target
+}
+
+// BlockUntil espera a que haya al menos n timers o tickers activos, o timeout.
+// Sirve para saber que una goroutine ya creó su ticker antes de avanzar el reloj.
+func (f *Fake) BlockUntil(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		f.mu.Lock()
+		count := len(f.timers)
+		changed := f.changed
+		f.mu.Unlock()
+		if count >= n {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// add inserta t ordenado por vencimiento; se llama con f.mu tomado
+func (f *Fake) add(t *fakeTimer) {
+	i := sort.Search(len(f.timers), func(i int) bool { return f.timers[i].at.After(t.at) })
+	f.timers = append(f.timers, nil)
+	copy(f.timers[i+1:], f.timers[i:])
+	f.timers[i] = t
+	f.notify()
+}
+
+func (f *Fake) remove(t *fakeTimer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			f.notify()
+			return
+		}
+	}
+}
+
+func (f *Fake) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+type fakeTicker struct {
+	f *Fake
+	t *fakeTimer
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time { return t.t.c }
+func (t *fakeTicker) Stop()                  { t.f.remove(t.t) }
@@ -0,0 +1,76 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func TestFake_TickerAndAfter(t *testing.T) {
+	f := NewFake(t0)
+	ticker := f.NewTicker(30 * time.Second)
+	after := f.After(45 * time.Second)
+
+	f.Advance(29 * time.Second)
+	select {
+	case <-ticker.Chan():
+		t.Fatalf("ticker fired early")
+	default:
+	}
+
+	f.Advance(time.Second)
+	if got := <-ticker.Chan(); !got.Equal(t0.Add(30 * time.Second)) {
+		t.Errorf("tick time: got %s", got)
+	}
+
+	// sin leer el ticker se pierden los ticks intermedios, como en time.Ticker
+	f.Advance(2 * time.Minute)
+	if got := <-ticker.Chan(); !got.Equal(t0.Add(time.Minute)) {
+		t.Errorf("buffered tick: got %s", got)
+	}
+	select {
+	case <-ticker.Chan():
+		t.Errorf("dropped ticks should not be queued")
+	default:
+	}
+	if got := <-after; !got.Equal(t0.Add(45 * time.Second)) {
+		t.Errorf("after time: got %s", got)
+	}
+	if !f.Now().Equal(t0.Add(2*time.Minute + 30*time.Second)) {
+		t.Errorf("now: got %s", f.Now())
+	}
+
+	ticker.Stop()
+	f.Advance(time.Minute)
+	select {
+	case <-ticker.Chan():
+		t.Errorf("stopped ticker fired")
+	default:
+	}
+}
+
+func TestFake_SleepAdvances(t *testing.T) {
+	f := NewFake(t0)
+	after := f.After(time.Second)
+	f.Sleep(1500 * time.Millisecond)
+	if !f.Now().Equal(t0.Add(1500 * time.Millisecond)) {
+		t.Errorf("sleep should advance the clock, now %s", f.Now())
+	}
+	select {
+	case <-after:
+	default:
+		t.Errorf("sleep past a timer should fire it")
+	}
+}
+
+func TestFake_BlockUntil(t *testing.T) {
+	f := NewFake(t0)
+	if f.BlockUntil(1, 10*time.Millisecond) {
+		t.Fatalf("no timers yet")
+	}
+	go f.NewTicker(time.Second)
+	if !f.BlockUntil(1, time.Second) {
+		t.Errorf("BlockUntil should see the new ticker")
+	}
+}
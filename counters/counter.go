@@ -0,0 +1,177 @@
+package counters
+
+import (
+	"encoding/gob"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Counter guarda el estado de un totalizador del PLC (energía, horas, etc.)
+type Counter struct {
+	Last      uint32 // último valor absoluto leído
+	Pending   uint64 // consumo acumulado desde el último upload
+	Valid     bool   // Last contiene una lectura real
+	Resets    int
+	Rollovers int
+}
+
+type Store struct {
+	mu       sync.Mutex
+	names    map[string]bool
+	counters map[string]*Counter
+	diskPath string
+}
+
+func NewStore(diskPath string, names map[string]bool) *Store {
+	s := &Store{
+		diskPath: diskPath,
+		names:    names,
+		counters: make(map[string]*Counter),
+	}
+	s.LoadCache()
+	return s
+}
+
+// ParseNames lee la lista de contadores de la variable COUNTERS (separados por coma o salto de línea)
+func ParseNames(list string) map[string]bool {
+	names := make(map[string]bool)
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			names[field] = true
+		}
+	}
+	return names
+}
+
+// SaveCache escribe a un archivo temporal y lo renombra: un corte de energía a
+// mitad de la escritura no debe perder la línea base de los contadores.
+func (s *Store) SaveCache() {
+	if s.diskPath == "" {
+		return
+	}
+	tmp := s.diskPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	if err := gob.NewEncoder(f).Encode(s.counters); err != nil {
+		_ = f.Close()
+		return
+	}
+	_ = f.Sync()
+	if err := f.Close(); err != nil {
+		return
+	}
+	_ = os.Rename(tmp, s.diskPath)
+}
+
+func (s *Store) LoadCache() {
+	f, err := os.Open(s.diskPath)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var data map[string]*Counter
+	decoder := gob.NewDecoder(f)
+	if err := decoder.Decode(&data); err != nil {
+		log.Printf("counters cache %s is corrupt, starting over: %v", s.diskPath, err)
+		return
+	}
+	if data == nil {
+		return
+	}
+	s.counters = data
+}
+
+func (s *Store) Tracks(name string) bool {
+	return s.names[name]
+}
+
+// Observe registra una lectura del contador de `width` bits, acumula el incremento
+// y persiste el estado si cambió. Si el valor baja cerca del máximo se asume
+// rollover, si no, un reset del PLC.
+func (s *Store) Observe(name string, value uint32, width uint) {
+	if !s.Tracks(name) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[name]
+	if !ok {
+		c = &Counter{}
+		s.counters[name] = c
+	}
+	if c.Valid && value == c.Last {
+		return
+	}
+	if !c.Valid {
+		c.Last = value
+		c.Valid = true
+	} else {
+		c.Pending += step(c, value, width)
+		c.Last = value
+	}
+	s.SaveCache()
+}
+
+func step(c *Counter, value uint32, width uint) uint64 {
+	if value >= c.Last {
+		return uint64(value - c.Last)
+	}
+	maxValue := uint64(1)<<width - 1
+	if uint64(c.Last) > maxValue-maxValue/4 && uint64(value) < maxValue/4 {
+		c.Rollovers++
+		return maxValue - uint64(c.Last) + 1 + uint64(value)
+	}
+	// el PLC reinició el contador desde 0
+	c.Resets++
+	return uint64(value)
+}
+
+// Peek devuelve el valor absoluto y el consumo pendiente de subir, sin descontarlo
+func (s *Store) Peek(name string) (absolute uint32, delta uint64, ok bool) {
+	if !s.Tracks(name) {
+		return 0, 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[name]
+	if !ok || !c.Valid {
+		return 0, 0, false
+	}
+	return c.Last, c.Pending, true
+}
+
+// Commit descuenta un consumo que ya se subió y persiste el estado. Lo acumulado
+// después del Peek queda pendiente.
+func (s *Store) Commit(name string, delta uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[name]
+	if !ok {
+		return
+	}
+	c.Pending -= min(delta, c.Pending)
+	s.SaveCache()
+}
+
+func (s *Store) Get(name string) (Counter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[name]
+	if !ok {
+		return Counter{}, false
+	}
+	return *c, true
+}
@@ -0,0 +1,143 @@
+package counters
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore(t *testing.T, path string) *Store {
+	t.Helper()
+	return NewStore(path, map[string]bool{"kwh": true, "horas": true})
+}
+
+func TestObserve_Increment(t *testing.T) {
+	s := newTestStore(t, "")
+	s.Observe("kwh", 100, 32)
+	s.Observe("kwh", 150, 32)
+	s.Observe("kwh", 175, 32)
+
+	abs, delta, ok := s.Peek("kwh")
+	if !ok {
+		t.Fatalf("Peek should succeed for tracked counter")
+	}
+	if abs != 175 || delta != 75 {
+		t.Fatalf("got abs=%d delta=%d, want 175 and 75", abs, delta)
+	}
+
+	// sin Commit (upload fallido) el consumo sigue pendiente
+	s.Observe("kwh", 200, 32)
+	if _, delta, _ = s.Peek("kwh"); delta != 100 {
+		t.Fatalf("delta should stay pending until committed, got %d", delta)
+	}
+
+	// lo acumulado entre Peek y Commit no se pierde
+	s.Observe("kwh", 210, 32)
+	s.Commit("kwh", delta)
+	if _, delta, _ = s.Peek("kwh"); delta != 10 {
+		t.Fatalf("delta after commit: got %d, want 10", delta)
+	}
+}
+
+func TestObserve_RolloverAndReset(t *testing.T) {
+	cases := map[string]struct {
+		values    []uint32
+		width     uint
+		delta     uint64
+		resets    int
+		rollovers int
+	}{
+		"16 bit rollover": {[]uint32{65530, 4}, 16, 10, 0, 1},
+		"32 bit rollover": {[]uint32{0xFFFFFFF0, 0x0F}, 32, 0x1F, 0, 1},
+		"reset to zero":   {[]uint32{5000, 0, 20}, 32, 20, 1, 0},
+		"reset mid range": {[]uint32{40000, 100}, 16, 100, 1, 0},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := newTestStore(t, "")
+			for _, v := range tc.values {
+				s.Observe("kwh", v, tc.width)
+			}
+			c, _ := s.Get("kwh")
+			_, delta, _ := s.Peek("kwh")
+			if delta != tc.delta {
+				t.Errorf("delta: got %d want %d", delta, tc.delta)
+			}
+			if c.Resets != tc.resets || c.Rollovers != tc.rollovers {
+				t.Errorf("got resets=%d rollovers=%d, want %d and %d",
+					c.Resets, c.Rollovers, tc.resets, tc.rollovers)
+			}
+		})
+	}
+}
+
+func TestUntrackedIgnored(t *testing.T) {
+	s := newTestStore(t, "")
+	s.Observe("caudal", 10, 16)
+	if _, _, ok := s.Peek("caudal"); ok {
+		t.Fatalf("untracked tag should not be reported")
+	}
+}
+
+func TestPersistenceAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.gob")
+
+	s1 := newTestStore(t, path)
+	s1.Observe("horas", 1000, 32)
+	s1.Observe("horas", 1010, 32)
+	s1.Commit("horas", 10)
+	s1.Observe("horas", 1015, 32)
+
+	// lo observado después del último upload también sobrevive al reinicio
+	s2 := newTestStore(t, path)
+	s2.Observe("horas", 1030, 32)
+	abs, delta, ok := s2.Peek("horas")
+	if !ok || abs != 1030 || delta != 20 {
+		t.Fatalf("got abs=%d delta=%d ok=%t, want 1030, 20, true", abs, delta, ok)
+	}
+}
+
+func TestLoadCache_Truncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.gob")
+	s1 := newTestStore(t, path)
+	s1.Observe("horas", 1000, 32)
+	s1.Observe("horas", 1010, 32)
+
+	// corte de energía a mitad de una escritura sin rename
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b[:len(b)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s2 := newTestStore(t, path)
+	if _, _, ok := s2.Peek("horas"); ok {
+		t.Fatalf("a truncated cache should start over")
+	}
+	s2.Observe("horas", 1020, 32)
+	if abs, delta, ok := s2.Peek("horas"); !ok || abs != 1020 || delta != 0 {
+		t.Fatalf("got abs=%d delta=%d ok=%t, want a new baseline at 1020", abs, delta, ok)
+	}
+
+	// SaveCache no deja el archivo a medias: la copia temporal se descarta
+	if err := os.WriteFile(path+".tmp", b[:len(b)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s3 := newTestStore(t, path)
+	if abs, _, ok := s3.Peek("horas"); !ok || abs != 1020 {
+		t.Fatalf("a leftover tmp file should not affect the cache, got abs=%d ok=%t", abs, ok)
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	names := ParseNames("grupo_energia_kwh, horas_funcionamiento\nagua_m3\n")
+	for _, n := range []string{"grupo_energia_kwh", "horas_funcionamiento", "agua_m3"} {
+		if !names[n] {
+			t.Errorf("missing %s", n)
+		}
+	}
+	if len(names) != 3 {
+		t.Errorf("expected 3 names, got %d", len(names))
+	}
+}
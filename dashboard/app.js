@@ -0,0 +1,247 @@
+// Dashboard local del gateway: usa la API de /api con el token guardado en el
+// navegador. Se refresca cada pocos segundos.
+"use strict";
+
+const REFRESH_MS = 5000;
+const TREND_MS = 60000;
+const $ = (id) => document.getElementById(id);
+
+let token = localStorage.getItem("gatewayToken") || "";
+let devices = [];
+let timer = null;
+
+class Unauthorized extends Error {}
+
+async function api(method, path, body) {
+  const res = await fetch(path, {
+    method,
+    headers: { "Authorization": "Bearer " + token, "Content-Type": "application/json" },
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (res.status === 401) {
+    throw new Unauthorized();
+  }
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(data.error || res.statusText);
+  }
+  return data;
+}
+
+function el(tag, attrs = {}, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs)) {
+    if (k === "class") {
+      e.className = v;
+    } else if (k.startsWith("on")) {
+      e.addEventListener(k.slice(2), v);
+    } else {
+      e.setAttribute(k, v);
+    }
+  }
+  for (const c of children) {
+    e.append(c);
+  }
+  return e;
+}
+
+function formatTime(iso) {
+  return iso ? new Date(iso).toLocaleString() : "";
+}
+
+// confirm muestra el diálogo de confirmación; resuelve true si se acepta
+function confirmAction(text) {
+  const dialog = $("confirm");
+  $("confirm-text").textContent = text;
+  dialog.returnValue = "";
+  dialog.showModal();
+  return new Promise((resolve) => {
+    dialog.addEventListener("close", () => resolve(dialog.returnValue === "ok"), { once: true });
+  });
+}
+
+async function run(action) {
+  try {
+    await action();
+  } catch (err) {
+    if (err instanceof Unauthorized) {
+      showLogin("Token inválido");
+      return;
+    }
+    alert(err.message);
+  }
+  refresh();
+}
+
+function showLogin(message) {
+  clearTimeout(timer);
+  $("app").hidden = true;
+  $("logout").hidden = true;
+  $("login").hidden = false;
+  $("login-error").textContent = message || "";
+}
+
+function renderStatus(st) {
+  $("device").textContent = "Gateway " + st.device;
+  const link = (id, ok, detail) => {
+    const e = $(id);
+    e.className = "link " + (ok ? "ok" : "down");
+    e.title = detail;
+  };
+  link("link-modbus", st.modbus.ok, st.modbus.breaker ? "breaker " + st.modbus.breaker : "");
+  link("link-wialon", st.wialon.ok, st.wialon.outbox ? st.wialon.outbox + " sin subir" : "");
+  $("genset").hidden = !st.genset;
+}
+
+function renderAlarms(alarms) {
+  const list = $("alarms");
+  list.replaceChildren();
+  if (alarms.length === 0) {
+    list.append(el("li", { class: "none" }, "Sin alarmas"));
+    return;
+  }
+  for (const a of alarms) {
+    const li = el("li", { class: a.acked ? "" : "active" },
+      el("span", {}, a.message),
+      el("small", { class: "muted" }, formatTime(a.since)));
+    if (!a.acked) {
+      li.append(el("button", {
+        class: "small",
+        onclick: () => run(() => api("POST", "/api/alarms/" + encodeURIComponent(a.id) + "/ack")),
+      }, "Reconocer"));
+    }
+    list.append(li);
+  }
+}
+
+function renderDevices(values) {
+  const byTag = new Map(values.map((v) => [v.device + "/" + v.tag, v]));
+  const container = $("devices");
+  container.replaceChildren();
+  for (const d of devices) {
+    const rows = d.tags.map((t) => {
+      const v = byTag.get(d.id + "/" + t.name);
+      const value = v ? String(v.value) : "–";
+      const row = el("tr", {},
+        el("td", {}, t.name, " ", el("span", { class: "quality" }, v && v.quality !== "good" ? v.quality : "")),
+        el("td", { class: "value", title: v ? formatTime(v.timestamp) : "" }, value));
+      const actions = el("td", {});
+      if (t.writable) {
+        const on = v && v.value !== 0;
+        actions.append(el("button", {
+          class: "small" + (on ? " danger" : ""),
+          onclick: async () => {
+            const target = !on;
+            if (await confirmAction(`¿${target ? "Encender" : "Apagar"} ${t.name}?`)) {
+              run(() => api("POST", "/api/commands", { tag: t.name, value: target }));
+            }
+          },
+        }, on ? "Apagar" : "Encender"));
+      }
+      row.append(actions);
+      return row;
+    });
+    container.append(el("section", {}, el("h2", {}, d.id), el("table", {}, ...rows)));
+  }
+}
+
+async function renderTrend() {
+  const tag = $("trend-tag").value;
+  const svg = $("trend");
+  if (!tag) {
+    return;
+  }
+  const hours = Number($("trend-range").value);
+  const from = new Date(Date.now() - hours * 3600e3).toISOString();
+  const step = Math.max(1, Math.round(hours * 60 / 120)) + "m";
+  const points = await api("GET", `/api/history?tag=${encodeURIComponent(tag)}&from=${from}&step=${step}`);
+  svg.replaceChildren();
+  if (points.length === 0) {
+    $("trend-info").textContent = "Sin datos";
+    return;
+  }
+  const t0 = Date.parse(from), t1 = Date.now();
+  const values = points.map((p) => p.value);
+  let lo = Math.min(...values), hi = Math.max(...values);
+  if (lo === hi) {
+    lo -= 1;
+    hi += 1;
+  }
+  const coords = points.map((p) => {
+    const x = (Date.parse(p.time) - t0) / (t1 - t0) * 600;
+    const y = 195 - (p.value - lo) / (hi - lo) * 190;
+    return x.toFixed(1) + "," + y.toFixed(1);
+  });
+  const line = document.createElementNS("http://www.w3.org/2000/svg", "polyline");
+  line.setAttribute("points", coords.join(" "));
+  svg.append(line);
+  $("trend-info").textContent = `mín ${Math.min(...values)} · máx ${Math.max(...values)} · último ${values[values.length - 1]}`;
+}
+
+async function refresh() {
+  clearTimeout(timer);
+  try {
+    const [st, values, alarms] = await Promise.all([
+      api("GET", "/api/status"), api("GET", "/api/values"), api("GET", "/api/alarms"),
+    ]);
+    renderStatus(st);
+    renderAlarms(alarms);
+    renderDevices(values);
+  } catch (err) {
+    if (err instanceof Unauthorized) {
+      showLogin("Token inválido");
+      return;
+    }
+    $("link-modbus").className = $("link-wialon").className = "link";
+  }
+  timer = setTimeout(refresh, REFRESH_MS);
+}
+
+async function start() {
+  try {
+    devices = await api("GET", "/api/devices");
+  } catch (err) {
+    showLogin(err instanceof Unauthorized ? (token ? "Token inválido" : "") : err.message);
+    return;
+  }
+  $("login").hidden = true;
+  $("app").hidden = false;
+  $("logout").hidden = false;
+  const select = $("trend-tag");
+  select.replaceChildren(...devices.flatMap((d) => d.tags.map((t) => el("option", { value: t.name }, t.name))));
+  renderTrend().catch(() => {});
+  refresh();
+}
+
+setInterval(() => {
+  if (!$("app").hidden) {
+    renderTrend().catch(() => {});
+  }
+}, TREND_MS);
+
+$("login").addEventListener("submit", (e) => {
+  e.preventDefault();
+  token = $("token").value;
+  localStorage.setItem("gatewayToken", token);
+  start();
+});
+
+$("logout").addEventListener("click", () => {
+  token = "";
+  localStorage.removeItem("gatewayToken");
+  showLogin();
+});
+
+for (const b of document.querySelectorAll("[data-genset]")) {
+  b.addEventListener("click", async () => {
+    const action = b.dataset.genset;
+    if (await confirmAction(action === "start" ? "¿Arrancar el grupo electrógeno?" : "¿Parar el grupo electrógeno?")) {
+      run(() => api("POST", "/api/genset", { action }));
+    }
+  });
+}
+
+$("trend-tag").addEventListener("change", () => renderTrend().catch(() => {}));
+$("trend-range").addEventListener("change", () => renderTrend().catch(() => {}));
+
+start();
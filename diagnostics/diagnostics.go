@@ -0,0 +1,207 @@
+package diagnostics
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/gob"
+	"fmt"
+	"mt-plc-control/modbusClient"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Grupos de diagnóstico; cada uno se activa por separado en DIAGNOSTICS
+const (
+	Uptime   = "uptime"   // gw_uptime_s
+	Restarts = "restarts" // gw_restarts
+	Modbus   = "modbus"   // mb_requests, mb_errors, mb_timeouts, mb_exceptions, mb_p50_ms...
+	Wialon   = "wialon"   // wl_reconnects, wl_outbox
+	CPUTemp  = "cpu_temp" // cpu_temp en °C
+	Disk     = "disk"     // disk_free_mb
+	Memory   = "memory"   // mem_free_mb
+	Config   = "config"   // cfg_hash
+	Version  = "version"  // gw_version
+)
+
+var groups = []string{Uptime, Restarts, Modbus, Wialon, CPUTemp, Disk, Memory, Config, Version}
+
+// ParseGroups lee DIAGNOSTICS: grupos separados por coma o salto de línea, o "all"
+func ParseGroups(list string) (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+		case field == "all":
+			for _, g := range groups {
+				enabled[g] = true
+			}
+		case slices.Contains(groups, field):
+			enabled[field] = true
+		default:
+			return nil, fmt.Errorf("unknown diagnostics group %q", field)
+		}
+	}
+	return enabled, nil
+}
+
+// Collector arma los params de diagnóstico que se suben con cada #D#.
+// Los valores que no se pueden leer (ej. sin sensor de temperatura) se omiten.
+type Collector struct {
+	Enabled    map[string]bool
+	Started    time.Time
+	Restarts   int
+	Modbus     func() modbusClient.Stats
+	Wialon     func(now time.Time) (reconnects int64, outbox int)
+	DiskPath   string // sistema de archivos a medir, ej. el del histórico
+	ConfigHash string
+	Version    string
+
+	ThermalPath string // vacío usa /sys/class/thermal/thermal_zone0/temp
+	MeminfoPath string // vacío usa /proc/meminfo
+}
+
+// Params devuelve los params habilitados, "" si no hay ninguno
+func (c *Collector) Params(now time.Time) string {
+	if c == nil {
+		return ""
+	}
+	params := make([]string, 0)
+	add := func(format string, args ...any) {
+		params = append(params, fmt.Sprintf(format, args...))
+	}
+	if c.Enabled[Uptime] {
+		add("gw_uptime_s:1:%d", int64(now.Sub(c.Started)/time.Second))
+	}
+	if c.Enabled[Restarts] {
+		add("gw_restarts:1:%d", c.Restarts)
+	}
+	if c.Enabled[Modbus] && c.Modbus != nil {
+		st := c.Modbus()
+		add("mb_requests:1:%d", st.Requests)
+		add("mb_errors:1:%d", st.Errors)
+		add("mb_timeouts:1:%d", st.Timeouts)
+		add("mb_exceptions:1:%d", st.Exceptions)
+		add("mb_p50_ms:1:%d", st.P50.Milliseconds())
+		add("mb_p95_ms:1:%d", st.P95.Milliseconds())
+		add("mb_p99_ms:1:%d", st.P99.Milliseconds())
+	}
+	if c.Enabled[Wialon] && c.Wialon != nil {
+		reconnects, outbox := c.Wialon(now)
+		add("wl_reconnects:1:%d", reconnects)
+		add("wl_outbox:1:%d", outbox)
+	}
+	if c.Enabled[CPUTemp] {
+		if t, err := c.cpuTemp(); err == nil {
+			add("cpu_temp:2:%.1f", t)
+		}
+	}
+	if c.Enabled[Disk] {
+		if free, err := diskFree(cmp.Or(c.DiskPath, ".")); err == nil {
+			add("disk_free_mb:1:%d", free>>20)
+		}
+	}
+	if c.Enabled[Memory] {
+		if free, err := c.memAvailable(); err == nil {
+			add("mem_free_mb:1:%d", free>>20)
+		}
+	}
+	if c.Enabled[Config] && c.ConfigHash != "" {
+		add("cfg_hash:3:%s", textParam(c.ConfigHash))
+	}
+	if c.Enabled[Version] && c.Version != "" {
+		add("gw_version:3:%s", textParam(c.Version))
+	}
+	return strings.Join(params, ",")
+}
+
+// cpuTemp lee la temperatura del SoC (en milésimas de grado en Linux/Raspberry Pi)
+func (c *Collector) cpuTemp() (float64, error) {
+	raw, err := os.ReadFile(cmp.Or(c.ThermalPath, "/sys/class/thermal/thermal_zone0/temp"))
+	if err != nil {
+		return 0, err
+	}
+	milli, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return 0, fmt.Errorf("cpu temperature: %w", err)
+	}
+	return float64(milli) / 1000, nil
+}
+
+// memAvailable lee MemAvailable de /proc/meminfo, en bytes
+func (c *Collector) memAvailable() (uint64, error) {
+	f, err := os.Open(cmp.Or(c.MeminfoPath, "/proc/meminfo"))
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("meminfo: %w", err)
+			}
+			return kb << 10, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("meminfo: no MemAvailable")
+}
+
+// CountStart suma un arranque al contador guardado en diskPath y devuelve
+// cuántos reinicios hubo (arranques menos el primero).
+func CountStart(diskPath string) int {
+	var starts int
+	if f, err := os.Open(diskPath); err == nil {
+		_ = gob.NewDecoder(f).Decode(&starts)
+		_ = f.Close()
+	}
+	starts++
+	if f, err := os.Create(diskPath); err == nil {
+		_ = gob.NewEncoder(f).Encode(starts)
+		_ = f.Close()
+	}
+	return starts - 1
+}
+
+// BinaryVersion devuelve la versión del módulo o, en builds de desarrollo,
+// la revisión de git con la que se compiló.
+func BinaryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	revision, dirty := "", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value[:min(len(s.Value), 12)]
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if revision != "" && dirty {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// textParam quita los separadores del protocolo de un valor de texto
+func textParam(v string) string {
+	return strings.NewReplacer(",", "_", ";", "_", ":", "_", "#", "_", "|", "_").Replace(v)
+}
@@ -0,0 +1,84 @@
+package diagnostics
+
+import (
+	"mt-plc-control/modbusClient"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGroups(t *testing.T) {
+	enabled, err := ParseGroups("uptime, modbus\ncpu_temp")
+	if err != nil || len(enabled) != 3 || !enabled[Modbus] {
+		t.Errorf("got %v %v", enabled, err)
+	}
+	if enabled, _ := ParseGroups("all"); len(enabled) != len(groups) {
+		t.Errorf("all: got %v", enabled)
+	}
+	if _, err := ParseGroups("uptime,bogus"); err == nil {
+		t.Errorf("unknown group should fail")
+	}
+}
+
+func TestCollector_Params(t *testing.T) {
+	dir := t.TempDir()
+	thermal := filepath.Join(dir, "temp")
+	meminfo := filepath.Join(dir, "meminfo")
+	if err := os.WriteFile(thermal, []byte("48312\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(meminfo, []byte("MemTotal:  948304 kB\nMemAvailable:  524288 kB\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	c := &Collector{
+		Started:  t0,
+		Restarts: 3,
+		Modbus: func() modbusClient.Stats {
+			return modbusClient.Stats{Counts: modbusClient.Counts{Requests: 40, Errors: 2, Timeouts: 1, Exceptions: 1}, P50: 12 * time.Millisecond, P95: 30 * time.Millisecond, P99: 45 * time.Millisecond}
+		},
+		Wialon: func(time.Time) (int64, int) {
+			return 2, 17
+		},
+		DiskPath:    dir,
+		ConfigHash:  "a1b2c3d4",
+		Version:     "v1.4.0,rc:1",
+		ThermalPath: thermal,
+		MeminfoPath: meminfo,
+	}
+
+	c.Enabled, _ = ParseGroups("uptime,restarts,modbus,wialon,cpu_temp,memory,config,version")
+	want := "gw_uptime_s:1:90,gw_restarts:1:3," +
+		"mb_requests:1:40,mb_errors:1:2,mb_timeouts:1:1,mb_exceptions:1:1,mb_p50_ms:1:12,mb_p95_ms:1:30,mb_p99_ms:1:45," +
+		"wl_reconnects:1:2,wl_outbox:1:17,cpu_temp:2:48.3,mem_free_mb:1:512,cfg_hash:3:a1b2c3d4,gw_version:3:v1.4.0_rc_1"
+	if got := c.Params(t0.Add(90 * time.Second)); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	c.Enabled, _ = ParseGroups("disk")
+	if got := c.Params(t0); !strings.HasPrefix(got, "disk_free_mb:1:") || strings.Contains(got, ",") {
+		t.Errorf("only disk: got %s", got)
+	}
+
+	// un valor que no se puede leer se omite
+	c.Enabled, _ = ParseGroups("cpu_temp,uptime")
+	c.ThermalPath = filepath.Join(dir, "missing")
+	if got := c.Params(t0); got != "gw_uptime_s:1:0" {
+		t.Errorf("missing sensor: got %s", got)
+	}
+	var none *Collector
+	if got := none.Params(t0); got != "" {
+		t.Errorf("no collector: got %s", got)
+	}
+}
+
+func TestCountStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diagnostics.gob")
+	for want := 0; want < 3; want++ {
+		if got := CountStart(path); got != want {
+			t.Errorf("restarts: got %d, want %d", got, want)
+		}
+	}
+}
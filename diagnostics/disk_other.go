@@ -0,0 +1,9 @@
+//go:build !(linux || darwin)
+
+package diagnostics
+
+import "errors"
+
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("disk usage not supported on this platform")
+}
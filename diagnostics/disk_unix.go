@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package diagnostics
+
+import "syscall"
+
+// diskFree devuelve los bytes libres para el usuario en el sistema de archivos de path
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
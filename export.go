@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mt-plc-control/history"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var exportTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// runExport implementa el subcomando `export`:
+//
+//	bin export -from 2026-03-01 -to 2026-03-02 -tags q1,presion -format csv -layout wide
+func runExport(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dir := fs.String("dir", os.Getenv("HISTORY_DIR"), "Directorio del histórico")
+	fromStr := fs.String("from", "", "Inicio (RFC3339, 2006-01-02 o 2006-01-02 15:04)")
+	toStr := fs.String("to", "", "Fin, excluido (por defecto ahora)")
+	tagsStr := fs.String("tags", "", "Tags separados por coma (por defecto todos)")
+	format := fs.String("format", "csv", "csv o json (JSON lines)")
+	layout := fs.String("layout", "long", "wide (una columna por tag) o long")
+	resample := fs.Duration("resample", 0, "Intervalo de re-muestreo, ej. 5m")
+	tzName := fs.String("tz", "UTC", "Zona horaria de entrada y salida, ej. America/Lima")
+	outPath := fs.String("o", "", "Archivo de salida (por defecto stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		*dir = "history"
+	}
+	loc, err := time.LoadLocation(*tzName)
+	if err != nil {
+		return fmt.Errorf("invalid tz: %w", err)
+	}
+	from, err := parseExportTime(*fromStr, loc)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	to := time.Now()
+	if *toStr != "" {
+		if to, err = parseExportTime(*toStr, loc); err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("invalid -format %q", *format)
+	}
+	if *layout != "wide" && *layout != "long" {
+		return fmt.Errorf("invalid -layout %q", *layout)
+	}
+	tags := make([]string, 0)
+	for _, t := range strings.Split(*tagsStr, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+
+	// Open crea el directorio: un -dir mal escrito daría una exportación vacía
+	if fi, err := os.Stat(*dir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("no history at %s", *dir)
+	}
+	hist, err := history.Open(*dir, history.DefaultOptions())
+	if err != nil {
+		return err
+	}
+	points, err := hist.Query(tags, from, to)
+	if err != nil {
+		return err
+	}
+	points = history.Resample(points, *resample, loc)
+
+	out := stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	if *layout == "wide" {
+		err = writeWide(w, points, tags, *format, loc)
+	} else {
+		err = writeLong(w, points, *format, loc)
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func parseExportTime(s string, loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("empty time")
+	}
+	for _, layout := range exportTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown format %q", s)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func writeLong(w io.Writer, points []history.Point, format string, loc *time.Location) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		for _, p := range points {
+			row := struct {
+				Time  string  `json:"time"`
+				Tag   string  `json:"tag"`
+				Value float64 `json:"value"`
+			}{p.Time.In(loc).Format(time.RFC3339), p.Tag, p.Value}
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"time", "tag", "value"})
+	for _, p := range points {
+		_ = cw.Write([]string{p.Time.In(loc).Format(time.RFC3339), p.Tag, formatValue(p.Value)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeWide agrupa los puntos con la misma hora en una fila, una columna por tag
+func writeWide(w io.Writer, points []history.Point, tags []string, format string, loc *time.Location) error {
+	if len(tags) == 0 {
+		seen := make(map[string]bool)
+		for _, p := range points {
+			if !seen[p.Tag] {
+				seen[p.Tag] = true
+				tags = append(tags, p.Tag)
+			}
+		}
+	}
+	column := make(map[string]int, len(tags))
+	for i, t := range tags {
+		column[t] = i
+	}
+
+	cw := csv.NewWriter(w)
+	enc := json.NewEncoder(w)
+	if format == "csv" {
+		_ = cw.Write(append([]string{"time"}, tags...))
+	}
+	writeRow := func(t time.Time, values []*float64) error {
+		ts := t.In(loc).Format(time.RFC3339)
+		if format == "json" {
+			row := make(map[string]any, len(values)+1)
+			row["time"] = ts
+			for i, v := range values {
+				if v != nil {
+					row[tags[i]] = *v
+				}
+			}
+			return enc.Encode(row)
+		}
+		record := make([]string, len(values)+1)
+		record[0] = ts
+		for i, v := range values {
+			if v != nil {
+				record[i+1] = formatValue(*v)
+			}
+		}
+		return cw.Write(record)
+	}
+
+	var rowTime time.Time
+	var values []*float64
+	for _, p := range points {
+		if values == nil || !p.Time.Equal(rowTime) {
+			if values != nil {
+				if err := writeRow(rowTime, values); err != nil {
+					return err
+				}
+			}
+			rowTime = p.Time
+			values = make([]*float64, len(tags))
+		}
+		v := p.Value
+		values[column[p.Tag]] = &v
+	}
+	if values != nil {
+		if err := writeRow(rowTime, values); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"mt-plc-control/history"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunExport(t *testing.T) {
+	dir := t.TempDir()
+	hist, err := history.Open(dir, history.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	err = hist.Append([]history.Point{
+		{Time: t0, Tag: "q1", Value: 1},
+		{Time: t0, Tag: "presion", Value: 4.5},
+		{Time: t0.Add(30 * time.Second), Tag: "q1", Value: 0},
+		{Time: t0.Add(90 * time.Second), Tag: "presion", Value: 4.75},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = hist.Close()
+
+	base := []string{"-dir", dir, "-from", "2026-03-01", "-to", "2026-03-02"}
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"long csv": {
+			[]string{"-tags", "q1"},
+			"time,tag,value\n2026-03-01T12:00:00Z,q1,1\n2026-03-01T12:00:30Z,q1,0\n",
+		},
+		"wide csv": {
+			[]string{"-layout", "wide", "-tags", "q1,presion"},
+			"time,q1,presion\n2026-03-01T12:00:00Z,1,4.5\n2026-03-01T12:00:30Z,0,\n2026-03-01T12:01:30Z,,4.75\n",
+		},
+		"wide json resampled with tz": {
+			[]string{"-layout", "wide", "-format", "json", "-resample", "1m", "-tz", "America/Lima", "-tags", "q1,presion"},
+			"{\"presion\":4.5,\"q1\":0,\"time\":\"2026-03-01T07:00:00-05:00\"}\n{\"presion\":4.75,\"time\":\"2026-03-01T07:01:00-05:00\"}\n",
+		},
+		"long json": {
+			[]string{"-format", "json", "-tags", "presion", "-from", "2026-03-01 12:01"},
+			"{\"time\":\"2026-03-01T12:01:30Z\",\"tag\":\"presion\",\"value\":4.75}\n",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := runExport(append(append([]string{}, base...), tc.args...), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", out.String(), tc.want)
+			}
+		})
+	}
+
+	if err := runExport(append(base, "-format", "xml"), &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "format") {
+		t.Errorf("expected format error, got %v", err)
+	}
+	missing := filepath.Join(dir, "typo")
+	if err := runExport([]string{"-dir", missing, "-from", "2026-03-01", "-to", "2026-03-02"}, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "no history") {
+		t.Errorf("expected missing history error, got %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("export should not create %s", missing)
+	}
+}
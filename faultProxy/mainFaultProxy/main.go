@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"mt-plc-control/faultProxy"
+	"os"
+	"os/signal"
+)
+
+// Proxy con fallas entre el gateway y el PLC o Wialon, para probar enlaces celulares.
+// Ej: mainFaultProxy -listen :5021 -target 192.168.1.10:502 -faults "down pass 5; down reset 3" -loop
+func main() {
+	listen := flag.String("listen", "0.0.0.0:5021", "Dirección de escucha")
+	target := flag.String("target", "127.0.0.1:5020", "Destino (PLC o servidor Wialon)")
+	script := flag.String("script", "", "Archivo con el guion de fallas")
+	faults := flag.String("faults", "", "Guion de fallas en línea, pasos separados por ';'")
+	loop := flag.Bool("loop", false, "Repetir el guion al terminar")
+	latencyUp := flag.Duration("latency-up", 0, "Retraso fijo gateway -> destino")
+	latencyDown := flag.Duration("latency-down", 0, "Retraso fijo destino -> gateway")
+	flag.Parse()
+
+	text := *faults
+	if *script != "" {
+		b, err := os.ReadFile(*script)
+		if err != nil {
+			log.Fatal(err)
+		}
+		text = string(b) + "\n" + text
+	}
+	schedule, err := faultProxy.ParseSchedule(text)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := faultProxy.New(*target)
+	p.SetLoop(*loop)
+	p.Logf = log.Printf
+	p.Add(schedule...)
+	p.SetLatency(faultProxy.Upstream, *latencyUp)
+	p.SetLatency(faultProxy.Downstream, *latencyDown)
+	if err := p.Start(*listen); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Fault proxy %s -> %s, %d steps", p.Addr(), *target, len(schedule))
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+	p.Close()
+}
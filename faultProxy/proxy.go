@@ -0,0 +1,268 @@
+package faultProxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+type Direction int
+
+const (
+	Upstream   Direction = iota // gateway -> PLC/Wialon
+	Downstream                  // PLC/Wialon -> gateway
+)
+
+func (d Direction) String() string {
+	if d == Upstream {
+		return "up"
+	}
+	return "down"
+}
+
+type Kind int
+
+const (
+	Pass      Kind = iota // deja pasar Count lecturas sin cambios
+	Delay                 // retrasa la lectura
+	Truncate              // deja pasar Bytes bytes y descarta el resto
+	Reset                 // deja pasar Bytes bytes y corta con RST
+	Duplicate             // reenvía la lectura dos veces
+	Blackhole             // socket medio abierto: desde ahí no pasa nada en ningún sentido
+)
+
+// Fault es un paso del guion; cada paso se aplica a una lectura (chunk) del socket
+// en su sentido. Una respuesta Modbus o una línea Wialon suele llegar en una sola lectura.
+type Fault struct {
+	Dir   Direction
+	Kind  Kind
+	Delay time.Duration
+	Bytes int
+	Count int
+}
+
+// Proxy reenvía conexiones TCP a Target aplicando un guion de fallas
+type Proxy struct {
+	Target string
+	Logf   func(format string, args ...any)
+
+	mu       sync.Mutex
+	ln       net.Listener
+	links    map[*link]bool
+	schedule [2][]Fault
+	script   [2][]Fault // copia para Loop
+	latency  [2]time.Duration
+	loop     bool
+	wg       sync.WaitGroup
+}
+
+type link struct {
+	client, server net.Conn
+	mu             sync.Mutex
+	blackhole      bool
+}
+
+func New(target string) *Proxy {
+	return &Proxy{Target: target, links: make(map[*link]bool)}
+}
+
+func (p *Proxy) Start(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("fault proxy listen: %w", err)
+	}
+	p.mu.Lock()
+	p.ln = ln
+	p.mu.Unlock()
+	p.wg.Add(1)
+	go p.acceptLoop(ln)
+	return nil
+}
+
+func (p *Proxy) Addr() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ln == nil {
+		return ""
+	}
+	return p.ln.Addr().String()
+}
+
+func (p *Proxy) Close() {
+	p.mu.Lock()
+	if p.ln != nil {
+		_ = p.ln.Close()
+	}
+	for l := range p.links {
+		l.close(false)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// Add agrega pasos al final del guion de su sentido
+func (p *Proxy) Add(faults ...Fault) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range faults {
+		p.schedule[f.Dir] = append(p.schedule[f.Dir], f)
+		p.script[f.Dir] = append(p.script[f.Dir], f)
+	}
+}
+
+// Pending devuelve cuántos pasos quedan por aplicar
+func (p *Proxy) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.schedule[Upstream]) + len(p.schedule[Downstream])
+}
+
+// SetLatency retrasa todas las lecturas en un sentido, además del guion
+func (p *Proxy) SetLatency(dir Direction, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latency[dir] = d
+}
+
+// SetLoop hace que el guion vuelva a empezar al terminar
+func (p *Proxy) SetLoop(loop bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loop = loop
+}
+
+// ResetConnections corta con RST las conexiones abiertas
+func (p *Proxy) ResetConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for l := range p.links {
+		l.close(true)
+	}
+}
+
+func (p *Proxy) acceptLoop(ln net.Listener) {
+	defer p.wg.Done()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s, err := net.DialTimeout("tcp", p.Target, 5*time.Second)
+		if err != nil {
+			p.logf("fault proxy: dialing %s: %v", p.Target, err)
+			_ = c.Close()
+			continue
+		}
+		l := &link{client: c, server: s}
+		p.mu.Lock()
+		p.links[l] = true
+		p.mu.Unlock()
+
+		p.wg.Add(2)
+		go p.pipe(l, Upstream)
+		go p.pipe(l, Downstream)
+	}
+}
+
+// pipe copia en un sentido aplicando el guion a cada lectura
+func (p *Proxy) pipe(l *link, dir Direction) {
+	defer p.wg.Done()
+	defer func() {
+		p.mu.Lock()
+		delete(p.links, l)
+		p.mu.Unlock()
+		l.close(false)
+	}()
+	src, dst := l.client, l.server
+	if dir == Downstream {
+		src, dst = l.server, l.client
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if !p.forward(l, dir, buf[:n], dst) {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// forward aplica el próximo paso del guion; devuelve false si la conexión se cortó
+func (p *Proxy) forward(l *link, dir Direction, chunk []byte, dst net.Conn) bool {
+	l.mu.Lock()
+	blackhole := l.blackhole
+	l.mu.Unlock()
+	if blackhole {
+		return true
+	}
+
+	f, latency := p.next(dir)
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if f.Kind != Pass {
+		p.logf("fault proxy: %s %s on %d bytes", dir, kindNames[f.Kind], len(chunk))
+	}
+	switch f.Kind {
+	case Delay:
+		time.Sleep(f.Delay)
+	case Truncate:
+		chunk = chunk[:min(f.Bytes, len(chunk))]
+	case Reset:
+		if f.Bytes > 0 {
+			_, _ = dst.Write(chunk[:min(f.Bytes, len(chunk))])
+		}
+		l.close(true)
+		return false
+	case Duplicate:
+		chunk = append(append([]byte{}, chunk...), chunk...)
+	case Blackhole:
+		l.mu.Lock()
+		l.blackhole = true
+		l.mu.Unlock()
+		return true
+	}
+	_, err := dst.Write(chunk)
+	return err == nil
+}
+
+// next saca el próximo paso del guion de dir (Pass si no hay)
+func (p *Proxy) next(dir Direction) (Fault, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.schedule[dir]) == 0 && p.loop {
+		p.schedule[dir] = append([]Fault{}, p.script[dir]...)
+	}
+	if len(p.schedule[dir]) == 0 {
+		return Fault{Dir: dir, Kind: Pass}, p.latency[dir]
+	}
+	f := p.schedule[dir][0]
+	if f.Kind == Pass && f.Count > 1 {
+		p.schedule[dir][0].Count--
+	} else {
+		p.schedule[dir] = p.schedule[dir][1:]
+	}
+	return f, p.latency[dir]
+}
+
+func (p *Proxy) logf(format string, args ...any) {
+	if p.Logf != nil {
+		p.Logf(format, args...)
+	}
+}
+
+// close cierra ambos lados; con rst se descarta lo pendiente y se envía RST
+func (l *link) close(rst bool) {
+	for _, c := range []net.Conn{l.client, l.server} {
+		if tcp, ok := c.(*net.TCPConn); ok && rst {
+			_ = tcp.SetLinger(0)
+		}
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,190 @@
+package faultProxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startEcho levanta un servidor que devuelve cada línea recibida
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = c.Close()
+				}()
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if _, err := c.Write([]byte(line)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func startProxy(t *testing.T, faults ...Fault) (*Proxy, net.Conn) {
+	t.Helper()
+	p := New(startEcho(t))
+	p.Add(faults...)
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	c, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return p, c
+}
+
+// roundTrip envía line y devuelve lo que llegue hasta timeout
+func roundTrip(c net.Conn, line string, timeout time.Duration) (string, error) {
+	if _, err := c.Write([]byte(line)); err != nil {
+		return "", err
+	}
+	_ = c.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 256)
+	got := ""
+	for {
+		n, err := c.Read(buf)
+		got += string(buf[:n])
+		if err != nil {
+			return got, err
+		}
+		if strings.HasSuffix(got, "\n") {
+			// puede venir un duplicado: esperar un poco más
+			_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func TestParseSchedule(t *testing.T) {
+	faults, err := ParseSchedule(`
+		down pass 3   # tres respuestas bien
+		down delay 2s; down truncate 5
+		up reset
+		down duplicate
+		up blackhole`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Fault{
+		{Dir: Downstream, Kind: Pass, Count: 3},
+		{Dir: Downstream, Kind: Delay, Delay: 2 * time.Second},
+		{Dir: Downstream, Kind: Truncate, Bytes: 5},
+		{Dir: Upstream, Kind: Reset},
+		{Dir: Downstream, Kind: Duplicate},
+		{Dir: Upstream, Kind: Blackhole},
+	}
+	if len(faults) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(faults), len(want), faults)
+	}
+	for i := range want {
+		if faults[i] != want[i] {
+			t.Errorf("step %d: got %+v want %+v", i, faults[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"sideways pass", "down explode", "down delay soon", "down duplicate 2", "down"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
+
+func TestProxy_Faults(t *testing.T) {
+	_, c := startProxy(t,
+		Fault{Dir: Downstream, Kind: Pass, Count: 1},
+		Fault{Dir: Downstream, Kind: Truncate, Bytes: 3},
+		Fault{Dir: Downstream, Kind: Duplicate},
+		Fault{Dir: Downstream, Kind: Delay, Delay: 200 * time.Millisecond},
+	)
+
+	if got, _ := roundTrip(c, "#AD#1\r\n", time.Second); got != "#AD#1\r\n" {
+		t.Errorf("pass: got %q", got)
+	}
+	if got, err := roundTrip(c, "#AD#1\r\n", 200*time.Millisecond); got != "#AD" || !isTimeout(err) {
+		t.Errorf("truncate: got %q %v", got, err)
+	}
+	if got, _ := roundTrip(c, "#AP#\r\n", time.Second); got != "#AP#\r\n#AP#\r\n" {
+		t.Errorf("duplicate: got %q", got)
+	}
+	start := time.Now()
+	if got, _ := roundTrip(c, "#AP#\r\n", time.Second); got != "#AP#\r\n" || time.Since(start) < 200*time.Millisecond {
+		t.Errorf("delay: got %q after %s", got, time.Since(start))
+	}
+}
+
+func TestProxy_ResetMidResponse(t *testing.T) {
+	_, c := startProxy(t, Fault{Dir: Downstream, Kind: Reset, Bytes: 2})
+
+	got, err := roundTrip(c, "#AL#1\r\n", time.Second)
+	if got != "#A" {
+		t.Errorf("should forward the first bytes before the reset, got %q", got)
+	}
+	if !errors.Is(err, syscall.ECONNRESET) && !errors.Is(err, io.EOF) {
+		t.Errorf("should end with a reset, got %v", err)
+	}
+}
+
+func TestProxy_Blackhole(t *testing.T) {
+	p, c := startProxy(t, Fault{Dir: Upstream, Kind: Blackhole})
+
+	if got, err := roundTrip(c, "#P#\r\n", 300*time.Millisecond); got != "" || !isTimeout(err) {
+		t.Errorf("half-open link should swallow everything, got %q %v", got, err)
+	}
+	// el socket sigue abierto: las escrituras no fallan
+	if _, err := c.Write([]byte("#P#\r\n")); err != nil {
+		t.Errorf("write on half-open link: %v", err)
+	}
+	if p.Pending() != 0 {
+		t.Errorf("schedule should be consumed")
+	}
+}
+
+func TestProxy_Loop(t *testing.T) {
+	p, c := startProxy(t, Fault{Dir: Downstream, Kind: Pass}, Fault{Dir: Downstream, Kind: Truncate, Bytes: 1})
+	p.SetLoop(true)
+
+	for i := 0; i < 2; i++ {
+		if got, _ := roundTrip(c, "ok\n", time.Second); got != "ok\n" {
+			t.Errorf("round %d pass: got %q", i, got)
+		}
+		if got, _ := roundTrip(c, "ok\n", 200*time.Millisecond); got != "o" {
+			t.Errorf("round %d truncate: got %q", i, got)
+		}
+	}
+}
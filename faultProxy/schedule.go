@@ -0,0 +1,97 @@
+package faultProxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var kindNames = map[Kind]string{
+	Pass:      "pass",
+	Delay:     "delay",
+	Truncate:  "truncate",
+	Reset:     "reset",
+	Duplicate: "duplicate",
+	Blackhole: "blackhole",
+}
+
+// ParseSchedule lee un guion, un paso por línea (o separados por ';'):
+//
+//	<up|down> pass [n]
+//	<up|down> delay <duración>
+//	<up|down> truncate <bytes>
+//	<up|down> reset [bytes]
+//	<up|down> duplicate
+//	<up|down> blackhole
+//
+// Lo que sigue a '#' es comentario.
+func ParseSchedule(script string) ([]Fault, error) {
+	faults := make([]Fault, 0)
+	lines := strings.FieldsFunc(script, func(r rune) bool { return r == '\n' || r == ';' })
+	for _, line := range lines {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("malformed step %q", strings.TrimSpace(line))
+		}
+		f := Fault{}
+		switch fields[0] {
+		case "up":
+			f.Dir = Upstream
+		case "down":
+			f.Dir = Downstream
+		default:
+			return nil, fmt.Errorf("unknown direction %q in %q", fields[0], strings.TrimSpace(line))
+		}
+		kind, ok := kindByName(fields[1])
+		if !ok {
+			return nil, fmt.Errorf("unknown fault %q in %q", fields[1], strings.TrimSpace(line))
+		}
+		f.Kind = kind
+		arg := ""
+		if len(fields) == 3 {
+			arg = fields[2]
+		}
+
+		var err error
+		switch kind {
+		case Pass:
+			f.Count = 1
+			if arg != "" {
+				f.Count, err = strconv.Atoi(arg)
+			}
+		case Delay:
+			f.Delay, err = time.ParseDuration(arg)
+		case Truncate:
+			f.Bytes, err = strconv.Atoi(arg)
+		case Reset:
+			if arg != "" {
+				f.Bytes, err = strconv.Atoi(arg)
+			}
+		default:
+			if arg != "" {
+				err = fmt.Errorf("unexpected argument")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("step %q: %w", strings.TrimSpace(line), err)
+		}
+		faults = append(faults, f)
+	}
+	return faults, nil
+}
+
+func kindByName(name string) (Kind, bool) {
+	for k, n := range kindNames {
+		if n == name {
+			return k, true
+		}
+	}
+	return 0, false
+}
@@ -4,7 +4,6 @@ go 1.25
 
 require (
 	github.com/goburrow/modbus v0.1.0
+	github.com/goburrow/serial v0.1.0
 	github.com/joho/godotenv v1.5.1
 )
-
-require github.com/goburrow/serial v0.1.0 // indirect
@@ -0,0 +1,990 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mt-plc-control/alarms"
+	"mt-plc-control/clock"
+	"mt-plc-control/diagnostics"
+	"mt-plc-control/history"
+	"mt-plc-control/modbusClient"
+	"mt-plc-control/modbusServer"
+	"mt-plc-control/mqttClient"
+	"mt-plc-control/quality"
+	"mt-plc-control/sparkplug"
+	"mt-plc-control/wailonServer"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// harness corre el gateway completo (run + pollLoop + WailonConnection) contra
+// un LOGO! 8 simulado y un servidor Wialon IPS local, con reloj controlado.
+type harness struct {
+	t     *testing.T
+	sim   *modbusServer.Server
+	ips   *wailonServer.IPSServer
+	clock *clock.Fake
+	done  chan struct{}
+	polls int // escaneos disparados
+	next  int // índice del próximo paquete a revisar
+	stop  func() error
+}
+
+const harnessPeriod = 30 * time.Second
+
+var harnessStart = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// newHarness arranca el gateway; opts permite ajustar la configuración antes de run
+func newHarness(t *testing.T, opts ...func(*Config)) *harness {
+	t.Helper()
+	return newHarnessWith(t, runOptions{}, opts...)
+}
+
+// newHarnessWith es newHarness con ganchos de run propios; el reloj y el aviso
+// de fin de escaneo son siempre los del harness
+func newHarnessWith(t *testing.T, opt runOptions, opts ...func(*Config)) *harness {
+	t.Helper()
+	h := &harness{
+		t:     t,
+		sim:   modbusServer.NewLogo8(),
+		ips:   wailonServer.NewIPSServer(),
+		clock: clock.NewFake(harnessStart),
+		done:  make(chan struct{}),
+	}
+	if err := h.sim.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.sim.Close)
+	if err := h.ips.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.ips.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dir := t.TempDir()
+	host, port, _ := net.SplitHostPort(h.ips.Addr())
+	cfg := Config{
+		Imei:          "864000000000001",
+		ModbusAddr:    h.sim.Addr(),
+		ModbusTimeout: 300 * time.Millisecond,
+		WailonUrl:     host,
+		WailonPort:    port,
+		AddrRead:      ParseAddrMap("I,i1,0\nQ,q1,8192\nQ,q2,8193"),
+		AddrWrite:     ParseAddrMap("Q,q1,8192\nQ,q2,8193"),
+		AddrAnalog:    ParseAddrMap("1,energia,0\n0,energia,1"),
+		Counters:      map[string]bool{"energia": true},
+		CountersCache: filepath.Join(dir, "counters.gob"),
+		RuntimeTags:   []string{"q1"},
+		RuntimeCache:  filepath.Join(dir, "runtime.gob"),
+		HistoryDir:    filepath.Join(dir, "history"),
+		History:       history.DefaultOptions(),
+		SentCache:     filepath.Join(dir, "sent.gob"),
+		BackfillBatch: 50,
+		PollPeriod:    harnessPeriod,
+		UploadPeriod:  10 * time.Minute,
+	}
+	opt.clock = h.clock
+	// la lectura de comandos toma la sesión: acortarla acelera cada escaneo
+	opt.readWait = 20 * time.Millisecond
+	opt.afterTick = func() {
+		select {
+		case h.done <- struct{}{}:
+		case <-ctx.Done():
+		}
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- runWith(ctx, cfg, opt)
+	}()
+	h.stop = sync.OnceValue(func() error {
+		cancel()
+		return <-errc
+	})
+	t.Cleanup(func() {
+		if err := h.stop(); err != nil {
+			t.Errorf("gateway stopped with error: %v", err)
+		}
+	})
+	if _, _, ok := h.ips.WaitPacket("L", 0, 2*time.Second); !ok {
+		t.Fatalf("gateway did not log in to wialon")
+	}
+	// ticker de polling y de mantenimiento del histórico
+	if !h.clock.BlockUntil(2, 2*time.Second) {
+		t.Fatalf("poll loop did not start")
+	}
+	return h
+}
+
+// Poll avanza el reloj hasta el próximo tick de polling y espera a que termine el escaneo
+func (h *harness) Poll() {
+	h.t.Helper()
+	h.polls++
+	h.clock.Advance(harnessStart.Add(time.Duration(h.polls) * harnessPeriod).Sub(h.clock.Now()))
+	select {
+	case <-h.done:
+	case <-time.After(10 * time.Second):
+		h.t.Fatalf("scan did not finish")
+	}
+}
+
+// count cuenta los paquetes recibidos del tipo indicado
+func (h *harness) count(kind string) int {
+	n := 0
+	for _, p := range h.ips.Packets() {
+		if p.Type == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// WaitPacket espera el próximo paquete del tipo indicado que contenga want
+func (h *harness) WaitPacket(kind string, want string) wailonServer.Packet {
+	h.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		p, i, ok := h.ips.WaitPacket(kind, h.next, time.Until(deadline))
+		if !ok {
+			break
+		}
+		h.next = i + 1
+		if strings.Contains(p.Body, want) {
+			return p
+		}
+	}
+	h.t.Fatalf("no #%s# packet containing %q", kind, want)
+	return wailonServer.Packet{}
+}
+
+func TestGateway_CoilChangeProducesData(t *testing.T) {
+	h := newHarness(t)
+	h.sim.SetWord(modbusServer.InputRegisters, 0, 1)
+	h.sim.SetWord(modbusServer.InputRegisters, 1, 2)
+
+	h.Poll()
+	p := h.WaitPacket("D", "q1:1:0")
+	if !strings.HasPrefix(p.Body, "010326;100030;") {
+		t.Errorf("first packet should carry the time of the scan: %s", p.Body)
+	}
+	for _, want := range []string{"i1:1:0", "q2:1:0", "energia:1:65538", "plc_comm:1:1", "q1_starts:1:0"} {
+		if !strings.Contains(p.Body, want) {
+			t.Errorf("first packet should contain %s: %s", want, p.Body)
+		}
+	}
+
+	h.sim.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, true)
+	h.Poll()
+	p = h.WaitPacket("D", "q1:1:1")
+	if !strings.HasPrefix(p.Body, "010326;100100;") {
+		t.Errorf("second packet time: %s", p.Body)
+	}
+}
+
+func TestGateway_UploadPeriod(t *testing.T) {
+	h := newHarness(t, func(cfg *Config) {
+		cfg.UploadPeriod = 2 * time.Minute
+	})
+	h.Poll()
+	h.WaitPacket("D", "q1:1:0")
+
+	// sin cambios solo hay pings hasta que pasa el periodo de upload
+	for h.polls < 5 {
+		h.Poll()
+	}
+	if n := h.count("D"); n != 1 {
+		t.Fatalf("unchanged values should not be uploaded before the period, got %d #D#", n)
+	}
+	h.Poll()
+	p := h.WaitPacket("D", "q1:1:0")
+	if !strings.HasPrefix(p.Body, "010326;100300;") {
+		t.Errorf("periodic upload should happen on the first scan past the period: %s", p.Body)
+	}
+}
+
+func TestGateway_WriteCommandFlipsCoil(t *testing.T) {
+	h := newHarness(t)
+	h.Poll()
+	h.WaitPacket("D", "q2:1:0")
+
+	if err := h.ips.SendCommand("W", "q2=1"); err != nil {
+		t.Fatal(err)
+	}
+	h.WaitPacket("D", "q2:1:1")
+	if !h.sim.Bit(modbusServer.Coils, modbusServer.LogoOutputs+1) {
+		t.Errorf("W command should set Q2 on the PLC")
+	}
+}
+
+func TestGateway_WialonOutageBuffers(t *testing.T) {
+	h := newHarness(t)
+	h.Poll()
+	h.WaitPacket("D", "q1:1:0")
+
+	h.ips.SetOffline(true)
+	for i := 1; i <= 3; i++ {
+		h.sim.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, i%2 == 1)
+		h.Poll()
+	}
+	h.Poll()
+	h.ips.SetOffline(false)
+	h.Poll()
+
+	p := h.WaitPacket("B", "")
+	records := strings.Split(strings.TrimSuffix(p.Body[:strings.LastIndex(p.Body, "|")], "|"), "|")
+	if len(records) != 4 {
+		t.Fatalf("expected the 4 scans taken during the outage, got %d: %s", len(records), p.Body)
+	}
+	if !strings.HasPrefix(records[0], "010326;100100;") || !strings.Contains(records[0], "q1:1:1") {
+		t.Errorf("first buffered record should keep its original time and values: %s", records[0])
+	}
+	if !strings.HasPrefix(records[3], "010326;100230;") {
+		t.Errorf("last buffered record: %s", records[3])
+	}
+}
+
+func TestGateway_CounterDeltaSurvivesFailedUpload(t *testing.T) {
+	h := newHarness(t)
+	h.sim.SetWord(modbusServer.InputRegisters, 1, 100)
+	h.Poll()
+	h.WaitPacket("D", "energia_delta:1:0")
+
+	// el consumo de un envío que Wialon no recibió se sube con el siguiente
+	h.ips.SetOffline(true)
+	h.sim.SetWord(modbusServer.InputRegisters, 1, 150)
+	h.Poll()
+	h.ips.SetOffline(false)
+	h.sim.SetWord(modbusServer.InputRegisters, 1, 175)
+	h.Poll()
+	h.WaitPacket("D", "energia_delta:1:75")
+}
+
+func TestGateway_PlcOutageReported(t *testing.T) {
+	h := newHarness(t)
+	h.Poll()
+	h.WaitPacket("D", "plc_comm:1:1")
+
+	h.sim.SetOffline(true)
+	h.Poll()
+	h.WaitPacket("D", "plc_comm:1:0")
+
+	h.sim.SetOffline(false)
+	h.Poll()
+	h.WaitPacket("D", "plc_comm:1:1")
+}
+
+func TestGateway_QualityFlags(t *testing.T) {
+	h := newHarness(t, func(cfg *Config) {
+		cfg.Limits = map[string]quality.Limits{"energia": {Min: 0, Max: 1000}}
+		cfg.Substitutes = map[string]float64{"i1": 0}
+	})
+	h.sim.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, true)
+	h.sim.SetBit(modbusServer.DiscreteInputs, 0, true)
+	h.Poll()
+	p := h.WaitPacket("D", "q1:1:1")
+	for _, want := range []string{"q1_q:1:0,", "i1_q:1:0,", "energia_q:1:0,"} {
+		if !strings.Contains(p.Body, want) {
+			t.Errorf("good values should carry %s: %s", want, p.Body)
+		}
+	}
+
+	// PLC caído: la bomba sigue en 1 pero con calidad comm-failure y la hora de la última lectura
+	h.sim.SetOffline(true)
+	h.Poll()
+	p = h.WaitPacket("D", "plc_comm:1:0")
+	for _, want := range []string{"q1_q:1:2,q1_ts:1:1772359230,q1:1:1", "i1_q:1:4,i1_ts:1:1772359230,i1:1:0", "energia_q:1:2"} {
+		if !strings.Contains(p.Body, want) {
+			t.Errorf("outage packet should contain %s: %s", want, p.Body)
+		}
+	}
+	for _, derived := range []string{"energia_delta", "q1_run_s"} {
+		if strings.Contains(p.Body, derived) {
+			t.Errorf("derived %s should not be uploaded from a bad source: %s", derived, p.Body)
+		}
+	}
+
+	h.sim.SetOffline(false)
+	h.sim.SetWord(modbusServer.InputRegisters, 1, 5000)
+	h.Poll()
+	p = h.WaitPacket("D", "plc_comm:1:1")
+	for _, want := range []string{"q1_q:1:0,", "energia_q:1:3,energia_ts:1:1772359230,energia:1:5000", "q1_run_s"} {
+		if !strings.Contains(p.Body, want) {
+			t.Errorf("recovered packet should contain %s: %s", want, p.Body)
+		}
+	}
+}
+
+func TestGateway_ExceptionKeepsPLCOnline(t *testing.T) {
+	h := newHarness(t)
+	h.sim.SetException(modbus.FuncCodeReadInputRegisters, 0, modbusServer.IllegalDataAddress)
+	h.Poll()
+	p := h.WaitPacket("D", "plc_comm:1:1")
+	if !strings.Contains(p.Body, "q1_q:1:0,") || strings.Contains(p.Body, "energia_q:1:0,") {
+		t.Errorf("only the analog group should lose quality: %s", p.Body)
+	}
+
+	// el PLC responde: las excepciones no cuentan como fallas de comunicación
+	for range InitModbusFails {
+		h.Poll()
+	}
+}
+
+func TestGateway_BreakerSkipsDownPLC(t *testing.T) {
+	h := newHarness(t, func(cfg *Config) {
+		cfg.BreakerFails = 1
+		cfg.BreakerProbe = 45 * time.Second
+	})
+	h.Poll()
+	h.WaitPacket("D", "plc_breaker:1:0,plc_comm:1:1")
+
+	h.sim.SetOffline(true)
+	h.Poll()
+	h.WaitPacket("D", "plc_breaker:1:1,plc_comm:1:0")
+
+	// con el breaker abierto el escaneo no llega al PLC
+	sent := len(h.sim.Requests())
+	h.Poll()
+	if n := len(h.sim.Requests()); n != sent {
+		t.Errorf("an open breaker should skip the PLC, got %d requests", n-sent)
+	}
+
+	// los escaneos saltados no cuentan como fallas: el gateway sigue corriendo
+	// más allá de InitModbusFails escaneos con el PLC caído
+	for range InitModbusFails {
+		h.Poll()
+	}
+
+	h.sim.SetOffline(false)
+	h.Poll()
+	h.WaitPacket("D", "plc_breaker:1:0,plc_comm:1:1")
+}
+
+func TestGateway_DiagnosticsUploaded(t *testing.T) {
+	h := newHarness(t, func(cfg *Config) {
+		cfg.Diagnostics = map[string]bool{diagnostics.Uptime: true, diagnostics.Modbus: true, diagnostics.Wialon: true, diagnostics.Config: true}
+	})
+	h.Poll()
+	p := h.WaitPacket("D", "plc_comm:1:1")
+	for _, want := range []string{"gw_uptime_s:1:30,", "mb_requests:1:3,", "mb_errors:1:0,", "wl_reconnects:1:0,", "wl_outbox:1:0,", "cfg_hash:3:"} {
+		if !strings.Contains(p.Body, want) {
+			t.Errorf("diagnostics should contain %s: %s", want, p.Body)
+		}
+	}
+	if strings.Contains(p.Body, "cpu_temp") || strings.Contains(p.Body, "gw_restarts") {
+		t.Errorf("disabled groups should not be uploaded: %s", p.Body)
+	}
+}
+
+// freeAddr devuelve una dirección local libre para un listener del gateway
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	return ln.Addr().String()
+}
+
+func TestGateway_MetricsEndpoint(t *testing.T) {
+	addr := freeAddr(t)
+	h := newHarness(t, func(cfg *Config) {
+		cfg.MetricsAddr = addr
+		cfg.BreakerFails = 2
+		cfg.BreakerProbe = time.Minute
+	})
+	h.sim.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, true)
+	h.Poll()
+	h.WaitPacket("D", "q1:1:1")
+
+	res, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"gateway_poll_duration_seconds_count 1\n",
+		// la duración del escaneo se mide con el reloj del harness, que no avanzó
+		"gateway_poll_duration_seconds_sum 0\n",
+		`modbus_requests_total{function="read_coils"} 1`,
+		`modbus_errors_total{function="read_input_registers"} 0`,
+		"modbus_breaker_state 0\n",
+		`wialon_response_seconds_count{packet="L"}`,
+		`wialon_response_seconds_count{packet="D"} 1`,
+		`gateway_tag_value{device="864000000000001",tag="q1"} 1`,
+		`gateway_tag_quality{device="864000000000001",tag="energia"} 0`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics should contain %s:\n%s", want, body)
+		}
+	}
+}
+
+// apiCall hace un request a la API local y decodifica la respuesta JSON en out
+func apiCall(t *testing.T, addr, method, path, token, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, "http://"+addr+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestGateway_LocalAPI(t *testing.T) {
+	addr := freeAddr(t)
+	h := newHarness(t, func(cfg *Config) {
+		cfg.APIAddr = addr
+		cfg.APIToken = "s3cret"
+		cfg.Limits = map[string]quality.Limits{"energia": {Min: 0, Max: 100}}
+	})
+	h.sim.SetWord(modbusServer.InputRegisters, 1, 500)
+	h.Poll()
+	h.WaitPacket("D", "q2:1:0")
+
+	call := func(method, path, token, body string, out any) int {
+		t.Helper()
+		return apiCall(t, addr, method, path, token, body, out)
+	}
+
+	if code := call("GET", "/api/values", "", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("missing token: got %d", code)
+	}
+	if code := call("GET", "/api/values", "wrong", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: got %d", code)
+	}
+
+	var devices []apiDevice
+	call("GET", "/api/devices", "s3cret", "", &devices)
+	if len(devices) != 1 || devices[0].ID != "864000000000001" || len(devices[0].Tags) != 4 {
+		t.Fatalf("devices: %+v", devices)
+	}
+	if q2 := devices[0].Tags[2]; q2.Name != "q2" || q2.Kind != "output" || !q2.Writable {
+		t.Errorf("q2 should be a writable output: %+v", q2)
+	}
+
+	var values []apiValue
+	call("GET", "/api/values", "s3cret", "", &values)
+	if len(values) != 4 || values[3].Tag != "energia" || values[3].Value != 500 || values[3].Quality != "out-of-range" {
+		t.Fatalf("values: %+v", values)
+	}
+	if values[0].Timestamp == nil || !values[0].Timestamp.Equal(harnessStart.Add(harnessPeriod)) {
+		t.Errorf("values should carry the time of the scan: %+v", values[0])
+	}
+
+	var alarmList []alarms.Alarm
+	call("GET", "/api/alarms", "s3cret", "", &alarmList)
+	if len(alarmList) != 1 || alarmList[0].ID != "tag_energia" {
+		t.Errorf("out of range tag should raise an alarm: %+v", alarmList)
+	}
+
+	var points []apiPoint
+	call("GET", "/api/history?tag=q2&from=2026-03-01T10:00:00Z&to=2026-03-01T11:00:00Z", "s3cret", "", &points)
+	if len(points) != 1 || points[0].Tag != "q2" {
+		t.Errorf("history: %+v", points)
+	}
+
+	if code := call("POST", "/api/commands", "s3cret", `{"tag":"i1","value":true}`, nil); code != http.StatusNotFound {
+		t.Errorf("inputs are not writable, got %d", code)
+	}
+	if code := call("POST", "/api/commands", "s3cret", `{"tag":"q2","value":true}`, nil); code != http.StatusOK {
+		t.Fatalf("command: got %d", code)
+	}
+	if !h.sim.Bit(modbusServer.Coils, modbusServer.LogoOutputs+1) {
+		t.Errorf("command should set Q2 on the PLC")
+	}
+	h.WaitPacket("D", "q2:1:1")
+}
+
+func TestGateway_Dashboard(t *testing.T) {
+	addr := freeAddr(t)
+	h := newHarness(t, func(cfg *Config) {
+		cfg.APIAddr = addr
+		cfg.APIToken = "s3cret"
+		cfg.GensetCommands = true
+		cfg.BreakerFails = 2
+		cfg.BreakerProbe = time.Minute
+	})
+	h.sim.AddRange(modbusServer.Coils, modbusClient.AutomaticStartStopAddr, 1)
+	h.Poll()
+	h.WaitPacket("D", "plc_comm:1:1")
+
+	// la página no lleva datos: se sirve sin token
+	res, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(page), "app.js") {
+		t.Fatalf("dashboard page: %d %s", res.StatusCode, page)
+	}
+
+	var st apiStatus
+	apiCall(t, addr, "GET", "/api/status", "s3cret", "", &st)
+	if !st.Modbus.OK || !st.Wialon.OK || !st.Genset || st.Modbus.Breaker != "closed" {
+		t.Errorf("status: %+v", st)
+	}
+
+	h.sim.SetOffline(true)
+	h.Poll()
+	h.WaitPacket("D", "plc_comm:1:0")
+	apiCall(t, addr, "GET", "/api/status", "s3cret", "", &st)
+	if st.Modbus.OK {
+		t.Errorf("status should show the PLC down: %+v", st)
+	}
+	if code := apiCall(t, addr, "POST", "/api/alarms/plc_comm/ack", "s3cret", "", nil); code != http.StatusOK {
+		t.Fatalf("ack: got %d", code)
+	}
+	var alarmList []alarms.Alarm
+	apiCall(t, addr, "GET", "/api/alarms", "s3cret", "", &alarmList)
+	if len(alarmList) == 0 || alarmList[0].ID != "plc_comm" || !alarmList[0].Acked {
+		t.Errorf("plc_comm should be acknowledged: %+v", alarmList)
+	}
+	if code := apiCall(t, addr, "POST", "/api/alarms/wialon/ack", "s3cret", "", nil); code != http.StatusNotFound {
+		t.Errorf("inactive alarm ack: got %d", code)
+	}
+
+	h.sim.SetOffline(false)
+	if code := apiCall(t, addr, "POST", "/api/genset", "s3cret", `{"action":"start"}`, nil); code != http.StatusOK {
+		t.Fatalf("genset start: got %d", code)
+	}
+	if !h.sim.Bit(modbusServer.Coils, modbusClient.AutomaticStartStopAddr) {
+		t.Errorf("genset start should set the start/stop coil")
+	}
+	if code := apiCall(t, addr, "POST", "/api/genset", "s3cret", `{"action":"reboot"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("unknown genset action: got %d", code)
+	}
+}
+
+func TestGateway_ReplaysRecordedSession(t *testing.T) {
+	capture := filepath.Join(t.TempDir(), "session.jsonl")
+	h := newHarness(t, func(cfg *Config) {
+		cfg.ModbusRecord = capture
+	})
+	h.Poll()
+	h.WaitPacket("D", "q1:1:0")
+	h.sim.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, true)
+	h.Poll()
+	h.WaitPacket("D", "q1:1:1")
+
+	// mismo escaneo sin PLC: el simulador queda apagado y responde la captura
+	replay := newHarness(t, func(cfg *Config) {
+		cfg.ModbusReplay = capture
+	})
+	replay.sim.SetOffline(true)
+	replay.Poll()
+	replay.WaitPacket("D", "q1:1:0")
+	replay.Poll()
+	replay.WaitPacket("D", "q1:1:1")
+}
+
+func TestGateway_ShutdownInterruptsScan(t *testing.T) {
+	h := newHarness(t)
+	h.Poll()
+	h.WaitPacket("D", "plc_comm:1:1")
+
+	// con el PLC lento el escaneo queda en reintentos; cancelar debe cortarlo
+	h.sim.SetDelay(2 * time.Second)
+	h.clock.Advance(harnessPeriod)
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if err := h.stop(); err != nil {
+		t.Fatalf("shutdown should not be an error: %v", err)
+	}
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("shutdown waited for the scan: %s", took)
+	}
+	for _, p := range h.ips.Packets() {
+		if strings.Contains(p.Body, "plc_comm:1:0") {
+			t.Errorf("an interrupted scan is not a PLC outage: %s", p.Body)
+		}
+	}
+}
+
+func TestGateway_MQTTUplink(t *testing.T) {
+	broker := mqttClient.NewBroker()
+	if err := broker.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(broker.Close)
+	h := newHarness(t, func(cfg *Config) {
+		cfg.MQTT = mqttClient.Options{Addr: broker.Addr(), ClientID: "gw-test"}
+		cfg.MQTTTopic = "plc/test"
+		cfg.MQTTMode = mqttModeTags
+	})
+	h.Poll()
+	h.WaitPacket("D", "q2:1:0")
+
+	if _, _, ok := broker.WaitMessage("plc/test/tags/q2", 0, 2*time.Second); !ok {
+		t.Fatal("tags should be published after the upload")
+	}
+	m, ok := broker.Retained("plc/test/tags/q2")
+	var tag mqttTag
+	if !ok || json.Unmarshal(m.Payload, &tag) != nil || tag.Value != 0 || tag.Quality != "good" {
+		t.Fatalf("q2 should be retained: %s", m.Payload)
+	}
+	if m, _ := broker.Retained("plc/test/status"); string(m.Payload) != "online" {
+		t.Errorf("status: %q", m.Payload)
+	}
+
+	// los comandos siguen el mismo camino que los #M# de Wialon
+	deadline := time.Now().Add(2 * time.Second)
+	for !broker.Subscribed("plc/test/cmd/+") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	from := len(broker.Received())
+	broker.Publish(mqttClient.Message{Topic: "plc/test/cmd/W", Payload: []byte("q2=1"), QoS: 1})
+	h.WaitPacket("D", "q2:1:1")
+	if !h.sim.Bit(modbusServer.Coils, modbusServer.LogoOutputs+1) {
+		t.Errorf("W command should set Q2 on the PLC")
+	}
+	m2, _, ok := broker.WaitMessage("plc/test/tags/q2", from, 2*time.Second)
+	if !ok || json.Unmarshal(m2.Payload, &tag) != nil || tag.Value != 1 {
+		t.Errorf("q2 should be republished after the command: %s", m2.Payload)
+	}
+}
+
+func TestGateway_MQTTSnapshot(t *testing.T) {
+	broker := mqttClient.NewBroker()
+	if err := broker.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(broker.Close)
+	h := newHarness(t, func(cfg *Config) {
+		cfg.MQTT = mqttClient.Options{Addr: broker.Addr(), ClientID: "gw-test"}
+		cfg.MQTTTopic = "plc/test"
+		cfg.MQTTMode = mqttModeSnapshot
+	})
+	h.sim.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, true)
+	h.Poll()
+	h.WaitPacket("D", "q1:1:1")
+
+	m, _, ok := broker.WaitMessage("plc/test/snapshot", 0, 2*time.Second)
+	var scan mqttScan
+	if !ok || json.Unmarshal(m.Payload, &scan) != nil {
+		t.Fatalf("snapshot: %s", m.Payload)
+	}
+	if len(scan.Tags) != 4 || scan.Tags["q1"].Value != 1 || !scan.Time.Equal(harnessStart.Add(harnessPeriod)) {
+		t.Errorf("snapshot: %+v", scan)
+	}
+	if _, ok := broker.Retained("plc/test/tags/q1"); ok {
+		t.Errorf("snapshot mode should not publish per-tag topics")
+	}
+}
+
+func TestGateway_Sparkplug(t *testing.T) {
+	broker := mqttClient.NewBroker()
+	if err := broker.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(broker.Close)
+	h := newHarness(t, func(cfg *Config) {
+		cfg.MQTT = mqttClient.Options{Addr: broker.Addr(), ClientID: "gw-test"}
+		cfg.MQTTMode = mqttModeSparkplug
+		cfg.Sparkplug.Group, cfg.Sparkplug.Node, cfg.Sparkplug.Device = "plants", "gw1", "plc"
+	})
+	next := 0
+	wait := func(kind, device string) sparkplug.Payload {
+		t.Helper()
+		m, i, ok := broker.WaitMessage(sparkplug.Topic("plants", kind, "gw1", device), next, 3*time.Second)
+		if !ok {
+			t.Fatalf("no %s", kind)
+		}
+		next = i + 1
+		p, err := sparkplug.Decode(m.Payload)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		return p
+	}
+	metric := func(p sparkplug.Payload, name string) sparkplug.Metric {
+		for _, m := range p.Metrics {
+			if m.Name == name {
+				return m
+			}
+		}
+		t.Fatalf("no metric %s in %+v", name, p.Metrics)
+		return sparkplug.Metric{}
+	}
+
+	nbirth := wait(sparkplug.NBirth, "")
+	if nbirth.Seq != 0 || metric(nbirth, sparkplug.BdSeq).Value != uint64(0) {
+		t.Fatalf("NBIRTH: %+v", nbirth)
+	}
+	h.Poll()
+	dbirth := wait(sparkplug.DBirth, "plc")
+	q2 := metric(dbirth, "q2")
+	if dbirth.Seq != 1 || len(dbirth.Metrics) != 4 || q2.Value != false || q2.Alias == 0 {
+		t.Fatalf("DBIRTH: %+v", dbirth)
+	}
+	if e := metric(dbirth, "energia"); e.Type != sparkplug.UInt32 || e.Value != uint32(0) {
+		t.Errorf("energia: %+v", e)
+	}
+
+	// DCMD por alias: se escribe como un comando W
+	cmd, _ := sparkplug.Payload{Seq: -1, Metrics: []sparkplug.Metric{
+		{Alias: q2.Alias, Type: sparkplug.Boolean, Value: true},
+	}}.Encode()
+	broker.Publish(mqttClient.Message{Topic: sparkplug.Topic("plants", sparkplug.DCmd, "gw1", "plc"), Payload: cmd})
+	h.WaitPacket("D", "q2:1:1")
+	if !h.sim.Bit(modbusServer.Coils, modbusServer.LogoOutputs+1) {
+		t.Errorf("DCMD should set Q2 on the PLC")
+	}
+	ddata := wait(sparkplug.DData, "plc")
+	if ddata.Seq != 2 || len(ddata.Metrics) != 1 || ddata.Metrics[0].Alias != q2.Alias ||
+		ddata.Metrics[0].Name != "" || ddata.Metrics[0].Value != true {
+		t.Errorf("DDATA should carry only the changed tag, by alias: %+v", ddata)
+	}
+
+	// el PLC caído es un DDEATH; al volver, un DBIRTH
+	h.sim.SetOffline(true)
+	h.Poll()
+	if p := wait(sparkplug.DDeath, "plc"); p.Seq != 3 {
+		t.Errorf("DDEATH seq: %d", p.Seq)
+	}
+	h.sim.SetOffline(false)
+	h.Poll()
+	if p := wait(sparkplug.DBirth, "plc"); p.Seq != 4 || metric(p, "q2").Value != true {
+		t.Errorf("DBIRTH after recovery: %+v", p)
+	}
+
+	// corte del broker: NDEATH con el bdSeq de la sesión y nacimientos con el siguiente
+	broker.DropConnections()
+	if p := wait(sparkplug.NDeath, ""); p.Seq != -1 || metric(p, sparkplug.BdSeq).Value != uint64(0) {
+		t.Errorf("NDEATH: %+v", p)
+	}
+	h.sim.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, true)
+	h.Poll()
+	if p := wait(sparkplug.NBirth, ""); p.Seq != 0 || metric(p, sparkplug.BdSeq).Value != uint64(1) {
+		t.Errorf("NBIRTH after reconnect: %+v", p)
+	}
+	if p := wait(sparkplug.DBirth, "plc"); p.Seq != 1 || metric(p, "q1").Value != true {
+		t.Errorf("DBIRTH after reconnect: %+v", p)
+	}
+
+	rebirth, _ := sparkplug.Payload{Seq: -1, Metrics: []sparkplug.Metric{
+		{Name: sparkplug.Rebirth, Type: sparkplug.Boolean, Value: true},
+	}}.Encode()
+	broker.Publish(mqttClient.Message{Topic: sparkplug.Topic("plants", sparkplug.NCmd, "gw1", ""), Payload: rebirth})
+	if p := wait(sparkplug.NBirth, ""); p.Seq != 0 || metric(p, sparkplug.BdSeq).Value != uint64(1) {
+		t.Errorf("rebirth keeps the session bdSeq: %+v", p)
+	}
+	wait(sparkplug.DBirth, "plc")
+}
+
+func TestGateway_ModbusImage(t *testing.T) {
+	addr := freeAddr(t)
+	h := newHarness(t, func(cfg *Config) {
+		cfg.ModbusServer = addr
+		var err error
+		cfg.ModbusImage, err = parseImageMap(`di:0:i1
+coil:0:q1
+coil:1:q2
+coil:2:plc_comm
+ir:0:energia:u32
+ir:2:energia:u16:0.1
+ir:3:energia_q
+ir:4:plc_comm
+hr:0:energia:f32:0.5`, cfg.AddrWrite)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	h.sim.SetBit(modbusServer.DiscreteInputs, 0, true)
+	h.sim.SetWord(modbusServer.InputRegisters, 0, 1)
+	h.sim.SetWord(modbusServer.InputRegisters, 1, 500)
+	h.Poll()
+	h.WaitPacket("D", "q2:1:0")
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.Timeout = 2 * time.Second
+	t.Cleanup(func() {
+		_ = handler.Close()
+	})
+	c := modbus.NewClient(handler)
+	code := func(err error) byte {
+		var mbErr *modbus.ModbusError
+		if errors.As(err, &mbErr) {
+			return mbErr.ExceptionCode
+		}
+		return 0
+	}
+
+	if b, err := c.ReadDiscreteInputs(0, 1); err != nil || b[0] != 1 {
+		t.Errorf("i1: %v %v", b, err)
+	}
+	// energia = 0x0001_01F4 = 66036: u32, u16 escalado, calidad y derivado en un solo bloque
+	ir, err := c.ReadInputRegisters(0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []uint16{1, 500, 6604, 0, 1} {
+		if got := binary.BigEndian.Uint16(ir[2*i:]); got != want {
+			t.Errorf("input register %d: got %d, want %d", i, got, want)
+		}
+	}
+	hr, err := c.ReadHoldingRegisters(0, 2)
+	if err != nil || math.Float32frombits(binary.BigEndian.Uint32(hr)) != 33018 {
+		t.Errorf("f32 energia: %v %v", hr, err)
+	}
+	if _, err := c.ReadInputRegisters(5, 1); code(err) != modbusServer.IllegalDataAddress {
+		t.Errorf("unmapped register should be an illegal address, got %v", err)
+	}
+
+	// las escrituras de coils van al PLC por el camino de los comandos
+	if _, err := c.WriteSingleCoil(1, 0xFF00); err != nil {
+		t.Fatal(err)
+	}
+	if !h.sim.Bit(modbusServer.Coils, modbusServer.LogoOutputs+1) {
+		t.Errorf("coil write should set Q2 on the PLC")
+	}
+	h.WaitPacket("D", "q2:1:1")
+	if _, err := c.WriteSingleCoil(2, 0xFF00); code(err) != modbusServer.IllegalDataAddress {
+		t.Errorf("coil mapped to a non-output should be rejected, got %v", err)
+	}
+	if b, err := c.ReadCoils(0, 3); err != nil || b[0] != 0b110 {
+		t.Errorf("rejected write should not change the image: %v %v", b, err)
+	}
+	if _, err := c.WriteSingleRegister(0, 1); code(err) != modbusServer.IllegalDataAddress {
+		t.Errorf("registers are read-only, got %v", err)
+	}
+}
+
+func TestGateway_ModbusImageCoilReadBack(t *testing.T) {
+	addr := freeAddr(t)
+	h := newHarness(t, func(cfg *Config) {
+		cfg.ModbusServer = addr
+		// el HMI lee el estado de Q2 y escribe su orden en el mismo coil
+		cfg.AddrWrite = ParseAddrMap("Q,q1,8192\nQ,q2_set,8193")
+		var err error
+		cfg.ModbusImage, err = parseImageMap("coil:0:q2:q2_set", cfg.AddrWrite)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	h.Poll()
+	h.WaitPacket("D", "q2:1:0")
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.Timeout = 2 * time.Second
+	t.Cleanup(func() {
+		_ = handler.Close()
+	})
+	c := modbus.NewClient(handler)
+
+	if _, err := c.WriteSingleCoil(0, 0xFF00); err != nil {
+		t.Fatal(err)
+	}
+	if !h.sim.Bit(modbusServer.Coils, modbusServer.LogoOutputs+1) {
+		t.Fatalf("coil write should set Q2 on the PLC")
+	}
+	h.Poll()
+	if b, err := c.ReadCoils(0, 1); err != nil || b[0] != 1 {
+		t.Errorf("coil should read back the written output: %v %v", b, err)
+	}
+
+	// el coil sigue al PLC aunque la salida cambie por otro lado
+	h.sim.SetBit(modbusServer.Coils, modbusServer.LogoOutputs+1, false)
+	h.Poll()
+	if b, err := c.ReadCoils(0, 1); err != nil || b[0] != 0 {
+		t.Errorf("coil should show the PLC state: %v %v", b, err)
+	}
+}
+
+func TestGateway_RTUGateway(t *testing.T) {
+	addr := freeAddr(t)
+	plc := modbusServer.NewLogo8()
+	plc.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, true)
+	meter := modbusServer.NewServer()
+	meter.SetWord(modbusServer.HoldingRegisters, 1, 1234)
+	// el PLC y el medidor en el mismo bus: el polling y el reenvío comparten la cola
+	bus := runOptions{openRTU: func() (io.ReadWriteCloser, error) {
+		client, bus := net.Pipe()
+		go func() {
+			_ = modbusServer.ServeRTU(bus, map[byte]*modbusServer.Server{1: plc, 2: meter})
+			_ = bus.Close()
+		}()
+		return client, nil
+	}}
+	h := newHarnessWith(t, bus, func(cfg *Config) {
+		cfg.ModbusRTU = true
+		cfg.RTU.Address = "/dev/sim"
+		cfg.RTUGateway = addr
+	})
+	h.Poll()
+	h.WaitPacket("D", "q1:1:1")
+
+	laptop := func(unit byte) modbus.Client {
+		handler := modbus.NewTCPClientHandler(addr)
+		handler.Timeout = 2 * time.Second
+		handler.SlaveId = unit
+		t.Cleanup(func() {
+			_ = handler.Close()
+		})
+		return modbus.NewClient(handler)
+	}
+	code := func(err error) byte {
+		var mbErr *modbus.ModbusError
+		if errors.As(err, &mbErr) {
+			return mbErr.ExceptionCode
+		}
+		return 0
+	}
+	if b, err := laptop(2).ReadHoldingRegisters(1, 1); err != nil || binary.BigEndian.Uint16(b) != 1234 {
+		t.Errorf("meter through the gateway: %v %v", b, err)
+	}
+	if _, err := laptop(2).WriteSingleRegister(1, 77); err != nil || meter.Word(modbusServer.HoldingRegisters, 1) != 77 {
+		t.Errorf("write through the gateway: %v", err)
+	}
+	if b, err := laptop(1).ReadCoils(modbusServer.LogoOutputs, 1); err != nil || b[0] != 1 {
+		t.Errorf("plc through the gateway: %v %v", b, err)
+	}
+	if _, err := laptop(9).ReadHoldingRegisters(0, 1); code(err) != modbusServer.GatewayTargetFailed {
+		t.Errorf("absent unit: got %v", err)
+	}
+	if _, err := laptop(0).ReadHoldingRegisters(0, 1); code(err) != modbusServer.GatewayPathUnavailable {
+		t.Errorf("broadcast should not be forwarded, got %v", err)
+	}
+
+	// tras el timeout del unit ausente el polling sigue
+	plc.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, false)
+	h.Poll()
+	h.WaitPacket("D", "q1:1:0")
+}
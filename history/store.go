@@ -0,0 +1,414 @@
+package history
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Un archivo por día (UTC) con líneas "unixMillis,tag,valor".
+// Los días ya reducidos se guardan como YYYYMMDD.ds.csv.
+const (
+	dayLayout = "20060102"
+	rawExt    = ".csv"
+	dsExt     = ".ds.csv"
+)
+
+type Point struct {
+	Time  time.Time
+	Tag   string
+	Value float64
+}
+
+type Options struct {
+	MaxAge          time.Duration // días más antiguos se borran
+	MaxBytes        int64         // tamaño total máximo en disco
+	DownsampleAfter time.Duration // días más antiguos se reducen
+	DownsampleStep  time.Duration // un punto por tag cada DownsampleStep
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MaxAge:          90 * 24 * time.Hour,
+		MaxBytes:        256 << 20,
+		DownsampleAfter: 7 * 24 * time.Hour,
+		DownsampleStep:  5 * time.Minute,
+	}
+}
+
+type Store struct {
+	mu   sync.Mutex
+	dir  string
+	opts Options
+	day  string
+	f    *os.File
+}
+
+func Open(dir string, opts Options) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating history dir: %w", err)
+	}
+	return &Store{dir: dir, opts: opts}, nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// Append guarda los puntos en el archivo del día correspondiente
+func (s *Store) Append(points []Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	for _, p := range points {
+		day := p.Time.UTC().Format(dayLayout)
+		if day != s.day {
+			if err := s.flush(&b); err != nil {
+				return err
+			}
+			if err := s.openDay(day); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(&b, "%d,%s,%s\n", p.Time.UnixMilli(), p.Tag,
+			strconv.FormatFloat(p.Value, 'f', -1, 64))
+	}
+	return s.flush(&b)
+}
+
+func (s *Store) flush(b *strings.Builder) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	_, err := s.f.WriteString(b.String())
+	b.Reset()
+	if err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) openDay(day string) error {
+	if s.f != nil {
+		_ = s.f.Close()
+		s.f = nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, day+rawExt), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening history file: %w", err)
+	}
+	s.f = f
+	s.day = day
+	return nil
+}
+
+// Query devuelve los puntos de los tags pedidos (todos si tags está vacío)
+// con from <= t < to, ordenados por tiempo.
+func (s *Store) Query(tags []string, from, to time.Time) ([]Point, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	want := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		want[t] = true
+	}
+	files, err := s.dayFiles()
+	if err != nil {
+		return nil, err
+	}
+	points := make([]Point, 0)
+	firstDay := from.UTC().Format(dayLayout)
+	lastDay := to.UTC().Format(dayLayout)
+	for _, df := range files {
+		if df.day < firstDay || df.day > lastDay {
+			continue
+		}
+		// con crudo y reducido del mismo día un punto puede estar en los dos
+		type key struct {
+			ms  int64
+			tag string
+		}
+		seen := make(map[key]bool)
+		for _, path := range df.paths() {
+			err := readFile(path, func(p Point) {
+				if len(want) > 0 && !want[p.Tag] {
+					return
+				}
+				if p.Time.Before(from) || !p.Time.Before(to) {
+					return
+				}
+				if df.ds != "" {
+					k := key{p.Time.UnixMilli(), p.Tag}
+					if seen[k] {
+						return
+					}
+					seen[k] = true
+				}
+				points = append(points, p)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+	return points, nil
+}
+
+// Maintain reduce los días viejos y borra por antigüedad y por tamaño.
+func (s *Store) Maintain(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	today := now.UTC().Format(dayLayout)
+	// el archivo de un día que ya pasó se cierra antes de reducirlo o borrarlo;
+	// un punto tardío lo vuelve a abrir
+	if s.f != nil && s.day != today {
+		_ = s.f.Close()
+		s.f = nil
+		s.day = ""
+	}
+	files, err := s.dayFiles()
+	if err != nil {
+		return err
+	}
+	oldest := now.Add(-s.opts.MaxAge).UTC().Format(dayLayout)
+	dsBefore := now.Add(-s.opts.DownsampleAfter).UTC().Format(dayLayout)
+
+	kept := make([]dayFile, 0, len(files))
+	for _, df := range files {
+		if df.day == today {
+			kept = append(kept, df)
+			continue
+		}
+		if s.opts.MaxAge > 0 && df.day < oldest {
+			_ = df.remove()
+			continue
+		}
+		if !df.downsampled && s.opts.DownsampleStep > 0 && df.day < dsBefore {
+			if err := s.downsample(df); err != nil {
+				return err
+			}
+			df = dayFile{day: df.day, path: filepath.Join(s.dir, df.day+dsExt), downsampled: true}
+		}
+		kept = append(kept, df)
+	}
+
+	if s.opts.MaxBytes <= 0 {
+		return nil
+	}
+	total := int64(0)
+	sizes := make([]int64, len(kept))
+	for i, df := range kept {
+		for _, path := range df.paths() {
+			if fi, err := os.Stat(path); err == nil {
+				sizes[i] += fi.Size()
+			}
+		}
+		total += sizes[i]
+	}
+	for i, df := range kept {
+		if total <= s.opts.MaxBytes || df.day == today {
+			break
+		}
+		if err := df.remove(); err == nil {
+			total -= sizes[i]
+		}
+	}
+	return nil
+}
+
+// downsample deja el último valor de cada tag por intervalo DownsampleStep,
+// que se toma como de al menos 1 ms
+func (s *Store) downsample(df dayFile) error {
+	type key struct {
+		tag    string
+		bucket int64
+	}
+	step := max(s.opts.DownsampleStep.Milliseconds(), 1)
+	last := make(map[key]Point)
+	for _, path := range df.paths() {
+		err := readFile(path, func(p Point) {
+			k := key{p.Tag, p.Time.UnixMilli() / step}
+			if prev, ok := last[k]; !ok || !p.Time.Before(prev.Time) {
+				last[k] = p
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	points := make([]Point, 0, len(last))
+	for _, p := range last {
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	target := filepath.Join(s.dir, df.day+dsExt)
+	tmp := target + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("downsampling %s: %w", df.day, err)
+	}
+	w := bufio.NewWriter(f)
+	for _, p := range points {
+		fmt.Fprintf(w, "%d,%s,%s\n", p.Time.UnixMilli(), p.Tag,
+			strconv.FormatFloat(p.Value, 'f', -1, 64))
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("downsampling %s: %w", df.day, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("downsampling %s: %w", df.day, err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return fmt.Errorf("downsampling %s: %w", df.day, err)
+	}
+	return os.Remove(df.path)
+}
+
+type dayFile struct {
+	day         string
+	path        string
+	downsampled bool
+	// reducido que convive con el crudo del mismo día: un corte entre el rename
+	// y el borrado del crudo, o puntos tardíos llegados después de reducirlo
+	ds string
+}
+
+func (df dayFile) paths() []string {
+	if df.ds != "" {
+		return []string{df.path, df.ds}
+	}
+	return []string{df.path}
+}
+
+func (df dayFile) remove() error {
+	if df.ds != "" {
+		_ = os.Remove(df.ds)
+	}
+	return os.Remove(df.path)
+}
+
+// dayFiles lista un dayFile por día, ordenados; si un día tiene crudo y
+// reducido, el crudo queda en path y el reducido en ds
+func (s *Store) dayFiles() ([]dayFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading history dir: %w", err)
+	}
+	byDay := make(map[string]dayFile, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		var df dayFile
+		switch {
+		case strings.HasSuffix(name, dsExt):
+			df = dayFile{day: strings.TrimSuffix(name, dsExt), path: filepath.Join(s.dir, name), downsampled: true}
+		case strings.HasSuffix(name, rawExt):
+			df = dayFile{day: strings.TrimSuffix(name, rawExt), path: filepath.Join(s.dir, name)}
+		default:
+			continue
+		}
+		if prev, ok := byDay[df.day]; ok {
+			if df.downsampled {
+				df, prev = prev, df
+			}
+			df.ds = prev.path
+		}
+		byDay[df.day] = df
+	}
+	files := make([]dayFile, 0, len(byDay))
+	for _, df := range byDay {
+		files = append(files, df)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].day < files[j].day
+	})
+	return files, nil
+}
+
+func readFile(path string, fn func(p Point)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("reading history: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), ",")
+		if len(parts) != 3 {
+			// línea cortada por un apagón
+			continue
+		}
+		ms, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			continue
+		}
+		fn(Point{time.UnixMilli(ms), parts[1], v})
+	}
+	return scanner.Err()
+}
+
+// Resample deja el último valor de cada tag por intervalo `step`, con la hora
+// truncada al inicio del intervalo. Los intervalos se cuentan desde la
+// medianoche en loc: con 24h cada punto cae en su día local. Los puntos deben
+// venir ordenados por tiempo.
+func Resample(points []Point, step time.Duration, loc *time.Location) []Point {
+	if step <= 0 {
+		return points
+	}
+	type key struct {
+		tag    string
+		bucket time.Time
+	}
+	index := make(map[key]int)
+	out := make([]Point, 0)
+	for _, p := range points {
+		k := key{p.Tag, truncateIn(p.Time, step, loc)}
+		if i, ok := index[k]; ok {
+			out[i].Value = p.Value
+			continue
+		}
+		index[k] = len(out)
+		out = append(out, Point{k.bucket, p.Tag, p.Value})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Time.Before(out[j].Time)
+	})
+	return out
+}
+
+// truncateIn trunca t a un múltiplo de step contado desde la medianoche en loc
+func truncateIn(t time.Time, step time.Duration, loc *time.Location) time.Time {
+	t = t.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	return midnight.Add(t.Sub(midnight) / step * step)
+}
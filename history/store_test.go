@@ -0,0 +1,225 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var day0 = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func openTestStore(t *testing.T, opts Options) *Store {
+	t.Helper()
+	s, err := Open(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s
+}
+
+func TestAppendAndQuery(t *testing.T) {
+	s := openTestStore(t, DefaultOptions())
+
+	points := []Point{
+		{day0.Add(23 * time.Hour), "q1", 1},
+		{day0.Add(23 * time.Hour), "presion", 4.5},
+		{day0.Add(25 * time.Hour), "q1", 0},
+		{day0.Add(26 * time.Hour), "presion", 4.7},
+	}
+	if err := s.Append(points); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Query([]string{"q1"}, day0, day0.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Value != 1 || got[1].Value != 0 {
+		t.Fatalf("unexpected q1 points: %v", got)
+	}
+
+	got, err = s.Query(nil, day0.Add(24*time.Hour), day0.Add(26*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Tag != "q1" {
+		t.Fatalf("range should be [from, to): %v", got)
+	}
+}
+
+func TestQuerySkipsTruncatedLine(t *testing.T) {
+	s := openTestStore(t, DefaultOptions())
+	if err := s.Append([]Point{{day0, "q1", 1}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, "20260301.csv"), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("1772323200500,q")
+	_ = f.Close()
+
+	got, err := s.Query(nil, day0, day0.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 point, got %v", got)
+	}
+}
+
+func TestMaintain_DownsampleAndRetention(t *testing.T) {
+	opts := Options{
+		MaxAge:          10 * 24 * time.Hour,
+		DownsampleAfter: 2 * 24 * time.Hour,
+		DownsampleStep:  5 * time.Minute,
+	}
+	s := openTestStore(t, opts)
+
+	points := make([]Point, 0)
+	for day := 0; day < 14; day++ {
+		for i := 0; i < 20; i++ {
+			// un punto cada 30 s durante 10 min -> 2 intervalos de 5 min
+			points = append(points, Point{day0.Add(time.Duration(day)*24*time.Hour + time.Duration(i)*30*time.Second), "a1", float64(i)})
+		}
+	}
+	if err := s.Append(points); err != nil {
+		t.Fatal(err)
+	}
+
+	now := day0.Add(13*24*time.Hour + 12*time.Hour)
+	if err := s.Maintain(now); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := s.dayFiles()
+	if files[0].day != "20260304" {
+		t.Errorf("days older than MaxAge should be removed, first day is %s", files[0].day)
+	}
+
+	old, _ := s.Query([]string{"a1"}, day0.Add(5*24*time.Hour), day0.Add(6*24*time.Hour))
+	if len(old) != 2 || old[0].Value != 9 || old[1].Value != 19 {
+		t.Errorf("old day should keep last value per 5 min: %v", old)
+	}
+
+	recent, _ := s.Query([]string{"a1"}, day0.Add(12*24*time.Hour), day0.Add(13*24*time.Hour))
+	if len(recent) != 20 {
+		t.Errorf("recent day should keep every sample, got %d", len(recent))
+	}
+}
+
+func TestMaintain_MaxBytes(t *testing.T) {
+	s := openTestStore(t, Options{MaxBytes: 1})
+
+	for day := 0; day < 3; day++ {
+		if err := s.Append([]Point{{day0.Add(time.Duration(day) * 24 * time.Hour), "q1", 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Maintain(day0.Add(2 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := s.dayFiles()
+	if len(files) != 1 || files[0].day != "20260303" {
+		t.Fatalf("only today should survive the size limit, got %v", files)
+	}
+}
+
+func TestMaintain_LatePointAfterDownsample(t *testing.T) {
+	s := openTestStore(t, Options{DownsampleAfter: 2 * 24 * time.Hour, DownsampleStep: 5 * time.Minute})
+	if err := s.Append([]Point{{day0.Add(8 * time.Hour), "q1", 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// el archivo del día sigue abierto cuando se reduce
+	if err := s.Maintain(day0.Add(3 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append([]Point{{day0.Add(9 * time.Hour), "q1", 0}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Query(nil, day0, day0.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Value != 1 || got[1].Value != 0 {
+		t.Fatalf("late point should be kept next to the downsampled day: %v", got)
+	}
+
+	if err := s.Maintain(day0.Add(3 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := s.dayFiles()
+	if len(files) != 1 || !files[0].downsampled || files[0].ds != "" {
+		t.Fatalf("the late points should be merged into the downsampled file: %+v", files)
+	}
+	if got, _ := s.Query(nil, day0, day0.Add(24*time.Hour)); len(got) != 2 {
+		t.Errorf("merged day: %v", got)
+	}
+}
+
+func TestQuery_InterruptedDownsample(t *testing.T) {
+	s := openTestStore(t, DefaultOptions())
+	if err := s.Append([]Point{{day0, "q1", 1}, {day0.Add(time.Minute), "q1", 0}}); err != nil {
+		t.Fatal(err)
+	}
+	// corte entre el rename del reducido y el borrado del crudo
+	if err := os.WriteFile(filepath.Join(s.dir, "20260301.ds.csv"), []byte("1772323260000,q1,0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Query(nil, day0, day0.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("points in both files should be returned once: %v", got)
+	}
+}
+
+func TestResample_LocalDays(t *testing.T) {
+	lima, err := time.LoadLocation("America/Lima")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 22:00 y 23:00 del 28/02 en Lima ya son 1/03 en UTC
+	points := []Point{
+		{day0.Add(3 * time.Hour), "q1", 1},
+		{day0.Add(4 * time.Hour), "q1", 0},
+		{day0.Add(6 * time.Hour), "q1", 1},
+		{day0.Add(20 * time.Hour), "q1", 0},
+	}
+	got := Resample(points, 24*time.Hour, lima)
+	want := []Point{
+		{time.Date(2026, 2, 28, 0, 0, 0, 0, lima), "q1", 0},
+		{time.Date(2026, 3, 1, 0, 0, 0, 0, lima), "q1", 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Value != want[i].Value {
+			t.Errorf("bucket %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// en UTC todos caen en el mismo día
+	if got := Resample(points, 24*time.Hour, time.UTC); len(got) != 1 || !got[0].Time.Equal(day0) {
+		t.Errorf("UTC buckets: %v", got)
+	}
+}
+
+func TestMaintain_SubMillisecondStep(t *testing.T) {
+	s := openTestStore(t, Options{DownsampleAfter: 24 * time.Hour, DownsampleStep: 500 * time.Microsecond})
+	if err := s.Append([]Point{{day0, "q1", 1}, {day0.Add(time.Second), "q1", 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Maintain(day0.Add(3 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Query(nil, day0, day0.Add(time.Hour)); len(got) != 2 {
+		t.Errorf("points a step apart should be kept: %v", got)
+	}
+}
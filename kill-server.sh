@@ -1,4 +0,0 @@
-#!/bin/bash
-
-PID=$(pgrep -f 'python main.py')
-kill "$PID"
\ No newline at end of file
@@ -1,17 +1,34 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mt-plc-control/alarms"
+	"mt-plc-control/clock"
+	"mt-plc-control/counters"
+	"mt-plc-control/diagnostics"
+	"mt-plc-control/history"
 	"mt-plc-control/modbusClient"
+	"mt-plc-control/mqttClient"
+	"mt-plc-control/quality"
+	"mt-plc-control/runHours"
+	"mt-plc-control/uplink"
 	"mt-plc-control/wailonServer"
+	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/goburrow/serial"
 	"github.com/joho/godotenv"
 )
 
@@ -47,62 +64,498 @@ func ParseAddrMap(fields string) *AddrMap {
 	return &AddrMap{logo, name, addr}
 }
 
-// getBit extrae un bit (posición bitIndex) del slice de bytes devuelto por Modbus
+// Config es la configuración del gateway, leída de las variables de entorno
+type Config struct {
+	Imei           string
+	Mock           bool
+	ModbusAddr     string
+	ModbusTimeout  time.Duration
+	ModbusGap      time.Duration // pausa mínima entre tramas Modbus
+	BreakerFails   int           // peticiones fallidas seguidas que abren el breaker; 0 lo desactiva
+	BreakerProbe   time.Duration // cada cuánto se prueba el PLC con el breaker abierto
+	MinTimeout     time.Duration // piso del timeout adaptativo; 0 usa ModbusTimeout fijo
+	ModbusRecord   string        // captura de las peticiones Modbus (JSON lines)
+	ModbusReplay   string        // reemplaza el PLC por una captura
+	WailonUrl      string
+	WailonPort     string
+	AddrRead       *AddrMap
+	AddrWrite      *AddrMap
+	AddrAnalog     *AddrMap
+	Counters       map[string]bool
+	CountersCache  string
+	RuntimeTags    []string
+	RuntimeCache   string
+	Limits         map[string]quality.Limits // fuera de rango: calidad out-of-range
+	Substitutes    map[string]float64        // valor a subir cuando no se puede leer el tag
+	HistoryDir     string                    // vacío desactiva el histórico
+	History        history.Options
+	SentCache      string
+	BackfillBatch  int
+	BackfillGap    time.Duration
+	GensetCommands bool
+	PollPeriod     time.Duration
+	UploadPeriod   time.Duration
+	Diagnostics    map[string]bool // grupos de diagnostics que se suben con los datos
+	DiagCache      string          // contador de arranques
+	MetricsAddr    string          // dirección de /metrics para Prometheus; vacío lo desactiva
+	APIAddr        string          // API local en la LAN (IP o interfaz:puerto); vacío la desactiva
+	APIToken       string
+	MQTT           mqttClient.Options // Addr vacío desactiva MQTT
+	MQTTTopic      string             // prefijo de los topics
+	MQTTMode       string             // tags, snapshot o sparkplug
+	Sparkplug      struct{ Group, Node, Device string }
+	ModbusServer   string        // servidor Modbus TCP con la imagen de los tags (IP o interfaz:puerto); vacío lo desactiva
+	ModbusImage    []imageEntry  // mapa de la imagen de registros
+	RTU            serial.Config // bus RS-485 (RTU_PORT); el timeout es el de TIMEOUT_MODBUS
+	ModbusRTU      bool          // el PLC se lee por el bus RTU, como unit 1, en lugar de ADDR_MODBUS
+	RTUGateway     string        // Modbus TCP reenviado al bus RTU (IP o interfaz:puerto); vacío lo desactiva
+}
+
+// runOptions son los ganchos de las pruebas; main corre con los valores cero
+type runOptions struct {
+	clock     clock.Clock                        // nil usa el reloj real
+	afterTick func()                             // aviso de fin de cada escaneo
+	openRTU   func() (io.ReadWriteCloser, error) // bus RTU simulado
+	readWait  time.Duration                      // espera de los comandos de Wialon; 0 usa la de WailonConnection
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func loadConfig() (Config, error) {
+	cfg := Config{
+		Imei:           os.Getenv("IMEI"),
+		Mock:           os.Getenv("MOCK") == "1",
+		ModbusAddr:     fmt.Sprintf("%s:%s", os.Getenv("ADDR_MODBUS"), os.Getenv("PORT_MODBUS")),
+		ModbusTimeout:  2500 * time.Millisecond,
+		ModbusGap:      modbusClient.DefaultMinGap,
+		BreakerProbe:   2 * time.Minute,
+		ModbusRecord:   os.Getenv("MODBUS_RECORD"),
+		ModbusReplay:   os.Getenv("MODBUS_REPLAY"),
+		WailonUrl:      os.Getenv("URL_WAILON"),
+		WailonPort:     os.Getenv("PORT_WAILON"),
+		AddrRead:       ParseAddrMap(os.Getenv("REGISTERS_READ")),
+		AddrWrite:      ParseAddrMap(os.Getenv("REGISTERS_WRITE")),
+		AddrAnalog:     ParseAddrMap(os.Getenv("REGISTERS_ANALOG")),
+		Counters:       counters.ParseNames(os.Getenv("COUNTERS")),
+		CountersCache:  envOr("COUNTERS_CACHE", "counters.gob"),
+		RuntimeTags:    runHours.ParseNames(os.Getenv("RUNTIME_TAGS")),
+		RuntimeCache:   envOr("RUNTIME_CACHE", "runtime.gob"),
+		HistoryDir:     envOr("HISTORY_DIR", "history"),
+		History:        history.DefaultOptions(),
+		SentCache:      envOr("SENT_CACHE", "sent.gob"),
+		BackfillBatch:  50,
+		BackfillGap:    2 * time.Second,
+		GensetCommands: os.Getenv("GENSET_COMMANDS") == "true",
+		DiagCache:      envOr("DIAG_CACHE", "diagnostics.gob"),
+		MetricsAddr:    os.Getenv("METRICS_ADDR"),
+		APIAddr:        os.Getenv("API_ADDR"),
+		APIToken:       os.Getenv("API_TOKEN"),
+		MQTT: mqttClient.Options{
+			Addr:     os.Getenv("MQTT_ADDR"),
+			ClientID: envOr("MQTT_CLIENT_ID", "gw-"+os.Getenv("IMEI")),
+			Username: os.Getenv("MQTT_USER"),
+			Password: os.Getenv("MQTT_PASSWORD"),
+		},
+		MQTTTopic:    envOr("MQTT_TOPIC", "plc/"+os.Getenv("IMEI")),
+		MQTTMode:     envOr("MQTT_MODE", mqttModeTags),
+		ModbusServer: os.Getenv("MODBUS_SERVER_ADDR"),
+		RTU: serial.Config{
+			Address:  os.Getenv("RTU_PORT"),
+			BaudRate: 9600,
+			DataBits: 8,
+			StopBits: 1,
+			Parity:   envOr("RTU_PARITY", "E"),
+		},
+		ModbusRTU:  os.Getenv("MODBUS_RTU") == "1",
+		RTUGateway: os.Getenv("RTU_GATEWAY_ADDR"),
+	}
+	cfg.Sparkplug.Group = envOr("SPARKPLUG_GROUP", "mt-plc")
+	cfg.Sparkplug.Node = envOr("SPARKPLUG_NODE", cfg.Imei)
+	cfg.Sparkplug.Device = envOr("SPARKPLUG_DEVICE", "plc")
+	for _, m := range []struct {
+		env  string
+		addr *AddrMap
+	}{{"REGISTERS_READ", cfg.AddrRead}, {"REGISTERS_WRITE", cfg.AddrWrite}, {"REGISTERS_ANALOG", cfg.AddrAnalog}} {
+		if m.addr == nil {
+			return cfg, fmt.Errorf("malformed %s: want one logo,name,address per line", m.env)
+		}
+	}
+	var err error
+	if cfg.Limits, err = quality.ParseLimits(os.Getenv("QUALITY_LIMITS")); err != nil {
+		return cfg, err
+	}
+	if cfg.Substitutes, err = quality.ParseSubstitutes(os.Getenv("QUALITY_SUBSTITUTES")); err != nil {
+		return cfg, err
+	}
+	if cfg.Diagnostics, err = diagnostics.ParseGroups(os.Getenv("DIAGNOSTICS")); err != nil {
+		return cfg, err
+	}
+	if cfg.ModbusImage, err = parseImageMap(os.Getenv("MODBUS_SERVER_MAP"), cfg.AddrWrite); err != nil {
+		return cfg, err
+	}
+	if cfg.ModbusServer != "" && len(cfg.ModbusImage) == 0 {
+		return cfg, fmt.Errorf("MODBUS_SERVER_ADDR requires MODBUS_SERVER_MAP")
+	}
+	if (cfg.ModbusRTU || cfg.RTUGateway != "") && cfg.RTU.Address == "" {
+		return cfg, fmt.Errorf("MODBUS_RTU and RTU_GATEWAY_ADDR require RTU_PORT")
+	}
+	if cfg.APIAddr != "" && cfg.APIToken == "" {
+		return cfg, fmt.Errorf("API_ADDR requires API_TOKEN")
+	}
+	switch cfg.MQTTMode {
+	case mqttModeTags, mqttModeSnapshot, mqttModeSparkplug:
+	default:
+		return cfg, fmt.Errorf("MQTT_MODE must be %s, %s or %s", mqttModeTags, mqttModeSnapshot, mqttModeSparkplug)
+	}
+	if timeoutMs, err := strconv.Atoi(os.Getenv("TIMEOUT_MODBUS")); err == nil {
+		cfg.ModbusTimeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	if baud, err := strconv.Atoi(os.Getenv("RTU_BAUD")); err == nil && baud > 0 {
+		cfg.RTU.BaudRate = baud
+	}
+	if bits, err := strconv.Atoi(os.Getenv("RTU_STOP_BITS")); err == nil && bits > 0 {
+		cfg.RTU.StopBits = bits
+	}
+	if gapMs, err := strconv.Atoi(os.Getenv("MODBUS_GAP_MS")); err == nil {
+		cfg.ModbusGap = time.Duration(gapMs) * time.Millisecond
+	}
+	if n, err := strconv.Atoi(os.Getenv("MODBUS_BREAKER_FAILS")); err == nil {
+		cfg.BreakerFails = n
+	}
+	if sec, err := strconv.Atoi(os.Getenv("MODBUS_PROBE_SEC")); err == nil && sec > 0 {
+		cfg.BreakerProbe = time.Duration(sec) * time.Second
+	}
+	if ms, err := strconv.Atoi(os.Getenv("MODBUS_MIN_TIMEOUT_MS")); err == nil {
+		cfg.MinTimeout = time.Duration(ms) * time.Millisecond
+	}
+	if cfg.HistoryDir == "off" {
+		cfg.HistoryDir = ""
+	}
+	if days, err := strconv.Atoi(os.Getenv("HISTORY_MAX_DAYS")); err == nil {
+		cfg.History.MaxAge = time.Duration(days) * 24 * time.Hour
+	}
+	if mb, err := strconv.Atoi(os.Getenv("HISTORY_MAX_MB")); err == nil {
+		cfg.History.MaxBytes = int64(mb) << 20
+	}
+	if days, err := strconv.Atoi(os.Getenv("HISTORY_DOWNSAMPLE_DAYS")); err == nil {
+		cfg.History.DownsampleAfter = time.Duration(days) * 24 * time.Hour
+	}
+	if step, err := time.ParseDuration(os.Getenv("HISTORY_DOWNSAMPLE_STEP")); err == nil {
+		if step > 0 && step < time.Second {
+			return cfg, fmt.Errorf("HISTORY_DOWNSAMPLE_STEP must be at least 1s, got %s", step)
+		}
+		cfg.History.DownsampleStep = step
+	}
+	if n, err := strconv.Atoi(os.Getenv("BACKFILL_BATCH")); err == nil && n > 0 {
+		cfg.BackfillBatch = n
+	}
+	if ms, err := strconv.Atoi(os.Getenv("BACKFILL_GAP_MS")); err == nil {
+		cfg.BackfillGap = time.Duration(ms) * time.Millisecond
+	}
+	return cfg, nil
+}
 
 func main() {
 	//ENV
 	_ = godotenv.Load()
-	Imei := os.Getenv("IMEI")
-	isMock := os.Getenv("MOCK")
-	AddrModbus := os.Getenv("ADDR_MODBUS")
-	PortModbus := os.Getenv("PORT_MODBUS")
-	UrlWailon := os.Getenv("URL_WAILON")
-	PortWailon := os.Getenv("PORT_WAILON")
-	addrRead := ParseAddrMap(os.Getenv("REGISTERS_READ"))
-	addrWrite := ParseAddrMap(os.Getenv("REGISTERS_WRITE"))
-	addrAnalog := ParseAddrMap(os.Getenv("REGISTERS_ANALOG"))
-
-	if addrRead == nil || addrWrite == nil {
-		log.Fatal("Malformed REGISTER_READ(WRITE)")
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		return
 	}
 
-	timeoutMs, err := strconv.Atoi(os.Getenv("TIMEOUT_MODBUS"))
-	if err != nil {
-		timeoutMs = 2500
-	}
 	period := flag.Int("period", 30, "Periodo de polling en s")
 	uploadMin := flag.Int("uploadMin", 10, "Periodo para upload en min")
 	flag.Parse()
 
-	plcAddr := fmt.Sprintf("%s:%s", AddrModbus, PortModbus)
-	plcConn, err := modbusClient.NewModbusConn(plcAddr, time.Duration(timeoutMs)*time.Millisecond)
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.PollPeriod = time.Duration(*period) * time.Second
+	cfg.UploadPeriod = time.Duration(*uploadMin) * time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := run(ctx, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openRTUBus abre el bus RTU de RTU_PORT; unit es el esclavo de las lecturas de ModbusConn
+func openRTUBus(cfg Config, opt runOptions, unit byte) (*modbusClient.ModbusConn, error) {
+	open := opt.openRTU
+	if open == nil {
+		open = modbusClient.SerialPort(cfg.RTU)
+	}
+	conn, err := modbusClient.NewRTUConn(open, cfg.ModbusTimeout, unit)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo abrir el bus RTU en %s: %w", cfg.RTU.Address, err)
+	}
+	return conn, nil
+}
+
+// openPLC conecta al PLC, por TCP o por el bus RTU, o a la captura de
+// MODBUS_REPLAY si está definida
+func openPLC(cfg Config, opt runOptions) (*modbusClient.ModbusConn, error) {
+	if cfg.ModbusReplay != "" {
+		f, err := os.Open(cfg.ModbusReplay)
+		if err != nil {
+			return nil, fmt.Errorf("opening modbus replay: %w", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		log.Printf("replaying modbus session from %s", cfg.ModbusReplay)
+		return modbusClient.NewReplayConn(f)
+	}
+	if cfg.ModbusRTU {
+		return openRTUBus(cfg, opt, 1)
+	}
+	plcConn, err := modbusClient.NewModbusConn(cfg.ModbusAddr, cfg.ModbusTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo conectar al PLC en %s: %w", cfg.ModbusAddr, err)
+	}
+	return plcConn, nil
+}
+
+// serveHTTP atiende h en addr hasta que se cancele ctx; devuelve la dirección
+// en la que quedó escuchando.
+func serveHTTP(ctx context.Context, addr string, h http.Handler) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatalf("no se pudo conectar al PLC en %s: %v", plcAddr, err)
+		return nil, err
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("http server on %s: %v", ln.Addr(), err)
+		}
+	}()
+	return ln.Addr(), nil
+}
+
+// configHash identifica la configuración en uso, para ver desde Wialon qué equipos difieren
+func configHash(cfg Config) string {
+	h := sha256.New()
+	for _, m := range []*AddrMap{cfg.AddrRead, cfg.AddrWrite, cfg.AddrAnalog} {
+		if m != nil {
+			fmt.Fprintf(h, "%v|", *m)
+		}
+	}
+	cfg.AddrRead, cfg.AddrWrite, cfg.AddrAnalog = nil, nil, nil
+	fmt.Fprintf(h, "%+v", cfg)
+	return hex.EncodeToString(h.Sum(nil))[:8]
+}
+
+// run conecta al PLC y a Wialon y corre el poll loop hasta que se cancele ctx
+func run(ctx context.Context, cfg Config) error {
+	return runWith(ctx, cfg, runOptions{})
+}
+
+// runWith es run con los ganchos de las pruebas
+func runWith(ctx context.Context, cfg Config, opt runOptions) error {
+	cnt := counters.NewStore(cfg.CountersCache, cfg.Counters)
+	rh := runHours.NewTracker(cfg.RuntimeCache, cfg.RuntimeTags)
+	var hist *history.Store
+	if cfg.HistoryDir != "" {
+		h, err := history.Open(cfg.HistoryDir, cfg.History)
+		if err != nil {
+			log.Printf("history disabled: %v", err)
+		} else {
+			hist = h
+			defer func() {
+				_ = hist.Close()
+			}()
+		}
+	}
+
+	plcConn, err := openPLC(cfg, opt)
+	if err != nil {
+		return err
+	}
+	plcConn.Clock = opt.clock
+	plcConn.MinGap = cfg.ModbusGap
+	if cfg.BreakerFails > 0 {
+		plcConn.Breaker = modbusClient.NewBreaker(cfg.BreakerFails, cfg.BreakerProbe)
+	}
+	if cfg.MinTimeout > 0 {
+		plcConn.AdaptTimeout(cfg.MinTimeout)
 	}
 	defer func() {
 		_ = plcConn.Close()
 	}()
+	if cfg.ModbusRecord != "" {
+		f, err := os.OpenFile(cfg.ModbusRecord, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening modbus capture: %w", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		plcConn.Record(f)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if cfg.RTUGateway != "" {
+		addr, err := lanAddr(cfg.RTUGateway)
+		if err != nil {
+			return fmt.Errorf("rtu gateway: %w", err)
+		}
+		// con el PLC en el mismo bus se comparte su conexión, y con ella la cola
+		bus := plcConn
+		if !cfg.ModbusRTU {
+			if bus, err = openRTUBus(cfg, opt, 1); err != nil {
+				return err
+			}
+			bus.Clock = opt.clock
+			defer func() {
+				_ = bus.Close()
+			}()
+		}
+		gw, err := startRTUGateway(ctx, addr, bus)
+		if err != nil {
+			return fmt.Errorf("rtu gateway: %w", err)
+		}
+		log.Printf("rtu gateway on %s to %s", gw.Addr(), cfg.RTU.Address)
+	}
+
 	var wailonCon IDataIO
 
-	if isMock == "1" {
-		wailonCon = wailonServer.NewMockServer(UrlWailon, PortWailon)
-	} else {
-		wailonCon = &wailonServer.WailonConnection{Imei: Imei, Url: UrlWailon, Port: PortWailon}
+	UrlWailon, PortWailon := cfg.WailonUrl, cfg.WailonPort
+	if cfg.Mock {
+		// servidor Wialon IPS local: los paquetes se registran en el log
+		mock := wailonServer.NewIPSServer()
+		mock.Logf = log.Printf
+		if err := mock.Start(net.JoinHostPort(UrlWailon, PortWailon)); err != nil {
+			return fmt.Errorf("no se pudo iniciar el servidor wailon local: %w", err)
+		}
+		defer mock.Close()
+		UrlWailon, PortWailon, _ = net.SplitHostPort(mock.Addr())
 	}
+	wc := &wailonServer.WailonConnection{Imei: cfg.Imei, Url: UrlWailon, Port: PortWailon, Clock: opt.clock, ReadWait: opt.readWait}
+	wailonCon = wc
+
+	qt := quality.NewTracker(cfg.Limits, cfg.Substitutes)
+	var bf *wailonServer.Backfill
+	var mt *gatewayMetrics
+	if cfg.MetricsAddr != "" {
+		mt = newGatewayMetrics(cfg.Imei, plcConn, wc, func() int {
+			return bf.Outbox()
+		}, qt)
+	}
+
 	err = wailonCon.OpenSocket()
 	if err != nil {
-		log.Fatalf("no se pudo conectar al servidor wailon: %v", err)
+		return fmt.Errorf("no se pudo conectar al servidor wailon: %w", err)
 	}
 	defer func() {
 		wailonCon.CloseSocket()
 	}()
 
 	log.Printf("Conectado a %s", UrlWailon)
-	pollLoop(ctx, plcConn, wailonCon, addrRead, addrWrite, addrAnalog,
-		time.Duration(*period)*time.Second,
-		time.Duration(*uploadMin)*time.Minute)
+
+	if sender, ok := wailonCon.(wailonServer.BlackBoxSender); ok && hist != nil {
+		bf = wailonServer.NewBackfill(cfg.Imei, wailonServer.NewSentCache(cfg.SentCache), hist, sender)
+		bf.BatchSize = cfg.BackfillBatch
+		bf.BatchGap = cfg.BackfillGap
+		bf.Clock = opt.clock
+		go bf.Run(ctx)
+	}
+
+	var diag *diagnostics.Collector
+	if len(cfg.Diagnostics) > 0 {
+		diag = &diagnostics.Collector{
+			Enabled:    cfg.Diagnostics,
+			Started:    clock.Or(opt.clock).Now(),
+			Modbus:     plcConn.Stats,
+			DiskPath:   cmp.Or(cfg.HistoryDir, "."),
+			ConfigHash: configHash(cfg),
+			Version:    diagnostics.BinaryVersion(),
+			Wialon: func(time.Time) (int64, int) {
+				return wc.Reconnects(), bf.Outbox()
+			},
+		}
+		if cfg.Diagnostics[diagnostics.Restarts] {
+			diag.Restarts = diagnostics.CountStart(cfg.DiagCache)
+		}
+	}
+
+	// Wialon primero: es el único uplink que sube sincrónicamente
+	uplinks := &uplink.Registry{}
+	table := alarms.NewTable()
+	uplinks.Add(newWialonUplink(wailonCon, bf, diag, table, opt.clock))
+	switch {
+	case cfg.MQTT.Addr == "":
+	case cfg.MQTTMode == mqttModeSparkplug:
+		sp := cfg.Sparkplug
+		uplinks.Add(newSparkplugNode(cfg.MQTT, sp.Group, sp.Node, sp.Device, sparkplugTags(cfg.AddrRead, cfg.AddrAnalog), opt.clock))
+		log.Printf("sparkplug B uplink to %s as %s/%s/%s", cfg.MQTT.Addr, sp.Group, sp.Node, sp.Device)
+	default:
+		uplinks.Add(newMQTTUplink(cfg.MQTT, cfg.MQTTTopic, cfg.MQTTMode, opt.clock))
+		log.Printf("mqtt uplink to %s on %s/#", cfg.MQTT.Addr, cfg.MQTTTopic)
+	}
+	if cfg.ModbusServer != "" {
+		addr, err := lanAddr(cfg.ModbusServer)
+		if err != nil {
+			return fmt.Errorf("modbus server: %w", err)
+		}
+		img := newModbusImage(cfg.ModbusImage)
+		if err := img.Start(addr); err != nil {
+			return fmt.Errorf("modbus server: %w", err)
+		}
+		uplinks.Add(img)
+		log.Printf("modbus server on %s with %d entries", img.Addr(), len(cfg.ModbusImage))
+	}
+
+	g := &gateway{
+		plcConn:        plcConn,
+		uplinks:        uplinks,
+		addrRead:       cfg.AddrRead,
+		addrWrite:      cfg.AddrWrite,
+		addrAnalog:     cfg.AddrAnalog,
+		cnt:            cnt,
+		rh:             rh,
+		hist:           hist,
+		quality:        qt,
+		bf:             bf,
+		alarms:         table,
+		gensetCommands: cfg.GensetCommands,
+		pollPeriod:     cfg.PollPeriod,
+		uploadPeriod:   cfg.UploadPeriod,
+		clock:          opt.clock,
+		afterTick:      opt.afterTick,
+		refresh:        make(chan struct{}, 1),
+	}
+	if mt != nil {
+		if err := mt.serve(ctx, cfg.MetricsAddr); err != nil {
+			return fmt.Errorf("metrics: %w", err)
+		}
+		g.scanned = mt.scanned
+	}
+	if cfg.APIAddr != "" {
+		addr, err := lanAddr(cfg.APIAddr)
+		if err != nil {
+			return fmt.Errorf("local api: %w", err)
+		}
+		api := &localAPI{ctx: ctx, device: cfg.Imei, token: cfg.APIToken, g: g}
+		ln, err := serveHTTP(ctx, addr, api.handler())
+		if err != nil {
+			return fmt.Errorf("local api: %w", err)
+		}
+		log.Printf("local api on http://%s/api", ln)
+	}
+	return pollLoop(ctx, g)
 }
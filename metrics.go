@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"mt-plc-control/metrics"
+	"mt-plc-control/modbusClient"
+	"mt-plc-control/quality"
+	"mt-plc-control/wailonServer"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// gatewayMetrics son las métricas que se exponen en METRICS_ADDR para Prometheus
+type gatewayMetrics struct {
+	reg          *metrics.Registry
+	scan         *metrics.Histogram
+	wialon       *metrics.Histogram
+	wialonErrors *metrics.Counter
+}
+
+// functionNames nombra los códigos de función Modbus en la etiqueta function
+var functionNames = map[byte]string{
+	modbus.FuncCodeReadCoils:              "read_coils",
+	modbus.FuncCodeReadDiscreteInputs:     "read_discrete_inputs",
+	modbus.FuncCodeReadHoldingRegisters:   "read_holding_registers",
+	modbus.FuncCodeReadInputRegisters:     "read_input_registers",
+	modbus.FuncCodeWriteSingleCoil:        "write_single_coil",
+	modbus.FuncCodeWriteSingleRegister:    "write_single_register",
+	modbus.FuncCodeWriteMultipleCoils:     "write_multiple_coils",
+	modbus.FuncCodeWriteMultipleRegisters: "write_multiple_registers",
+}
+
+func functionName(fc byte) string {
+	if name, ok := functionNames[fc]; ok {
+		return name
+	}
+	return strconv.Itoa(int(fc))
+}
+
+// newGatewayMetrics registra las métricas del gateway y se engancha a las
+// respuestas de wc; device es la etiqueta de los tags (el IMEI) y outbox
+// devuelve los escaneos pendientes de subir.
+func newGatewayMetrics(device string, plcConn *modbusClient.ModbusConn,
+	wc *wailonServer.WailonConnection, outbox func() int, qt *quality.Tracker) *gatewayMetrics {
+	reg := metrics.NewRegistry()
+	m := &gatewayMetrics{
+		reg: reg,
+		scan: reg.Histogram("gateway_poll_duration_seconds",
+			"Duration of each poll scan, including the upload to Wialon.", metrics.DefaultBuckets),
+		wialon: reg.Histogram("wialon_response_seconds",
+			"Time until the Wialon server answered each packet.", metrics.DefaultBuckets, "packet"),
+		wialonErrors: reg.Counter("wialon_errors_total",
+			"Packets sent to Wialon without a valid answer.", "packet"),
+	}
+
+	modbusCounter := func(name, help string, get func(modbusClient.Counts) int64) {
+		reg.CounterFunc(name, help, []string{"function"}, func(emit func(float64, ...string)) {
+			fns := plcConn.Stats().Functions
+			for _, fc := range slices.Sorted(maps.Keys(fns)) {
+				emit(float64(get(fns[fc])), functionName(fc))
+			}
+		})
+	}
+	modbusCounter("modbus_requests_total", "Modbus requests sent to the PLC.",
+		func(c modbusClient.Counts) int64 { return c.Requests })
+	modbusCounter("modbus_errors_total", "Modbus requests that failed, including timeouts and exceptions.",
+		func(c modbusClient.Counts) int64 { return c.Errors })
+	modbusCounter("modbus_timeouts_total", "Modbus requests without an answer.",
+		func(c modbusClient.Counts) int64 { return c.Timeouts })
+	modbusCounter("modbus_exceptions_total", "Modbus requests answered with an exception.",
+		func(c modbusClient.Counts) int64 { return c.Exceptions })
+	reg.GaugeFunc("modbus_latency_seconds", "Modbus response latency over the last responses.",
+		[]string{"quantile"}, func(emit func(float64, ...string)) {
+			st := plcConn.Stats()
+			emit(st.P50.Seconds(), "0.5")
+			emit(st.P95.Seconds(), "0.95")
+			emit(st.P99.Seconds(), "0.99")
+		})
+	reg.GaugeFunc("modbus_timeout_seconds", "Current Modbus response timeout.", nil,
+		func(emit func(float64, ...string)) {
+			emit(plcConn.Timeout().Seconds())
+		})
+	if plcConn.Breaker != nil {
+		reg.GaugeFunc("modbus_breaker_state", "PLC circuit breaker: 0 closed, 1 open, 2 half-open.", nil,
+			func(emit func(float64, ...string)) {
+				emit(float64(plcConn.Breaker.State()))
+			})
+	}
+
+	reg.CounterFunc("wialon_reconnects_total", "Successful logins to Wialon after a failure.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(wc.Reconnects()))
+		})
+	reg.GaugeFunc("wialon_outbox_scans", "Scans stored in history still waiting to be sent to Wialon.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(outbox()))
+		})
+
+	tagGauge := func(name, help string, get func(quality.Tag) float64) {
+		reg.GaugeFunc(name, help, []string{"device", "tag"}, func(emit func(float64, ...string)) {
+			tags := qt.Tags()
+			for _, tag := range slices.Sorted(maps.Keys(tags)) {
+				emit(get(tags[tag]), device, tag)
+			}
+		})
+	}
+	tagGauge("gateway_tag_value", "Current value of each tag.",
+		func(t quality.Tag) float64 { return t.Value })
+	tagGauge("gateway_tag_quality", "Quality of each tag: 0 good, 1 stale, 2 comm failure, 3 out of range, 4 substituted.",
+		func(t quality.Tag) float64 { return float64(t.Quality) })
+	tagGauge("gateway_tag_last_good_timestamp_seconds", "Unix time of the last good read of each tag.",
+		func(t quality.Tag) float64 {
+			if t.LastGood.IsZero() {
+				return 0
+			}
+			return float64(t.LastGood.UnixNano()) / 1e9
+		})
+
+	wc.OnResponse = m.wialonResponse
+	return m
+}
+
+func (m *gatewayMetrics) wialonResponse(kind string, latency time.Duration, err error) {
+	if err != nil {
+		m.wialonErrors.Add(1, kind)
+		return
+	}
+	m.wialon.Observe(latency.Seconds(), kind)
+}
+
+func (m *gatewayMetrics) scanned(d time.Duration) {
+	m.scan.Observe(d.Seconds())
+}
+
+// serve atiende /metrics en addr hasta que se cancele ctx
+func (m *gatewayMetrics) serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.reg)
+	ln, err := serveHTTP(ctx, addr, mux)
+	if err != nil {
+		return err
+	}
+	log.Printf("metrics on http://%s/metrics", ln)
+	return nil
+}
@@ -0,0 +1,271 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry junta métricas y las expone en el formato de texto de Prometheus
+// (version 0.0.4), sin dependencias externas. Las métricas se escriben en el
+// orden en que se registraron.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) add(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteTo escribe todas las métricas
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type desc struct {
+	name, help, kind string
+	labels           []string
+}
+
+func (d desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+}
+
+// sample escribe una línea name{labels} value
+func (d desc) sample(w *bufio.Writer, suffix string, values []string, extra string, v float64) {
+	w.WriteString(d.name + suffix)
+	if len(values) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, l := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=%q", l, escapeLabel(values[i]))
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func (d desc) check(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+}
+
+// Counter es un contador que solo crece, con etiquetas
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*counterValue
+	order  []string
+}
+
+type counterValue struct {
+	labels []string
+	v      float64
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter", labels}, values: make(map[string]*counterValue)}
+	r.add(name, c)
+	return c
+}
+
+// Add suma v a la serie con esos valores de etiqueta; nil no hace nada
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if c == nil {
+		return
+	}
+	c.check(labelValues)
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: slices.Clone(labelValues)}
+		c.values[key] = cv
+		c.order = append(c.order, key)
+	}
+	cv.v += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range c.order {
+		cv := c.values[key]
+		c.sample(w, "", cv.labels, "", cv.v)
+	}
+}
+
+// Histogram acumula observaciones en buckets
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histSeries
+	order   []string
+}
+
+type histSeries struct {
+	labels []string
+	counts []uint64 // por bucket, no acumulado
+	count  uint64
+	sum    float64
+}
+
+// DefaultBuckets sirve para latencias en segundos de unos ms a decenas de s
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name, help, "histogram", labels}, buckets: buckets, series: make(map[string]*histSeries)}
+	r.add(name, h)
+	return h
+}
+
+// Observe registra v; nil no hace nada
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	h.check(labelValues)
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histSeries{labels: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+		h.order = append(h.order, key)
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, key := range h.order {
+		s := h.series[key]
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += s.counts[i]
+			h.sample(w, "_bucket", s.labels, fmt.Sprintf("le=%q", formatFloat(le)), float64(cum))
+		}
+		h.sample(w, "_bucket", s.labels, `le="+Inf"`, float64(s.count))
+		h.sample(w, "_sum", s.labels, "", s.sum)
+		h.sample(w, "_count", s.labels, "", float64(s.count))
+	}
+}
+
+// funcFamily toma sus valores al momento de exponerlas
+type funcFamily struct {
+	desc
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// GaugeFunc registra un gauge cuyas series se leen en cada scrape: collect
+// llama a emit una vez por serie.
+func (r *Registry) GaugeFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	r.add(name, &funcFamily{desc{name, help, "gauge", labels}, collect})
+}
+
+// CounterFunc es como GaugeFunc para contadores que se llevan en otro lado
+func (r *Registry) CounterFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	r.add(name, &funcFamily{desc{name, help, "counter", labels}, collect})
+}
+
+func (f *funcFamily) write(w *bufio.Writer) {
+	f.header(w)
+	f.collect(func(value float64, labelValues ...string) {
+		f.check(labelValues)
+		f.sample(w, "", labelValues, "", value)
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel deja a %q el escape de \ y "; solo hay que evitar otros escapes de Go
+func escapeLabel(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
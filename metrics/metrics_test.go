@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Exposition(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("requests_total", "Requests sent.", "function")
+	c.Add(2, "read_coils")
+	c.Add(1, "write_single_coil")
+	c.Add(1, "read_coils")
+	h := reg.Histogram("scan_seconds", "Scan time.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(3)
+	reg.GaugeFunc("tag_value", "Tag value.", []string{"device", "tag"}, func(emit func(float64, ...string)) {
+		emit(1.5, "864", `a"b`)
+		emit(-2, "864", "c\\d")
+	})
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type: %s", ct)
+	}
+	want := `# HELP requests_total Requests sent.
+# TYPE requests_total counter
+requests_total{function="read_coils"} 3
+requests_total{function="write_single_coil"} 1
+# HELP scan_seconds Scan time.
+# TYPE scan_seconds histogram
+scan_seconds_bucket{le="0.1"} 2
+scan_seconds_bucket{le="1"} 2
+scan_seconds_bucket{le="+Inf"} 3
+scan_seconds_sum 3.15
+scan_seconds_count 3
+# HELP tag_value Tag value.
+# TYPE tag_value gauge
+tag_value{device="864",tag="a\"b"} 1.5
+tag_value{device="864",tag="c\\d"} -2
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistry_DuplicatePanics(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("x_total", "x")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a metric twice should panic")
+		}
+	}()
+	reg.Counter("x_total", "x")
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"mt-plc-control/modbusServer"
+	"mt-plc-control/uplink"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// imageEntry ubica un valor en la imagen de registros. Name es un tag, un
+// valor derivado (plc_comm, q1_run_s, ...) o <tag>_q con la calidad del tag.
+type imageEntry struct {
+	table   modbusServer.Table
+	address uint16
+	name    string
+	write   string  // coils: salida de ADDR_WRITE que reciben las escrituras; vacío es de solo lectura
+	format  string  // registros: u16, s16, u32 o f32 (32 bits con la palabra alta primero)
+	scale   float64 // se multiplica antes de codificar
+}
+
+// words es la cantidad de registros que ocupa
+func (e imageEntry) words() int {
+	if e.format == "u32" || e.format == "f32" {
+		return 2
+	}
+	return 1
+}
+
+var imageTables = map[string]modbusServer.Table{
+	"di":   modbusServer.DiscreteInputs,
+	"coil": modbusServer.Coils,
+	"ir":   modbusServer.InputRegisters,
+	"hr":   modbusServer.HoldingRegisters,
+}
+
+// parseImageMap lee MODBUS_SERVER_MAP: líneas o campos separados por coma
+// "tabla:dirección:nombre[:formato[:escala]]", con tabla di, coil, ir o hr.
+// Un coil "coil:dirección:nombre[:salida]" muestra nombre y manda sus
+// escrituras a la salida de outputs (ADDR_WRITE); sin salida se escribe en
+// nombre si es una salida y si no es de solo lectura. Los registros son de
+// solo lectura: una salida no puede ir en hr.
+func parseImageMap(list string, outputs *AddrMap) ([]imageEntry, error) {
+	isOutput := func(name string) bool {
+		return outputs != nil && slices.Contains(outputs.name, name)
+	}
+	entries := make([]imageEntry, 0)
+	for field := range strings.FieldsFuncSeq(list, func(r rune) bool { return r == ',' || r == '\n' }) {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.Split(field, ":")
+		if len(parts) < 3 || len(parts) > 5 {
+			return nil, fmt.Errorf("modbus server map %q: want table:address:name[:format[:scale]]", field)
+		}
+		t, ok := imageTables[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("modbus server map %q: unknown table %s", field, parts[0])
+		}
+		address, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("modbus server map %q: %w", field, err)
+		}
+		e := imageEntry{table: t, address: uint16(address), name: parts[2], format: "u16", scale: 1}
+		bits := t == modbusServer.DiscreteInputs || t == modbusServer.Coils
+		if t == modbusServer.Coils {
+			if len(parts) > 4 {
+				return nil, fmt.Errorf("modbus server map %q: want coil:address:name[:output]", field)
+			}
+			if len(parts) == 4 {
+				if !isOutput(parts[3]) {
+					return nil, fmt.Errorf("modbus server map %q: %s is not in ADDR_WRITE", field, parts[3])
+				}
+				e.write = parts[3]
+			} else if isOutput(e.name) {
+				e.write = e.name
+			}
+			parts = parts[:3]
+		}
+		if t == modbusServer.HoldingRegisters && isOutput(e.name) {
+			return nil, fmt.Errorf("modbus server map %q: holding registers are read-only, map output %s as a coil", field, e.name)
+		}
+		if len(parts) > 3 {
+			if bits {
+				return nil, fmt.Errorf("modbus server map %q: bits take no format", field)
+			}
+			switch e.format = parts[3]; e.format {
+			case "u16", "s16", "u32", "f32":
+			default:
+				return nil, fmt.Errorf("modbus server map %q: unknown format %s", field, e.format)
+			}
+		}
+		if len(parts) > 4 {
+			if e.scale, err = strconv.ParseFloat(parts[4], 64); err != nil {
+				return nil, fmt.Errorf("modbus server map %q: %w", field, err)
+			}
+		}
+		if int(e.address)+e.words() > 1<<16 {
+			return nil, fmt.Errorf("modbus server map %q: address out of range", field)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// encode pasa un valor a los registros de la entrada, saturando en los enteros
+func (e imageEntry) encode(value float64) []uint16 {
+	v := value * e.scale
+	switch e.format {
+	case "s16":
+		return []uint16{uint16(int16(math.Round(min(max(v, math.MinInt16), math.MaxInt16))))}
+	case "u32":
+		n := uint32(math.Round(min(max(v, 0), math.MaxUint32)))
+		return []uint16{uint16(n >> 16), uint16(n)}
+	case "f32":
+		n := math.Float32bits(float32(v))
+		return []uint16{uint16(n >> 16), uint16(n)}
+	}
+	return []uint16{uint16(math.Round(min(max(v, 0), math.MaxUint16)))}
+}
+
+// modbusImage expone los tags en un servidor Modbus TCP propio, para que un
+// HMI de la LAN los lea sin ocupar una conexión del LOGO!. Las escrituras de
+// coils con salida se reenvían al PLC como comandos; el resto de la imagen es
+// de lectura.
+type modbusImage struct {
+	server  *modbusServer.Server
+	entries []imageEntry
+	coils   map[uint16]string // coil escribible -> salida
+
+	mu   sync.Mutex
+	exec func(uplink.Command) error // nil hasta que arranca Run
+}
+
+func newModbusImage(entries []imageEntry) *modbusImage {
+	m := &modbusImage{server: modbusServer.NewServer(), entries: entries, coils: make(map[uint16]string)}
+	// solo las direcciones del mapa son válidas: un rango vacío por tabla
+	// deja afuera las tablas sin entradas
+	for _, t := range imageTables {
+		m.server.AddRange(t, 0, 0)
+	}
+	for _, e := range entries {
+		m.server.AddRange(e.table, e.address, e.words())
+		if e.table == modbusServer.Coils && e.write != "" {
+			m.coils[e.address] = e.write
+		}
+	}
+	m.server.OnWrite = m.write
+	return m
+}
+
+// Start abre el puerto antes de arrancar el gateway, para fallar enseguida
+// si está ocupado
+func (m *modbusImage) Start(addr string) error {
+	return m.server.Start(addr)
+}
+
+func (m *modbusImage) Addr() string {
+	return m.server.Addr()
+}
+
+func (m *modbusImage) Name() string {
+	return "modbus"
+}
+
+// Publish actualiza la imagen con cada escaneo; los valores derivados solo
+// llegan con los envíos y mientras tanto quedan los últimos
+func (m *modbusImage) Publish(_ context.Context, b uplink.Batch) error {
+	values := make(map[string]float64, 2*len(b.Samples)+len(b.Derived))
+	for _, s := range b.Samples {
+		values[s.Name] = s.Value
+		values[s.Name+"_q"] = float64(s.Quality)
+	}
+	for _, s := range b.Derived {
+		if s.Type != uplink.Text {
+			values[s.Name] = s.Value
+		}
+	}
+	for _, e := range m.entries {
+		v, ok := values[e.name]
+		if !ok {
+			continue
+		}
+		if e.table == modbusServer.DiscreteInputs || e.table == modbusServer.Coils {
+			m.server.SetBit(e.table, e.address, v != 0)
+			continue
+		}
+		for i, w := range e.encode(v) {
+			m.server.SetWord(e.table, e.address+uint16(i), w)
+		}
+	}
+	return nil
+}
+
+func (m *modbusImage) Run(ctx context.Context, exec func(uplink.Command) error) {
+	m.mu.Lock()
+	m.exec = exec
+	m.mu.Unlock()
+	<-ctx.Done()
+	m.mu.Lock()
+	m.exec = nil
+	m.mu.Unlock()
+	m.server.Close()
+}
+
+// write reenvía una escritura de coils al PLC por el mismo camino que los
+// comandos de los uplinks; solo se aplica a la imagen si el PLC la aceptó, y
+// el escaneo siguiente la reemplaza por el estado leído
+func (m *modbusImage) write(t modbusServer.Table, address uint16, values []uint16) error {
+	if t != modbusServer.Coils {
+		return modbusServer.Exception(modbusServer.IllegalDataAddress)
+	}
+	cmd := uplink.Command{Kind: uplink.Write}
+	for i, v := range values {
+		output, ok := m.coils[address+uint16(i)]
+		if !ok {
+			return modbusServer.Exception(modbusServer.IllegalDataAddress)
+		}
+		cmd.Writes = append(cmd.Writes, uplink.OutputWrite{Tag: output, Value: v != 0})
+	}
+	m.mu.Lock()
+	exec := m.exec
+	m.mu.Unlock()
+	if exec == nil {
+		return modbusServer.Exception(modbusServer.ServerDeviceBusy)
+	}
+	if err := exec(cmd); err != nil {
+		log.Printf("modbus %s: %v", cmd, err)
+		if errors.Is(err, errUnknownOutput) {
+			return modbusServer.Exception(modbusServer.IllegalDataAddress)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"mt-plc-control/modbusServer"
+	"slices"
+	"testing"
+)
+
+func TestParseImageMap(t *testing.T) {
+	outputs := ParseAddrMap("Q,q1,8192\nQ,q2_set,8193")
+	entries, err := parseImageMap("coil:0:q1, ir:10:energia:u32\nhr:5:nivel:s16:-0.5", outputs)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("got %v %v", entries, err)
+	}
+	if e := entries[1]; e.table != modbusServer.InputRegisters || e.address != 10 || e.format != "u32" || e.words() != 2 {
+		t.Errorf("u32 entry: %+v", e)
+	}
+	if got := entries[2].encode(3); !slices.Equal(got, []uint16{0xFFFE}) {
+		t.Errorf("scaled s16: %x", got)
+	}
+	if got := entries[1].encode(-1); !slices.Equal(got, []uint16{0, 0}) {
+		t.Errorf("negative u32 should saturate at 0: %v", got)
+	}
+	if e := entries[0]; e.write != "q1" {
+		t.Errorf("a coil named after an output should write to it: %+v", e)
+	}
+
+	// el estado de una salida y su orden en el mismo coil, o un coil de solo lectura
+	entries, err = parseImageMap("coil:0:q2:q2_set\ncoil:1:plc_comm", outputs)
+	if err != nil || entries[0].name != "q2" || entries[0].write != "q2_set" || entries[1].write != "" {
+		t.Errorf("coil outputs: %+v %v", entries, err)
+	}
+	for _, bad := range []string{"ir:1", "xx:1:a", "ir:70000:a", "coil:1:q1:u16", "coil:1:q2:q2_set:1", "di:1:q1:q1",
+		"ir:1:a:u64", "ir:65535:a:f32", "hr:1:a:u16:x", "hr:1:q1"} {
+		if _, err := parseImageMap(bad, outputs); err == nil {
+			t.Errorf("%q should fail", bad)
+		}
+	}
+}
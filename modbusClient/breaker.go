@@ -0,0 +1,93 @@
+package modbusClient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState es el estado del circuit breaker; el valor numérico es el que se sube a Wialon
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // peticiones normales
+	BreakerOpen                         // equipo caído: las peticiones fallan sin ir al bus
+	BreakerHalfOpen                     // una petición de prueba en curso
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+var ErrBreakerOpen = errors.New("modbus: circuit breaker open, device is down")
+
+// Breaker corta las peticiones a un equipo que dejó de responder: tras Threshold
+// peticiones seguidas con error de transporte o timeout (ya con sus reintentos)
+// se abre, y cada Probe deja pasar una sola petición, sin reintentos, para
+// ver si volvió. Las excepciones Modbus no cuentan: el equipo respondió.
+type Breaker struct {
+	Threshold int
+	Probe     time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	fails    int
+	openedAt time.Time
+}
+
+func NewBreaker(threshold int, probe time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Probe: probe}
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow decide si la petición pasa; probe indica que es la de prueba
+func (b *Breaker) allow(now time.Time) (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.Probe {
+			return false, ErrBreakerOpen
+		}
+		b.state = BreakerHalfOpen
+		return true, nil
+	case BreakerHalfOpen:
+		return false, ErrBreakerOpen
+	}
+	return false, nil
+}
+
+// done registra el resultado de una petición que pasó por allow
+func (b *Breaker) done(now time.Time, probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var mbErr *Error
+	if err != nil && !errors.As(err, &mbErr) {
+		// cancelada: no dice nada del equipo, la prueba se repite en la próxima
+		if probe {
+			b.state = BreakerOpen
+		}
+		return
+	}
+	if err == nil || mbErr.Class == ClassException {
+		b.state = BreakerClosed
+		b.fails = 0
+		return
+	}
+	b.fails++
+	if probe || b.fails >= b.Threshold {
+		b.state = BreakerOpen
+		b.openedAt = now
+	}
+}
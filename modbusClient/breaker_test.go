@@ -0,0 +1,136 @@
+package modbusClient
+
+import (
+	"errors"
+	"mt-plc-control/clock"
+	"mt-plc-control/modbusServer"
+	"testing"
+	"time"
+)
+
+func TestBreaker_OpensAndProbes(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	fake := clock.NewFake(time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC))
+	con.Clock = fake
+	con.Breaker = NewBreaker(2, time.Minute)
+
+	// una excepción no abre el breaker: el equipo respondió
+	if err := con.WriteCoil(8888, true); err == nil {
+		t.Fatal("write outside the LOGO! map should fail")
+	}
+	if s := con.Breaker.State(); s != BreakerClosed {
+		t.Fatalf("state after an exception: %s", s)
+	}
+
+	sim.SetOffline(true)
+	for i := 0; i < 2; i++ {
+		if _, err := con.ReadInputs([]uint16{0}); err == nil || errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("read %d: got %v, want a transport error", i, err)
+		}
+	}
+	if s := con.Breaker.State(); s != BreakerOpen {
+		t.Fatalf("state after 2 failed requests: %s", s)
+	}
+	sent := len(sim.Requests())
+	if _, err := con.ReadInputs([]uint16{0}); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("open breaker: got %v, want ErrBreakerOpen", err)
+	}
+	if n := len(sim.Requests()); n != sent {
+		t.Errorf("an open breaker should not reach the PLC, got %d requests", n-sent)
+	}
+
+	// la prueba falla: un solo intento y vuelve a abrirse
+	fake.Advance(time.Minute)
+	if _, err := con.ReadInputs([]uint16{0}); err == nil || errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("probe: got %v, want a transport error", err)
+	}
+	if n := len(sim.Requests()); n != sent+1 {
+		t.Errorf("the probe should be a single request, got %d", n-sent)
+	}
+	if s := con.Breaker.State(); s != BreakerOpen {
+		t.Fatalf("state after a failed probe: %s", s)
+	}
+	if _, err := con.ReadInputs([]uint16{0}); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("after a failed probe: got %v, want ErrBreakerOpen", err)
+	}
+
+	fake.Advance(time.Minute)
+	sim.SetOffline(false)
+	if got, err := con.ReadInputs([]uint16{1}); err != nil || !got[0] {
+		t.Fatalf("probe with the PLC back: %v %v", got, err)
+	}
+	if s := con.Breaker.State(); s != BreakerClosed {
+		t.Errorf("state after a good probe: %s", s)
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	a := &adaptiveTimeout{min: 50 * time.Millisecond, max: 2 * time.Second}
+	if got := a.timeout(); got != 2*time.Second {
+		t.Errorf("without samples: got %s, want the configured timeout", got)
+	}
+	for i := 0; i < 20; i++ {
+		a.observe(10 * time.Millisecond)
+	}
+	if got := a.timeout(); got != 50*time.Millisecond {
+		t.Errorf("fast device: got %s, want the floor", got)
+	}
+	for i := 0; i < 20; i++ {
+		a.observe(time.Duration(100+i%2*100) * time.Millisecond)
+	}
+	if got := a.timeout(); got < 200*time.Millisecond || got > time.Second {
+		t.Errorf("jittery device: got %s", got)
+	}
+	a.expired(a.timeout())
+	a.expired(a.timeout())
+	a.expired(a.timeout())
+	if got := a.timeout(); got != 2*time.Second {
+		t.Errorf("after repeated timeouts: got %s, want the ceiling", got)
+	}
+	a.observe(150 * time.Millisecond)
+	if got := a.timeout(); got >= 2*time.Second {
+		t.Errorf("a response should end the backoff: got %s", got)
+	}
+}
+
+func TestModbusConn_AdaptiveTimeout(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.Clock = clock.NewFake(time.Now())
+	con.AdaptTimeout(50 * time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		if _, err := con.ReadInputs([]uint16{0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := con.Timeout(); got != 50*time.Millisecond {
+		t.Errorf("timeout after fast responses: got %s, want 50ms", got)
+	}
+
+	// el PLC se pone lento: el timeout se duplica en cada reintento (100, 200, 400 ms)
+	sim.SetDelay(300 * time.Millisecond)
+	sent := len(sim.Requests())
+	if _, err := con.ReadCoils([]uint16{modbusServer.LogoOutputs}); err != nil {
+		t.Fatalf("the read should succeed once the timeout grows: %v", err)
+	}
+	if n := len(sim.Requests()) - sent; n != 4 {
+		t.Errorf("requests: got %d, want 4", n)
+	}
+	if got := con.Timeout(); got <= 300*time.Millisecond {
+		t.Errorf("timeout should follow the slower device: got %s", got)
+	}
+}
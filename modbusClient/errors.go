@@ -0,0 +1,129 @@
+package modbusClient
+
+import (
+	"errors"
+	"net"
+
+	"github.com/goburrow/modbus"
+)
+
+// ErrorClass agrupa los errores de una petición según qué conviene hacer con ellos
+type ErrorClass int
+
+const (
+	ClassTransport ErrorClass = iota // conexión caída, respuesta truncada o desfasada
+	ClassTimeout                     // el PLC no respondió a tiempo
+	ClassException                   // el PLC respondió con una excepción Modbus
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassTimeout:
+		return "timeout"
+	case ClassException:
+		return "exception"
+	}
+	return "transport"
+}
+
+// Error es el error que devuelven las peticiones de ModbusConn tras agotar
+// (o saltear) los reintentos. Error() es el mensaje del último intento.
+type Error struct {
+	Class     ErrorClass
+	Exception byte // código de excepción, solo con ClassException
+	Function  byte // código de función de la petición
+	Attempts  int
+	Err       error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// classify arma un Error a partir del error de un intento
+func classify(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	e = &Error{Class: ClassTransport, Err: err}
+	var mbErr *modbus.ModbusError
+	var netErr net.Error
+	if errors.As(err, &mbErr) {
+		e.Class = ClassException
+		e.Exception = mbErr.ExceptionCode
+		e.Function = mbErr.FunctionCode
+	} else if errors.As(err, &netErr) && netErr.Timeout() {
+		e.Class = ClassTimeout
+	}
+	return e
+}
+
+// ClassOf devuelve la clase de err; ok es false si no es un error de petición Modbus
+func ClassOf(err error) (class ErrorClass, ok bool) {
+	var e *Error
+	if !errors.As(err, &e) {
+		return 0, false
+	}
+	return e.Class, true
+}
+
+// IsException indica si err es la excepción Modbus code
+func IsException(err error, code byte) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Class == ClassException && e.Exception == code
+}
+
+// Action es qué hacer tras un intento fallido
+type Action int
+
+const (
+	Reconnect Action = iota // cerrar, esperar y reconectar antes del próximo intento
+	Retry                   // esperar y reintentar por la misma conexión
+	Fail                    // no reintentar
+)
+
+func (a Action) String() string {
+	switch a {
+	case Retry:
+		return "retry"
+	case Fail:
+		return "fail"
+	}
+	return "reconnect"
+}
+
+// RetryPolicy decide la acción por clase de error, y por código para las excepciones
+type RetryPolicy struct {
+	Transport  Action
+	Timeout    Action
+	Exception  Action          // excepciones sin entrada en Exceptions
+	Exceptions map[byte]Action // por código de excepción
+}
+
+// DefaultRetryPolicy reconecta ante errores de transporte y timeouts (la
+// respuesta tardía desfasaría la próxima), reintenta las excepciones
+// transitorias y falla enseguida con las que no se arreglan reintentando.
+var DefaultRetryPolicy = RetryPolicy{
+	Transport: Reconnect,
+	Timeout:   Reconnect,
+	Exception: Retry,
+	Exceptions: map[byte]Action{
+		modbus.ExceptionCodeIllegalFunction:    Fail,
+		modbus.ExceptionCodeIllegalDataAddress: Fail,
+		modbus.ExceptionCodeIllegalDataValue:   Fail,
+		modbus.ExceptionCodeMemoryParityError:  Fail,
+	},
+}
+
+func (p *RetryPolicy) action(e *Error) Action {
+	switch e.Class {
+	case ClassTimeout:
+		return p.Timeout
+	case ClassException:
+		if a, ok := p.Exceptions[e.Exception]; ok {
+			return a
+		}
+		return p.Exception
+	}
+	return p.Transport
+}
@@ -1,5 +1,7 @@
 package modbusClient
 
+import "context"
+
 const CodeAddress = 4209
 const ArgumentAddress = 4207
 const AutomaticStartStopAddr = 4700
@@ -8,12 +10,12 @@ const StartStopCode = 0x01
 const ArgumentStart = 0x01FE0000
 const ArgumentStop = 0x02FD0000
 
-func GenSetON(c *ModbusConn) error {
-	return c.WriteCoil(AutomaticStartStopAddr, true)
+func GenSetON(ctx context.Context, c *ModbusConn) error {
+	return c.WriteCoilCtx(ctx, AutomaticStartStopAddr, true)
 }
 
-func GenSetOFF(c *ModbusConn) error {
-	return c.WriteCoil(AutomaticStartStopAddr, false)
+func GenSetOFF(ctx context.Context, c *ModbusConn) error {
+	return c.WriteCoilCtx(ctx, AutomaticStartStopAddr, false)
 }
 
 //
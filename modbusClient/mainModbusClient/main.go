@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"mt-plc-control/modbusClient"
+	"os"
+	"os/signal"
 	"time"
 )
 
@@ -24,12 +27,16 @@ func main() {
 		panic("Nil flag cmd")
 	}
 
+	// Ctrl-C corta los reintentos en curso
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	if *cmd == "ON" {
-		if err := modbusClient.GenSetON(plcConn); err != nil {
+		if err := modbusClient.GenSetON(ctx, plcConn); err != nil {
 			panic(err)
 		}
 	} else if *cmd == "OFF" {
-		if err := modbusClient.GenSetOFF(plcConn); err != nil {
+		if err := modbusClient.GenSetOFF(ctx, plcConn); err != nil {
 			panic(err)
 		}
 	} else if *cmd == "EN" {
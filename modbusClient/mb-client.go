@@ -1,9 +1,12 @@
 package modbusClient
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"log"
+	"mt-plc-control/clock"
+	"sync"
 	"time"
 
 	"github.com/goburrow/modbus"
@@ -11,9 +14,48 @@ import (
 
 const triesLimit = 4
 
+// DefaultMinGap es la pausa mínima entre tramas que usa NewModbusConn
+const DefaultMinGap = 50 * time.Millisecond
+
+// ModbusConn es segura para uso concurrente: las tramas pasan de a una por la
+// cola del bus, por prioridad (ver WithPriority) y separadas por MinGap.
 type ModbusConn struct {
-	handler *modbus.TCPClientHandler
-	client  modbus.Client
+	Clock  clock.Clock   // esperas entre reintentos y tramas; nil usa el reloj real
+	MinGap time.Duration // pausa mínima entre el fin de una trama y la siguiente
+	Retry  *RetryPolicy  // nil usa DefaultRetryPolicy
+
+	// Breaker, si no es nil, corta las peticiones mientras el equipo esté caído
+	Breaker *Breaker
+
+	handler  connHandler
+	mu       sync.Mutex // protege client, que Reconnect reemplaza
+	client   modbus.Client
+	record   *recorder
+	queue    queue
+	adaptive *adaptiveTimeout
+	stats    stats
+}
+
+// connHandler es el transporte de ModbusConn: TCP o RTU
+type connHandler interface {
+	modbus.ClientHandler
+	Connect() error
+	Close() error
+	timeoutField() *time.Duration // el timeout de respuesta, que ajusta AdaptTimeout
+}
+
+type tcpHandler struct {
+	*modbus.TCPClientHandler
+}
+
+func (h tcpHandler) timeoutField() *time.Duration {
+	return &h.Timeout
+}
+
+func (c *ModbusConn) getClient() modbus.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
 }
 
 func NewModbusConn(address string, timeout time.Duration) (*ModbusConn, error) {
@@ -26,7 +68,110 @@ func NewModbusConn(address string, timeout time.Duration) (*ModbusConn, error) {
 		return nil, err
 	}
 	c := modbus.NewClient(h)
-	return &ModbusConn{handler: h, client: c}, nil
+	return &ModbusConn{MinGap: DefaultMinGap, handler: tcpHandler{h}, client: c}, nil
+}
+
+// AdaptTimeout hace que el timeout de respuesta siga la latencia observada,
+// entre floor y el timeout dado a NewModbusConn. Llamar antes de usar la conexión.
+func (c *ModbusConn) AdaptTimeout(floor time.Duration) {
+	if c.handler == nil {
+		return
+	}
+	c.adaptive = &adaptiveTimeout{min: floor, max: *c.handler.timeoutField()}
+}
+
+// Timeout devuelve el timeout de respuesta en uso
+func (c *ModbusConn) Timeout() time.Duration {
+	if c.adaptive != nil {
+		return c.adaptive.timeout()
+	}
+	if c.handler != nil {
+		return *c.handler.timeoutField()
+	}
+	return 0
+}
+
+// try hace una petición con la función fc por la cola del bus, con reintentos
+func (c *ModbusConn) try(ctx context.Context, fc byte, f tryFuncT) ([]byte, error) {
+	clk := clock.Or(c.Clock)
+	policy := c.Retry
+	if policy == nil {
+		policy = &DefaultRetryPolicy
+	}
+	tries, probe := triesLimit, false
+	if c.Breaker != nil {
+		var err error
+		if probe, err = c.Breaker.allow(clk.Now()); err != nil {
+			return nil, err
+		}
+		if probe {
+			tries = 1
+		}
+	}
+	prio := priorityOf(ctx)
+	b, err := tryNTimes(ctx, func() ([]byte, error) {
+		if err := c.queue.acquire(ctx, prio, c.MinGap, clk); err != nil {
+			return nil, err
+		}
+		defer func() {
+			c.queue.release(clk.Now())
+		}()
+		b, err := c.timed(fc, f)
+		if ctx.Err() != nil {
+			// petición abandonada: se cierra con el bus tomado
+			_ = c.Close()
+		}
+		return b, err
+	}, func() error {
+		// cerrar también ocupa el bus: no cortar la petición de otro
+		if err := c.queue.acquire(ctx, prio, 0, clk); err != nil {
+			return err
+		}
+		defer func() {
+			c.queue.release(clk.Now())
+		}()
+		return c.Close()
+	}, func() {
+		// la reconexión también ocupa el bus
+		if c.queue.acquire(ctx, prio, 0, clk) != nil {
+			return
+		}
+		defer func() {
+			c.queue.release(clk.Now())
+		}()
+		c.Reconnect()
+	}, tries, policy, clk)
+	if mbErr, ok := err.(*Error); ok && mbErr.Function == 0 {
+		mbErr.Function = fc
+	}
+	if c.Breaker != nil {
+		c.Breaker.done(clk.Now(), probe, err)
+	}
+	return b, err
+}
+
+// timed corre f con el timeout adaptativo y registra su latencia; se llama con el bus tomado
+func (c *ModbusConn) timed(fc byte, f tryFuncT) ([]byte, error) {
+	var timeout time.Duration
+	if c.adaptive != nil {
+		timeout = c.adaptive.timeout()
+		*c.handler.timeoutField() = timeout
+	}
+	start := time.Now()
+	b, err := f()
+	took := time.Since(start)
+	c.stats.observe(fc, took, err)
+	if c.adaptive == nil {
+		return b, err
+	}
+	if err == nil {
+		c.adaptive.observe(took)
+	} else if mbErr := classify(err); mbErr.Class == ClassException {
+		c.adaptive.observe(took)
+	} else if mbErr.Class == ClassTimeout {
+		c.adaptive.expired(timeout)
+	}
+	return b, err
 }
 
 func (c *ModbusConn) Reconnect() {
@@ -35,16 +180,23 @@ func (c *ModbusConn) Reconnect() {
 		return
 	}
 	if err := c.handler.Connect(); err != nil {
-		log.Printf("could not connect")
+		log.Printf("could not connect: %v", err)
 		_ = c.handler.Close()
-
 		return
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.client = modbus.NewClient(c.handler)
-
+	if c.record != nil {
+		c.client = c.record.wrap(c.client)
+	}
 }
 
 func (c *ModbusConn) ReadInputs(addressList []uint16) ([]bool, error) {
+	return c.ReadInputsCtx(context.Background(), addressList)
+}
+
+func (c *ModbusConn) ReadInputsCtx(ctx context.Context, addressList []uint16) ([]bool, error) {
 	inputBool := make([]bool, len(addressList))
 	if len(addressList) == 0 {
 		return inputBool, nil
@@ -53,9 +205,9 @@ func (c *ModbusConn) ReadInputs(addressList []uint16) ([]bool, error) {
 	iEnd := extremeValue(addressList, max16)
 	iQty := iEnd - iStart + 1
 
-	iRegs, err := tryNTimes(func() ([]byte, error) {
-		return c.client.ReadDiscreteInputs(iStart, iQty)
-	}, c.Close, c.Reconnect, triesLimit)
+	iRegs, err := c.try(ctx, modbus.FuncCodeReadDiscreteInputs, func() ([]byte, error) {
+		return c.getClient().ReadDiscreteInputs(iStart, iQty)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +221,10 @@ func (c *ModbusConn) ReadInputs(addressList []uint16) ([]bool, error) {
 }
 
 func (c *ModbusConn) ReadCoils(addressList []uint16) ([]bool, error) {
+	return c.ReadCoilsCtx(context.Background(), addressList)
+}
+
+func (c *ModbusConn) ReadCoilsCtx(ctx context.Context, addressList []uint16) ([]bool, error) {
 	coilsBool := make([]bool, len(addressList))
 	if len(addressList) == 0 {
 		return coilsBool, nil
@@ -78,9 +234,9 @@ func (c *ModbusConn) ReadCoils(addressList []uint16) ([]bool, error) {
 	qEnd := extremeValue(addressList, max16)
 	qQty := qEnd - qStart + 1
 
-	qRegs, err := tryNTimes(func() ([]byte, error) {
-		return c.client.ReadCoils(qStart, qQty)
-	}, c.Close, c.Reconnect, triesLimit)
+	qRegs, err := c.try(ctx, modbus.FuncCodeReadCoils, func() ([]byte, error) {
+		return c.getClient().ReadCoils(qStart, qQty)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -93,6 +249,10 @@ func (c *ModbusConn) ReadCoils(addressList []uint16) ([]bool, error) {
 }
 
 func (c *ModbusConn) ReadAnalog(addressList []uint16) ([]float32, error) {
+	return c.ReadAnalogCtx(context.Background(), addressList)
+}
+
+func (c *ModbusConn) ReadAnalogCtx(ctx context.Context, addressList []uint16) ([]float32, error) {
 	analogs := make([]float32, len(addressList))
 	bytesArr := make([]byte, 0, 2*len(addressList))
 
@@ -110,9 +270,9 @@ func (c *ModbusConn) ReadAnalog(addressList []uint16) ([]float32, error) {
 			aj = addressList[j]
 		}
 		if aj > aData.aStart+aData.aQty || j == len(addressList) {
-			b, err := tryNTimes(func() ([]byte, error) {
-				return c.client.ReadInputRegisters(aData.aStart, aData.aQty)
-			}, c.Close, c.Reconnect, triesLimit)
+			b, err := c.try(ctx, modbus.FuncCodeReadInputRegisters, func() ([]byte, error) {
+				return c.getClient().ReadInputRegisters(aData.aStart, aData.aQty)
+			})
 			if err != nil {
 				return nil, err
 			}
@@ -160,41 +320,49 @@ func (c *ModbusConn) ReadAnalog(addressList []uint16) ([]float32, error) {
 }*/
 
 func (c *ModbusConn) WriteCoil(address uint16, value bool) error {
+	return c.WriteCoilCtx(context.Background(), address, value)
+}
+
+func (c *ModbusConn) WriteCoilCtx(ctx context.Context, address uint16, value bool) error {
 	var v uint16
 	if value {
 		v = 0xFF00
 	} else {
 		v = 0x0000
 	}
-	if _, err := tryNTimes(func() ([]byte, error) {
-		return c.client.WriteSingleCoil(address, v)
-	}, c.Close, c.Reconnect, triesLimit); err != nil {
+	if _, err := c.try(ctx, modbus.FuncCodeWriteSingleCoil, func() ([]byte, error) {
+		return c.getClient().WriteSingleCoil(address, v)
+	}); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c *ModbusConn) WriteCommand(cmdAddress uint16, cmdValue uint16, argAddress uint16, argValue uint32) (uint32, error) {
+	return c.WriteCommandCtx(context.Background(), cmdAddress, cmdValue, argAddress, argValue)
+}
+
+func (c *ModbusConn) WriteCommandCtx(ctx context.Context, cmdAddress uint16, cmdValue uint16, argAddress uint16, argValue uint32) (uint32, error) {
 	// 0x01FE0000 -> byte
 	argBytes := make([]byte, 4)
 	binary.BigEndian.PutUint32(argBytes, argValue)
-	_, err := tryNTimes(func() ([]byte, error) {
-		return c.client.WriteMultipleRegisters(argAddress, 2, argBytes)
-	}, c.Close, c.Reconnect, triesLimit)
+	_, err := c.try(ctx, modbus.FuncCodeWriteMultipleRegisters, func() ([]byte, error) {
+		return c.getClient().WriteMultipleRegisters(argAddress, 2, argBytes)
+	})
 	if err != nil {
 		return 0, fmt.Errorf("writing argument, %w", err)
 	}
 	// 0x0001
-	_, err = tryNTimes(func() ([]byte, error) {
-		return c.client.WriteSingleRegister(cmdAddress, cmdValue)
-	}, c.Close, c.Reconnect, triesLimit)
+	_, err = c.try(ctx, modbus.FuncCodeWriteSingleRegister, func() ([]byte, error) {
+		return c.getClient().WriteSingleRegister(cmdAddress, cmdValue)
+	})
 	if err != nil {
 		return 0, fmt.Errorf("writing command: %w", err)
 	}
 
-	b, err := tryNTimes(func() ([]byte, error) {
-		return c.client.ReadHoldingRegisters(argAddress, 2)
-	}, c.Close, c.Reconnect, triesLimit)
+	b, err := c.try(ctx, modbus.FuncCodeReadHoldingRegisters, func() ([]byte, error) {
+		return c.getClient().ReadHoldingRegisters(argAddress, 2)
+	})
 	if err != nil {
 		return 0, fmt.Errorf("reading return value: %w", err)
 	}
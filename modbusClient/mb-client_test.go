@@ -1,9 +1,16 @@
 package modbusClient
 
 import (
-	"os/exec"
+	"context"
+	"errors"
+	"mt-plc-control/clock"
+	"mt-plc-control/faultProxy"
+	"mt-plc-control/modbusServer"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/goburrow/modbus"
 )
 
 type register struct {
@@ -48,12 +55,25 @@ func getAddressSpace() map[string]register {
 	}
 }
 
-func runBash(script string) error {
-	cmd := exec.Command(script, "")
-	if err := cmd.Run(); err != nil {
-		return err
+// startSimulator levanta un LOGO! 8 simulado con los valores de getAddressSpace
+func startSimulator(t *testing.T) *modbusServer.Server {
+	t.Helper()
+	s := modbusServer.NewLogo8()
+	for name, r := range getAddressSpace() {
+		switch name[0] {
+		case 'I':
+			s.SetBit(modbusServer.DiscreteInputs, r.address, r.value == 1)
+		case 'Q':
+			s.SetBit(modbusServer.Coils, r.address, r.value == 1)
+		case 'A':
+			s.SetWord(modbusServer.InputRegisters, r.address, uint16(r.value))
+		}
 	}
-	return nil
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
 }
 
 func Test_getBit(t *testing.T) {
@@ -80,18 +100,9 @@ func TestModbusConn_ReadDigital(t *testing.T) {
 
 	addresses := getAddressSpace()
 
-	err := runBash("./start-server.sh")
-	defer func() {
-		err = runBash("./kill-server.sh")
-		if err != nil {
-			t.Fatalf("could not kill server, %v", err)
-		}
-	}()
-	if err != nil {
-		t.Fatal(err)
-	}
+	sim := startSimulator(t)
 
-	con, err := NewModbusConn("0.0.0.0:5020", time.Second)
+	con, err := NewModbusConn(sim.Addr(), time.Second)
 	defer func() {
 		if con == nil {
 			return
@@ -187,18 +198,9 @@ func TestModbusConn_WriteCoil(t *testing.T) {
 
 	addresses := getAddressSpace()
 
-	err := runBash("./start-server.sh")
-	defer func() {
-		err = runBash("./kill-server.sh")
-		if err != nil {
-			t.Fatalf("could not kill server, %v", err)
-		}
-	}()
-	if err != nil {
-		t.Fatal(err)
-	}
+	sim := startSimulator(t)
 
-	con, err := NewModbusConn("0.0.0.0:5020", time.Second)
+	con, err := NewModbusConn(sim.Addr(), time.Second)
 	defer func() {
 		if con == nil {
 			return
@@ -267,3 +269,283 @@ func TestModbusConn_WriteCoil(t *testing.T) {
 	}
 
 }
+
+func TestModbusConn_ReadAnalog(t *testing.T) {
+	sim := startSimulator(t)
+	for i, v := range []uint16{500, 111, 222, 333} {
+		sim.SetWord(modbusServer.InputRegisters, uint16(i), v)
+	}
+
+	con, err := NewModbusConn(sim.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+
+	got, err := con.ReadAnalog([]uint16{0, 1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float32{500, 111, 333}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want: %v, got: %v", want, got)
+		}
+	}
+}
+
+func TestModbusConn_RetryBackoff(t *testing.T) {
+	sim := startSimulator(t)
+	sim.SetException(modbus.FuncCodeReadInputRegisters, 0, modbusServer.ServerDeviceFailure)
+	con, err := NewModbusConn(sim.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(t0)
+	con.Clock = fake
+
+	start := time.Now()
+	_, err = con.ReadAnalog([]uint16{0})
+	if !IsException(err, modbusServer.ServerDeviceFailure) {
+		t.Fatalf("got %v, want the device failure exception", err)
+	}
+	// 4 intentos: esperas de 70, 210 y 490 ms, sin dormir de verdad
+	if got := fake.Now().Sub(t0); got != 770*time.Millisecond {
+		t.Errorf("backoff: got %s, want 770ms", got)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("fake clock should not sleep for real")
+	}
+	if n := len(sim.Requests()); n != triesLimit {
+		t.Errorf("requests: got %d, want %d", n, triesLimit)
+	}
+	var mbErr *Error
+	if errors.As(err, &mbErr) && mbErr.Attempts != triesLimit {
+		t.Errorf("attempts: got %d, want %d", mbErr.Attempts, triesLimit)
+	}
+}
+
+func TestModbusConn_IllegalAddressFailsFast(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(t0)
+	con.Clock = fake
+
+	err = con.WriteCoil(8888, true)
+	if !IsException(err, modbusServer.IllegalDataAddress) {
+		t.Fatalf("got %v, want the illegal address exception", err)
+	}
+	if class, ok := ClassOf(err); !ok || class != ClassException {
+		t.Errorf("class: got %v %t, want exception", class, ok)
+	}
+	if n := len(sim.Requests()); n != 1 {
+		t.Errorf("an illegal address should not be retried, got %d requests", n)
+	}
+	if got := fake.Now().Sub(t0); got != 0 {
+		t.Errorf("an illegal address should not wait, waited %s", got)
+	}
+
+	// la política es configurable por código de excepción
+	sim.SetException(modbus.FuncCodeReadInputRegisters, 0, modbusServer.ServerDeviceFailure)
+	con.Retry = &RetryPolicy{Exceptions: map[byte]Action{modbusServer.ServerDeviceFailure: Fail}}
+	if _, err := con.ReadAnalog([]uint16{0}); !IsException(err, modbusServer.ServerDeviceFailure) {
+		t.Fatalf("got %v, want the device failure exception", err)
+	}
+	if n := len(sim.Requests()); n != 2 {
+		t.Errorf("policy set to fail should not retry, got %d requests", n-1)
+	}
+}
+
+func TestModbusConn_TimeoutClass(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.Clock = clock.NewFake(time.Now())
+	con.Retry = &RetryPolicy{Timeout: Fail}
+
+	sim.SetDelay(300 * time.Millisecond)
+	_, err = con.ReadInputs([]uint16{0})
+	if class, ok := ClassOf(err); !ok || class != ClassTimeout {
+		t.Fatalf("got %v (%v), want a timeout", err, class)
+	}
+	if IsException(err, modbusServer.IllegalDataAddress) {
+		t.Errorf("a timeout is not an exception")
+	}
+
+	sim.SetDelay(0)
+	sim.SetOffline(true)
+	con.Retry = &RetryPolicy{Transport: Fail}
+	_, err = con.ReadInputs([]uint16{0})
+	if class, ok := ClassOf(err); !ok || class != ClassTransport {
+		t.Errorf("got %v (%v), want a transport error", err, class)
+	}
+}
+
+func TestModbusConn_SurvivesLinkFaults(t *testing.T) {
+	sim := startSimulator(t)
+	p := faultProxy.New(sim.Addr())
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	con, err := NewModbusConn(p.Addr(), 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.Clock = clock.NewFake(time.Now())
+
+	cases := map[string]string{
+		"reset mid-response": "down reset 4",
+		"truncated response": "down truncate 5",
+		"half-open socket":   "up blackhole",
+		"late response":      "down delay 500ms",
+		"duplicated reply":   "down duplicate; down pass",
+	}
+	for name, script := range cases {
+		t.Run(name, func(t *testing.T) {
+			faults, err := faultProxy.ParseSchedule(script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Add(faults...)
+			// la respuesta duplicada desfasa la siguiente; el reintento la descarta
+			for i := 0; i < 2; i++ {
+				got, err := con.ReadInputs([]uint16{1, 2, 3})
+				if err != nil {
+					t.Fatalf("read %d should recover with a retry: %v", i, err)
+				}
+				if !got[0] || !got[1] || got[2] {
+					t.Errorf("read %d: got %v", i, got)
+				}
+			}
+			if p.Pending() != 0 {
+				t.Errorf("fault was not applied")
+			}
+		})
+	}
+}
+
+func TestModbusConn_ContextCancellation(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+
+	// petición en curso: el PLC tarda más que el deadline del contexto
+	sim.SetDelay(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := con.ReadCoilsCtx(ctx, []uint16{modbusServer.LogoOutputs}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("in-flight request: got %v, want DeadlineExceeded", err)
+	}
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("in-flight request was not interrupted, took %s", took)
+	}
+	sim.SetDelay(0)
+	if got, err := con.ReadInputs([]uint16{1}); err != nil || !got[0] {
+		t.Errorf("the connection should be usable after an abandoned request: %v %v", got, err)
+	}
+
+	// espera entre reintentos: 4 intentos fallidos suman 770 ms de espera
+	sim.SetException(modbus.FuncCodeReadInputRegisters, 0, modbusServer.ServerDeviceFailure)
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := con.ReadAnalogCtx(ctx, []uint16{0}); !errors.Is(err, context.Canceled) {
+		t.Errorf("backoff: got %v, want Canceled", err)
+	}
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("backoff sleep was not interrupted, took %s", took)
+	}
+}
+
+// closeSpy cuenta los cierres de la conexión que caen en medio de una trama
+type closeSpy struct {
+	connHandler
+	mu       sync.Mutex
+	sending  bool
+	midFrame int
+}
+
+func (h *closeSpy) Send(aduRequest []byte) ([]byte, error) {
+	h.mu.Lock()
+	h.sending = true
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.sending = false
+		h.mu.Unlock()
+	}()
+	// la trama dura más que el cierre lento, para que se crucen
+	time.Sleep(50 * time.Millisecond)
+	return h.connHandler.Send(aduRequest)
+}
+
+func (h *closeSpy) Close() error {
+	// un cierre lento: si no tiene el bus, la trama siguiente arranca mientras tanto
+	time.Sleep(20 * time.Millisecond)
+	h.mu.Lock()
+	if h.sending {
+		h.midFrame++
+	}
+	h.mu.Unlock()
+	return h.connHandler.Close()
+}
+
+func TestModbusConn_AbandonedRequestKeepsBus(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.MinGap = 0
+	spy := &closeSpy{connHandler: con.handler}
+	con.handler = spy
+	con.client = modbus.NewClient(spy)
+
+	// la petición abandonada cierra la conexión antes de dejar pasar a la siguiente
+	sim.SetDelay(300 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := con.ReadCoilsCtx(ctx, []uint16{modbusServer.LogoOutputs}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("in-flight request: got %v, want DeadlineExceeded", err)
+	}
+	sim.SetDelay(0)
+	if got, err := con.ReadInputs([]uint16{1}); err != nil || !got[0] {
+		t.Fatalf("queued request: %v %v", got, err)
+	}
+	spy.mu.Lock()
+	defer spy.mu.Unlock()
+	if spy.midFrame != 0 {
+		t.Errorf("the abandoned request closed the connection in the middle of the next one")
+	}
+}
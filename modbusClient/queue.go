@@ -0,0 +1,109 @@
+package modbusClient
+
+import (
+	"context"
+	"mt-plc-control/clock"
+	"sync"
+	"time"
+)
+
+// Priority ordena las peticiones que esperan el bus: una escritura de operador
+// pasa delante de las lecturas de polling ya encoladas.
+type Priority int
+
+const (
+	PriorityPoll Priority = iota
+	PriorityCommand
+	priorities
+)
+
+type priorityKey struct{}
+
+// WithPriority marca las peticiones hechas con ctx
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= 0 && p < priorities {
+		return p
+	}
+	return PriorityPoll
+}
+
+// queue deja pasar una trama a la vez, por prioridad y en orden de llegada,
+// con al menos gap entre el fin de una trama y el inicio de la siguiente.
+type queue struct {
+	mu      sync.Mutex
+	busy    bool
+	waiting [priorities][]chan struct{}
+	lastEnd time.Time
+}
+
+// acquire espera el turno; tras un nil hay que llamar a release
+func (q *queue) acquire(ctx context.Context, p Priority, gap time.Duration, clk clock.Clock) error {
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+	} else {
+		turn := make(chan struct{})
+		q.waiting[p] = append(q.waiting[p], turn)
+		q.mu.Unlock()
+		select {
+		case <-turn:
+		case <-ctx.Done():
+			if !q.leave(p, turn) {
+				// el turno llegó a la vez que la cancelación: pasarlo al siguiente
+				q.release(clk.Now())
+			}
+			return ctx.Err()
+		}
+	}
+
+	q.mu.Lock()
+	wait := q.lastEnd.Add(gap).Sub(clk.Now())
+	q.mu.Unlock()
+	if wait > 0 {
+		if err := clk.SleepContext(ctx, wait); err != nil {
+			q.release(q.lastEndTime())
+			return err
+		}
+	}
+	return nil
+}
+
+// leave saca turn de la espera; false si ya se le había dado el turno
+func (q *queue) leave(p Priority, turn chan struct{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, t := range q.waiting[p] {
+		if t == turn {
+			q.waiting[p] = append(q.waiting[p][:i], q.waiting[p][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// release libera el bus al terminar una trama en end
+func (q *queue) release(end time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lastEnd = end
+	for p := priorities - 1; p >= 0; p-- {
+		if len(q.waiting[p]) > 0 {
+			turn := q.waiting[p][0]
+			q.waiting[p] = q.waiting[p][1:]
+			close(turn)
+			return
+		}
+	}
+	q.busy = false
+}
+
+func (q *queue) lastEndTime() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.lastEnd
+}
@@ -0,0 +1,116 @@
+package modbusClient
+
+import (
+	"context"
+	"errors"
+	"mt-plc-control/modbusServer"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestModbusConn_CommandsPreemptQueuedReads(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.MinGap = 0
+
+	// una lectura lenta ocupa el bus mientras se encolan otras dos y una escritura
+	sim.SetDelay(200 * time.Millisecond)
+	var wg sync.WaitGroup
+	read := func(address uint16) {
+		defer wg.Done()
+		if _, err := con.ReadInputs([]uint16{address}); err != nil {
+			t.Errorf("read %d: %v", address, err)
+		}
+	}
+	wg.Add(4)
+	go read(0)
+	time.Sleep(50 * time.Millisecond)
+	go read(1)
+	time.Sleep(20 * time.Millisecond)
+	go read(2)
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		defer wg.Done()
+		ctx := WithPriority(context.Background(), PriorityCommand)
+		if err := con.WriteCoilCtx(ctx, modbusServer.LogoOutputs, true); err != nil {
+			t.Errorf("write: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	reqs := sim.Requests()
+	if len(reqs) != 4 {
+		t.Fatalf("got %d requests, want 4", len(reqs))
+	}
+	order := []uint16{reqs[0].Address, reqs[1].Address, reqs[2].Address, reqs[3].Address}
+	want := []uint16{0, modbusServer.LogoOutputs, 1, 2}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("bus order: got %v, want %v", order, want)
+		}
+	}
+}
+
+func TestModbusConn_MinGap(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.MinGap = 100 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		if _, err := con.ReadInputs([]uint16{0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reqs := sim.Requests()
+	for i := 1; i < len(reqs); i++ {
+		if gap := reqs[i].At.Sub(reqs[i-1].At); gap < 100*time.Millisecond {
+			t.Errorf("frames %d and %d only %s apart", i-1, i, gap)
+		}
+	}
+}
+
+func TestModbusConn_CancelWhileQueued(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.MinGap = 0
+
+	sim.SetDelay(300 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = con.ReadInputs([]uint16{0})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := con.ReadCoilsCtx(ctx, []uint16{modbusServer.LogoOutputs}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("queued request: got %v, want DeadlineExceeded", err)
+	}
+	<-done
+	sim.SetDelay(0)
+	if _, err := con.ReadInputs([]uint16{0}); err != nil {
+		t.Errorf("the queue should keep working after a cancelled wait: %v", err)
+	}
+	if n := len(sim.Requests()); n != 2 {
+		t.Errorf("the cancelled request should never reach the PLC, got %d requests", n)
+	}
+}
@@ -0,0 +1,287 @@
+package modbusClient
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// Exchange es una petición Modbus con su respuesta, una línea JSON de una captura
+type Exchange struct {
+	Time      time.Time `json:"time"`
+	Op        string    `json:"op"`
+	Address   uint16    `json:"address"`
+	Quantity  uint16    `json:"quantity"`       // o el valor en WriteSingleCoil/Register
+	Data      string    `json:"data,omitempty"` // hex de lo escrito
+	Response  string    `json:"response,omitempty"`
+	Err       string    `json:"err,omitempty"`
+	Exception byte      `json:"exception,omitempty"` // código de excepción Modbus
+	Function  byte      `json:"function,omitempty"`
+	Timeout   bool      `json:"timeout,omitempty"`
+	Millis    float64   `json:"ms"`
+}
+
+func (e Exchange) key() string {
+	return fmt.Sprintf("%s %d %d %s", e.Op, e.Address, e.Quantity, e.Data)
+}
+
+// recorder guarda en w cada intercambio con el cliente envuelto
+type recorder struct {
+	client modbus.Client
+	mu     *sync.Mutex
+	enc    *json.Encoder
+}
+
+// Record hace que la conexión escriba en w (JSON lines) cada petición y
+// respuesta al PLC, con su duración y error. Se mantiene tras Reconnect.
+func (c *ModbusConn) Record(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record = &recorder{mu: &sync.Mutex{}, enc: json.NewEncoder(w)}
+	c.client = c.record.wrap(c.client)
+}
+
+func (r *recorder) wrap(client modbus.Client) modbus.Client {
+	if client == nil {
+		return nil
+	}
+	return &recorder{client: client, mu: r.mu, enc: r.enc}
+}
+
+func (r *recorder) do(op string, address, quantity uint16, data []byte, f func() ([]byte, error)) ([]byte, error) {
+	start := time.Now()
+	res, err := f()
+	e := Exchange{
+		Time:     start,
+		Op:       op,
+		Address:  address,
+		Quantity: quantity,
+		Data:     hex.EncodeToString(data),
+		Response: hex.EncodeToString(res),
+		Millis:   float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		e.Err = err.Error()
+		var mbErr *modbus.ModbusError
+		var netErr net.Error
+		if errors.As(err, &mbErr) {
+			e.Exception = mbErr.ExceptionCode
+			e.Function = mbErr.FunctionCode
+		} else if errors.As(err, &netErr) && netErr.Timeout() {
+			e.Timeout = true
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_ = r.enc.Encode(e)
+	return res, err
+}
+
+func (r *recorder) ReadCoils(address, quantity uint16) ([]byte, error) {
+	return r.do("ReadCoils", address, quantity, nil, func() ([]byte, error) {
+		return r.client.ReadCoils(address, quantity)
+	})
+}
+
+func (r *recorder) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	return r.do("ReadDiscreteInputs", address, quantity, nil, func() ([]byte, error) {
+		return r.client.ReadDiscreteInputs(address, quantity)
+	})
+}
+
+func (r *recorder) WriteSingleCoil(address, value uint16) ([]byte, error) {
+	return r.do("WriteSingleCoil", address, value, nil, func() ([]byte, error) {
+		return r.client.WriteSingleCoil(address, value)
+	})
+}
+
+func (r *recorder) WriteMultipleCoils(address, quantity uint16, value []byte) ([]byte, error) {
+	return r.do("WriteMultipleCoils", address, quantity, value, func() ([]byte, error) {
+		return r.client.WriteMultipleCoils(address, quantity, value)
+	})
+}
+
+func (r *recorder) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return r.do("ReadInputRegisters", address, quantity, nil, func() ([]byte, error) {
+		return r.client.ReadInputRegisters(address, quantity)
+	})
+}
+
+func (r *recorder) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return r.do("ReadHoldingRegisters", address, quantity, nil, func() ([]byte, error) {
+		return r.client.ReadHoldingRegisters(address, quantity)
+	})
+}
+
+func (r *recorder) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	return r.do("WriteSingleRegister", address, value, nil, func() ([]byte, error) {
+		return r.client.WriteSingleRegister(address, value)
+	})
+}
+
+func (r *recorder) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	return r.do("WriteMultipleRegisters", address, quantity, value, func() ([]byte, error) {
+		return r.client.WriteMultipleRegisters(address, quantity, value)
+	})
+}
+
+func (r *recorder) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) ([]byte, error) {
+	data := append([]byte{byte(writeAddress >> 8), byte(writeAddress), byte(writeQuantity >> 8), byte(writeQuantity)}, value...)
+	return r.do("ReadWriteMultipleRegisters", readAddress, readQuantity, data, func() ([]byte, error) {
+		return r.client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+	})
+}
+
+func (r *recorder) MaskWriteRegister(address, andMask, orMask uint16) ([]byte, error) {
+	data := []byte{byte(andMask >> 8), byte(andMask), byte(orMask >> 8), byte(orMask)}
+	return r.do("MaskWriteRegister", address, 0, data, func() ([]byte, error) {
+		return r.client.MaskWriteRegister(address, andMask, orMask)
+	})
+}
+
+func (r *recorder) ReadFIFOQueue(address uint16) ([]byte, error) {
+	return r.do("ReadFIFOQueue", address, 0, nil, func() ([]byte, error) {
+		return r.client.ReadFIFOQueue(address)
+	})
+}
+
+var (
+	ErrReplayEnd      = errors.New("replay: end of capture")
+	ErrReplayMismatch = errors.New("replay: request not found in capture")
+)
+
+// replayError reproduce un error de transporte grabado; implementa net.Error
+type replayError struct {
+	msg     string
+	timeout bool
+}
+
+func (e *replayError) Error() string   { return e.msg }
+func (e *replayError) Timeout() bool   { return e.timeout }
+func (e *replayError) Temporary() bool { return e.timeout }
+
+// ReplayClient responde con una captura grabada por Record, sin PLC.
+// Cada petición consume el próximo intercambio igual (op, dirección, cantidad y
+// datos); los intercambios intermedios que no coinciden se saltan.
+type ReplayClient struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	next      int
+}
+
+func NewReplayClient(r io.Reader) (*ReplayClient, error) {
+	c := &ReplayClient{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("replay line %d: %w", line, err)
+		}
+		c.exchanges = append(c.exchanges, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading capture: %w", err)
+	}
+	return c, nil
+}
+
+// NewReplayConn arma una ModbusConn que lee de la captura en lugar del PLC
+func NewReplayConn(r io.Reader) (*ModbusConn, error) {
+	client, err := NewReplayClient(r)
+	if err != nil {
+		return nil, err
+	}
+	return &ModbusConn{client: client}, nil
+}
+
+// Remaining devuelve cuántos intercambios quedan sin consumir
+func (c *ReplayClient) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.exchanges) - c.next
+}
+
+func (c *ReplayClient) do(op string, address, quantity uint16, data []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next >= len(c.exchanges) {
+		return nil, ErrReplayEnd
+	}
+	want := Exchange{Op: op, Address: address, Quantity: quantity, Data: hex.EncodeToString(data)}.key()
+	for i := c.next; i < len(c.exchanges); i++ {
+		e := c.exchanges[i]
+		if e.key() != want {
+			continue
+		}
+		c.next = i + 1
+		if e.Exception != 0 {
+			return nil, &modbus.ModbusError{FunctionCode: e.Function, ExceptionCode: e.Exception}
+		}
+		if e.Err != "" {
+			return nil, &replayError{e.Err, e.Timeout}
+		}
+		res, err := hex.DecodeString(e.Response)
+		if err != nil {
+			return nil, fmt.Errorf("replay response at %s: %w", e.Time.Format(time.RFC3339), err)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrReplayMismatch, want)
+}
+
+func (c *ReplayClient) ReadCoils(address, quantity uint16) ([]byte, error) {
+	return c.do("ReadCoils", address, quantity, nil)
+}
+
+func (c *ReplayClient) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	return c.do("ReadDiscreteInputs", address, quantity, nil)
+}
+
+func (c *ReplayClient) WriteSingleCoil(address, value uint16) ([]byte, error) {
+	return c.do("WriteSingleCoil", address, value, nil)
+}
+
+func (c *ReplayClient) WriteMultipleCoils(address, quantity uint16, value []byte) ([]byte, error) {
+	return c.do("WriteMultipleCoils", address, quantity, value)
+}
+
+func (c *ReplayClient) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return c.do("ReadInputRegisters", address, quantity, nil)
+}
+
+func (c *ReplayClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return c.do("ReadHoldingRegisters", address, quantity, nil)
+}
+
+func (c *ReplayClient) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	return c.do("WriteSingleRegister", address, value, nil)
+}
+
+func (c *ReplayClient) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	return c.do("WriteMultipleRegisters", address, quantity, value)
+}
+
+func (c *ReplayClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) ([]byte, error) {
+	data := append([]byte{byte(writeAddress >> 8), byte(writeAddress), byte(writeQuantity >> 8), byte(writeQuantity)}, value...)
+	return c.do("ReadWriteMultipleRegisters", readAddress, readQuantity, data)
+}
+
+func (c *ReplayClient) MaskWriteRegister(address, andMask, orMask uint16) ([]byte, error) {
+	return c.do("MaskWriteRegister", address, 0, []byte{byte(andMask >> 8), byte(andMask), byte(orMask >> 8), byte(orMask)})
+}
+
+func (c *ReplayClient) ReadFIFOQueue(address uint16) ([]byte, error) {
+	return c.do("ReadFIFOQueue", address, 0, nil)
+}
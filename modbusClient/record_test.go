@@ -0,0 +1,116 @@
+package modbusClient
+
+import (
+	"bytes"
+	"errors"
+	"mt-plc-control/clock"
+	"mt-plc-control/modbusServer"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecord_ReplayRoundTrip(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.Clock = clock.NewFake(time.Now())
+	var capture bytes.Buffer
+	con.Record(&capture)
+
+	inputs, err := con.ReadInputs([]uint16{0, 1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	analogs, err := con.ReadAnalog([]uint16{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeErr := con.WriteCoil(8888, true)
+	if writeErr == nil {
+		t.Fatalf("write outside the LOGO! map should fail")
+	}
+
+	replay, err := NewReplayConn(bytes.NewReader(capture.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Clock = clock.NewFake(time.Now())
+	gotInputs, err := replay.ReadInputs([]uint16{0, 1, 3})
+	if err != nil || gotInputs[0] != inputs[0] || gotInputs[1] != inputs[1] || gotInputs[2] != inputs[2] {
+		t.Errorf("replayed inputs: got %v %v, want %v", gotInputs, err, inputs)
+	}
+	if got, err := replay.ReadAnalog([]uint16{0}); err != nil || got[0] != analogs[0] {
+		t.Errorf("replayed analog: got %v %v, want %v", got, err, analogs)
+	}
+	if err := replay.WriteCoil(8888, true); err == nil || err.Error() != writeErr.Error() {
+		t.Errorf("replayed error: got %v, want %v", err, writeErr)
+	}
+	if n := replay.client.(*ReplayClient).Remaining(); n != 0 {
+		t.Errorf("all exchanges should be consumed, %d left", n)
+	}
+	if _, err := replay.ReadCoils([]uint16{modbusServer.LogoOutputs}); !errors.Is(err, ErrReplayEnd) {
+		t.Errorf("after the capture: got %v, want ErrReplayEnd", err)
+	}
+}
+
+// captura de campo: el primer escaneo bien, el segundo con timeout, reintento
+// y excepción en el registro 3
+func TestReplay_FieldCapture(t *testing.T) {
+	f, err := os.Open("testdata/analog-gap.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	con, err := NewReplayConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := con.client.(*ReplayClient)
+
+	got, err := con.ReadAnalog([]uint16{0, 1, 3})
+	if err != nil || got[0] != 500 || got[1] != 111 || got[2] != 333 {
+		t.Fatalf("first scan: got %v %v", got, err)
+	}
+
+	_, err = client.ReadInputRegisters(0, 2)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("recorded timeout should replay as a net timeout, got %v", err)
+	}
+	if _, err := client.ReadInputRegisters(0, 2); err != nil {
+		t.Errorf("retry: %v", err)
+	}
+	if _, err := client.ReadInputRegisters(3, 1); err == nil || err.Error() != "modbus: exception '2' (illegal data address), function '132'" {
+		t.Errorf("recorded exception: got %v", err)
+	}
+}
+
+func TestReplay_Mismatch(t *testing.T) {
+	f, err := os.Open("testdata/analog-gap.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	client, err := NewReplayClient(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadInputRegisters(0, 3); !errors.Is(err, ErrReplayMismatch) {
+		t.Errorf("different grouping should not match the capture, got %v", err)
+	}
+	// los intercambios que no coinciden se saltan
+	if _, err := client.ReadInputRegisters(3, 1); err != nil || client.Remaining() != 3 {
+		t.Errorf("got %v, remaining %d", err, client.Remaining())
+	}
+}
@@ -0,0 +1,308 @@
+package modbusClient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mt-plc-control/clock"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+)
+
+// Largos de trama RTU: unit + función + datos + CRC
+const (
+	rtuMaxSize       = 256
+	rtuExceptionSize = 5
+)
+
+// rtuSilence son 3,5 caracteres a 9600 baudios: el fin de una trama de largo
+// desconocido en puertos con deadline. Un puerto serie lo marca su propio timeout.
+var rtuSilence = frameSilence(9600)
+
+var ErrNotRTU = errors.New("modbus: raw requests need an RTU connection")
+
+// rtuTimeout es el vencimiento de una lectura del bus; implementa net.Error
+// para que classify lo trate como timeout
+type rtuTimeout struct{ err error }
+
+func (e *rtuTimeout) Error() string   { return e.err.Error() }
+func (e *rtuTimeout) Unwrap() error   { return e.err }
+func (e *rtuTimeout) Timeout() bool   { return true }
+func (e *rtuTimeout) Temporary() bool { return true }
+
+// SerialPort abre el puerto serie de cfg, para NewRTUConn. El puerto no acepta
+// deadline: su Timeout pasa a ser el silencio entre tramas y el vencimiento de
+// la respuesta lo lleva la conexión.
+func SerialPort(cfg serial.Config) func() (io.ReadWriteCloser, error) {
+	cfg.Timeout = frameSilence(cfg.BaudRate)
+	return func() (io.ReadWriteCloser, error) {
+		return serial.Open(&cfg)
+	}
+}
+
+// frameSilence son 3,5 caracteres de 11 bits; sobre 19200 baudios la norma lo
+// fija en 1,75 ms
+func frameSilence(baud int) time.Duration {
+	if baud <= 0 || baud > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return time.Duration(3.5 * 11 * float64(time.Second) / float64(baud))
+}
+
+// rtuHandler es el modbus.ClientHandler de un bus RTU: arma las tramas con su
+// CRC y lee la respuesta según la función pedida
+type rtuHandler struct {
+	SlaveId byte
+	Timeout time.Duration // de las peticiones de ModbusConn; AdaptTimeout lo ajusta
+
+	// el de NewRTUConn, para Transact: los otros equipos del bus no siguen la
+	// latencia del PLC
+	rawTimeout time.Duration
+
+	open func() (io.ReadWriteCloser, error)
+	mu   sync.Mutex
+	port io.ReadWriteCloser
+}
+
+func (h *rtuHandler) Connect() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.connect()
+}
+
+// connect abre el puerto si está cerrado; se llama con h.mu tomado
+func (h *rtuHandler) connect() error {
+	if h.port != nil {
+		return nil
+	}
+	port, err := h.open()
+	if err != nil {
+		return err
+	}
+	h.port = port
+	return nil
+}
+
+func (h *rtuHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.port == nil {
+		return nil
+	}
+	err := h.port.Close()
+	h.port = nil
+	return err
+}
+
+func (h *rtuHandler) timeoutField() *time.Duration {
+	return &h.Timeout
+}
+
+func (h *rtuHandler) Encode(pdu *modbus.ProtocolDataUnit) ([]byte, error) {
+	return rtuFrame(h.SlaveId, append([]byte{pdu.FunctionCode}, pdu.Data...))
+}
+
+func (h *rtuHandler) Verify(req, res []byte) error {
+	if len(res) < rtuExceptionSize {
+		return fmt.Errorf("modbus: rtu response of %d bytes", len(res))
+	}
+	if res[0] != req[0] {
+		return fmt.Errorf("modbus: response from unit %d to a request for unit %d", res[0], req[0])
+	}
+	return nil
+}
+
+func (h *rtuHandler) Decode(adu []byte) (*modbus.ProtocolDataUnit, error) {
+	n := len(adu)
+	if crc16(adu[:n-2]) != uint16(adu[n-2])|uint16(adu[n-1])<<8 {
+		return nil, fmt.Errorf("modbus: rtu response with bad crc")
+	}
+	return &modbus.ProtocolDataUnit{FunctionCode: adu[1], Data: adu[2 : n-2]}, nil
+}
+
+// Send escribe la trama y lee la respuesta completa
+func (h *rtuHandler) Send(req []byte) ([]byte, error) {
+	return h.send(req, h.Timeout)
+}
+
+// send es Send con el timeout de respuesta dado
+func (h *rtuHandler) send(req []byte, timeout time.Duration) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := h.port.(deadliner); ok {
+		_ = d.SetDeadline(deadline)
+	}
+	if _, err := h.port.Write(req); err != nil {
+		return nil, err
+	}
+	res := make([]byte, 3, rtuMaxSize)
+	if err := h.read(res, deadline); err != nil {
+		return nil, err
+	}
+	rest := 0
+	switch fc := res[1]; {
+	case fc&0x80 != 0:
+		rest = rtuExceptionSize - 3
+	case fc >= 0x01 && fc <= 0x04, fc == 0x17:
+		rest = int(res[2]) + 2
+	case fc == 0x05 || fc == 0x06 || fc == 0x0F || fc == 0x10:
+		rest = 8 - 3
+	case fc == 0x16:
+		rest = 10 - 3
+	default:
+		return h.readToSilence(res)
+	}
+	res = res[:3+rest]
+	if err := h.read(res[3:], deadline); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// deadliner es un puerto que acepta deadline, como un pipe o un socket
+type deadliner interface {
+	SetDeadline(time.Time) error
+}
+
+// read llena b desde el puerto antes de deadline (cero espera sin límite); se
+// llama con h.mu tomado
+func (h *rtuHandler) read(b []byte, deadline time.Time) error {
+	_, hasDeadline := h.port.(deadliner)
+	for n := 0; n < len(b); {
+		m, err := h.port.Read(b[n:])
+		n += m
+		if err == nil {
+			continue
+		}
+		if !isSilence(err) {
+			return err
+		}
+		// un puerto serie vuelve tras cada silencio: se sigue esperando hasta deadline
+		if !hasDeadline && (deadline.IsZero() || time.Now().Before(deadline)) {
+			continue
+		}
+		return &rtuTimeout{err}
+	}
+	return nil
+}
+
+// readToSilence completa una trama de largo desconocido: termina con 3,5
+// caracteres sin datos; se llama con h.mu tomado
+func (h *rtuHandler) readToSilence(res []byte) ([]byte, error) {
+	d, hasDeadline := h.port.(deadliner)
+	for len(res) < rtuMaxSize {
+		if hasDeadline {
+			_ = d.SetDeadline(time.Now().Add(rtuSilence))
+		}
+		m, err := h.port.Read(res[len(res):rtuMaxSize])
+		res = res[:len(res)+m]
+		if err != nil {
+			if isSilence(err) {
+				break
+			}
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// isSilence indica que el puerto no recibió nada en el plazo de la lectura
+func isSilence(err error) bool {
+	return errors.Is(err, serial.ErrTimeout) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// transact manda un PDU a unit y devuelve el PDU de la respuesta, excepción incluida
+func (h *rtuHandler) transact(unit byte, pdu []byte) ([]byte, error) {
+	req, err := rtuFrame(unit, pdu)
+	if err != nil {
+		return nil, err
+	}
+	res, err := h.send(req, h.rawTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.Verify(req, res); err != nil {
+		return nil, err
+	}
+	decoded, err := h.Decode(res)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{decoded.FunctionCode}, decoded.Data...), nil
+}
+
+func rtuFrame(unit byte, pdu []byte) ([]byte, error) {
+	if len(pdu) == 0 || len(pdu)+3 > rtuMaxSize {
+		return nil, fmt.Errorf("modbus: rtu pdu of %d bytes", len(pdu))
+	}
+	adu := append([]byte{unit}, pdu...)
+	crc := crc16(adu)
+	return append(adu, byte(crc), byte(crc>>8)), nil
+}
+
+// crc16 es el CRC de Modbus RTU (polinomio 0xA001), que va con el byte bajo primero
+func crc16(b []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, v := range b {
+		crc ^= uint16(v)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// NewRTUConn arma una ModbusConn sobre un bus RTU; open abre el puerto (ver
+// SerialPort) y unit es el esclavo al que van las lecturas y escrituras.
+// Sus peticiones y las de Transact comparten la cola del bus.
+func NewRTUConn(open func() (io.ReadWriteCloser, error), timeout time.Duration, unit byte) (*ModbusConn, error) {
+	h := &rtuHandler{SlaveId: unit, Timeout: timeout, rawTimeout: timeout, open: open}
+	if err := h.Connect(); err != nil {
+		return nil, err
+	}
+	return &ModbusConn{MinGap: DefaultMinGap, handler: h, client: modbus.NewClient(h)}, nil
+}
+
+// Transact reenvía un PDU tal cual al esclavo unit del bus RTU, por la misma
+// cola que el polling y sin reintentos: la respuesta, excepción incluida, es
+// la del equipo
+func (c *ModbusConn) Transact(ctx context.Context, unit byte, pdu []byte) ([]byte, error) {
+	h, ok := c.handler.(*rtuHandler)
+	if !ok {
+		return nil, ErrNotRTU
+	}
+	if len(pdu) == 0 {
+		return nil, fmt.Errorf("modbus: empty pdu for unit %d", unit)
+	}
+	clk := clock.Or(c.Clock)
+	if err := c.queue.acquire(ctx, priorityOf(ctx), c.MinGap, clk); err != nil {
+		return nil, err
+	}
+	defer func() {
+		c.queue.release(clk.Now())
+	}()
+	start := time.Now()
+	res, err := h.transact(unit, pdu)
+	if err != nil {
+		// una respuesta tardía desfasaría la próxima trama
+		_ = h.Close()
+	}
+	c.stats.observe(pdu[0], time.Since(start), err)
+	return res, err
+}
@@ -0,0 +1,186 @@
+package modbusClient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mt-plc-control/modbusServer"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rtuBus simula un bus RS-485 con los units dados; cada apertura del puerto es un pipe nuevo
+func rtuBus(units map[byte]*modbusServer.Server) func() (io.ReadWriteCloser, error) {
+	return func() (io.ReadWriteCloser, error) {
+		client, bus := net.Pipe()
+		go func() {
+			_ = modbusServer.ServeRTU(bus, units)
+			_ = bus.Close()
+		}()
+		return client, nil
+	}
+}
+
+func TestRTUConn_PollAndTransact(t *testing.T) {
+	plc := startSimulator(t)
+	meter := modbusServer.NewServer()
+	meter.AddRange(modbusServer.HoldingRegisters, 0, 2)
+	meter.SetWord(modbusServer.HoldingRegisters, 1, 1234)
+
+	con, err := NewRTUConn(rtuBus(map[byte]*modbusServer.Server{1: plc, 2: meter}), 200*time.Millisecond, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.MinGap = 0
+
+	if got, err := con.ReadInputs([]uint16{0, 1}); err != nil || got[0] || !got[1] {
+		t.Errorf("poll over rtu: %v %v", got, err)
+	}
+	if err := con.WriteCoil(8192, true); err != nil || !plc.Bit(modbusServer.Coils, 8192) {
+		t.Errorf("write over rtu: %v", err)
+	}
+
+	ctx := context.Background()
+	if res, err := con.Transact(ctx, 2, []byte{0x03, 0, 1, 0, 1}); err != nil || !bytes.Equal(res, []byte{0x03, 2, 0x04, 0xD2}) {
+		t.Errorf("transact to unit 2: %x %v", res, err)
+	}
+	if res, err := con.Transact(ctx, 2, []byte{0x03, 0, 5, 0, 1}); err != nil || !bytes.Equal(res, []byte{0x83, modbusServer.IllegalDataAddress}) {
+		t.Errorf("exception should be passed through: %x %v", res, err)
+	}
+	var netErr net.Error
+	if _, err := con.Transact(ctx, 9, []byte{0x03, 0, 0, 0, 1}); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("absent unit should time out, got %v", err)
+	}
+
+	// el polling y el reenvío comparten el bus sin pisarse
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if _, err := con.ReadInputs([]uint16{0, 1}); err != nil {
+				t.Errorf("poll: %v", err)
+			}
+		})
+		wg.Go(func() {
+			if res, err := con.Transact(ctx, 2, []byte{0x03, 0, 1, 0, 1}); err != nil || res[3] != 0xD2 {
+				t.Errorf("transact: %x %v", res, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	tcp, err := NewModbusConn(plc.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = tcp.Close()
+	}()
+	if _, err := tcp.Transact(ctx, 1, []byte{0x01, 0, 0, 0, 1}); !errors.Is(err, ErrNotRTU) {
+		t.Errorf("tcp connection should refuse raw requests, got %v", err)
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	// 01 03 00 00 00 01 84 0A: lectura de un holding register del unit 1
+	if got := crc16([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01}); got != 0x0A84 {
+		t.Errorf("crc: %04x", got)
+	}
+}
+
+// rtuDevice simula un bus donde respond contesta cada trama pedida
+func rtuDevice(respond func(req []byte) []byte) func() (io.ReadWriteCloser, error) {
+	return func() (io.ReadWriteCloser, error) {
+		client, bus := net.Pipe()
+		go func() {
+			defer func() {
+				_ = bus.Close()
+			}()
+			req := make([]byte, rtuMaxSize)
+			for {
+				n, err := bus.Read(req)
+				if err != nil {
+					return
+				}
+				if _, err := bus.Write(respond(req[:n])); err != nil {
+					return
+				}
+			}
+		}()
+		return client, nil
+	}
+}
+
+func TestRTUConn_FrameLengths(t *testing.T) {
+	mei := []byte{0x2B, 0x0E, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x04, 'L', 'O', 'G', 'O'}
+	con, err := NewRTUConn(rtuDevice(func(req []byte) []byte {
+		var pdu []byte
+		switch req[1] {
+		case 0x16:
+			pdu = req[1:8] // eco de la máscara
+		case 0x17:
+			pdu = []byte{0x17, 4, 0x00, 0x01, 0x00, 0x02}
+		default:
+			pdu = mei
+		}
+		res, _ := rtuFrame(req[0], pdu)
+		return res
+	}), 200*time.Millisecond, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.MinGap = 0
+
+	ctx := context.Background()
+	for _, c := range []struct{ req, want []byte }{
+		{[]byte{0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}, []byte{0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}},
+		{[]byte{0x17, 0x00, 0x00, 0x00, 0x02, 0x00, 0x04, 0x00, 0x01, 0x02, 0x00, 0x07}, []byte{0x17, 4, 0x00, 0x01, 0x00, 0x02}},
+		// largo desconocido: la trama termina con el silencio del bus
+		{[]byte{0x2B, 0x0E, 0x01, 0x00}, mei},
+		{[]byte{0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}, []byte{0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}},
+	} {
+		if res, err := con.Transact(ctx, 2, c.req); err != nil || !bytes.Equal(res, c.want) {
+			t.Errorf("transact %x: got %x %v, want %x", c.req, res, err, c.want)
+		}
+	}
+}
+
+func TestRTUConn_TransactIgnoresAdaptedTimeout(t *testing.T) {
+	con, err := NewRTUConn(rtuDevice(func(req []byte) []byte {
+		if req[0] == 2 {
+			time.Sleep(60 * time.Millisecond)
+		}
+		res, _ := rtuFrame(req[0], []byte{req[1], 1, 0x00})
+		return res
+	}), 300*time.Millisecond, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.MinGap = 0
+	con.AdaptTimeout(5 * time.Millisecond)
+
+	// el PLC responde enseguida: su timeout baja al piso
+	for range 20 {
+		if _, err := con.ReadInputs([]uint16{0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if timeout := *con.handler.timeoutField(); timeout >= 60*time.Millisecond {
+		t.Fatalf("the adaptive timeout should have dropped, got %s", timeout)
+	}
+	// otro equipo del bus, más lento, tiene el timeout configurado
+	if _, err := con.Transact(context.Background(), 2, []byte{0x02, 0, 0, 0, 1}); err != nil {
+		t.Errorf("transact should use the configured timeout: %v", err)
+	}
+}
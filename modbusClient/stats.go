@@ -0,0 +1,93 @@
+package modbusClient
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Counts cuenta tramas y sus errores
+type Counts struct {
+	Requests   int64
+	Errors     int64 // incluye timeouts y excepciones
+	Timeouts   int64
+	Exceptions int64
+}
+
+// Stats cuenta las tramas de una ModbusConn desde que se creó, en total y por
+// código de función. Las latencias son percentiles de las últimas respuestas
+// (incluidas las excepciones).
+type Stats struct {
+	Counts
+	Functions map[byte]Counts
+	P50       time.Duration
+	P95       time.Duration
+	P99       time.Duration
+}
+
+const latencyWindow = 256
+
+type stats struct {
+	mu        sync.Mutex
+	counts    Counts
+	functions map[byte]*Counts
+	latencies [latencyWindow]time.Duration
+	n         int
+}
+
+func (c *Counts) add(err error) (responded bool) {
+	c.Requests++
+	if err == nil {
+		return true
+	}
+	c.Errors++
+	switch classify(err).Class {
+	case ClassTimeout:
+		c.Timeouts++
+	case ClassException:
+		c.Exceptions++
+		return true
+	}
+	return false
+}
+
+// observe registra una trama de la función fc que tardó d y terminó con err
+func (s *stats) observe(fc byte, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.functions == nil {
+		s.functions = make(map[byte]*Counts)
+	}
+	if s.functions[fc] == nil {
+		s.functions[fc] = &Counts{}
+	}
+	s.functions[fc].add(err)
+	if s.counts.add(err) {
+		s.latencies[s.n%latencyWindow] = d
+		s.n++
+	}
+}
+
+func (s *stats) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Stats{Counts: s.counts, Functions: make(map[byte]Counts, len(s.functions))}
+	for fc, c := range s.functions {
+		st.Functions[fc] = *c
+	}
+	sorted := slices.Clone(s.latencies[:min(s.n, latencyWindow)])
+	if len(sorted) == 0 {
+		return st
+	}
+	slices.Sort(sorted)
+	at := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+	st.P50, st.P95, st.P99 = at(50), at(95), at(99)
+	return st
+}
+
+// Stats devuelve los contadores de tramas y percentiles de latencia
+func (c *ModbusConn) Stats() Stats {
+	return c.stats.snapshot()
+}
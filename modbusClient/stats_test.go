@@ -0,0 +1,60 @@
+package modbusClient
+
+import (
+	"mt-plc-control/clock"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestStats_Percentiles(t *testing.T) {
+	var s stats
+	for i := 1; i <= 100; i++ {
+		s.observe(4, time.Duration(i)*time.Millisecond, nil)
+	}
+	s.observe(2, time.Second, &replayError{"i/o timeout", true})
+	st := s.snapshot()
+	if st.Requests != 101 || st.Errors != 1 || st.Timeouts != 1 {
+		t.Errorf("counts: %+v", st)
+	}
+	if st.Functions[4].Requests != 100 || st.Functions[4].Errors != 0 || st.Functions[2].Timeouts != 1 {
+		t.Errorf("per function: %+v", st.Functions)
+	}
+	if st.P50 != 50*time.Millisecond || st.P95 != 95*time.Millisecond || st.P99 != 99*time.Millisecond {
+		t.Errorf("a timeout is not a latency sample: %+v", st)
+	}
+	for i := 0; i < latencyWindow; i++ {
+		s.observe(4, time.Millisecond, nil)
+	}
+	if st := s.snapshot(); st.P99 != time.Millisecond {
+		t.Errorf("old samples should leave the window: %+v", st)
+	}
+}
+
+func TestModbusConn_Stats(t *testing.T) {
+	sim := startSimulator(t)
+	con, err := NewModbusConn(sim.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+	con.Clock = clock.NewFake(time.Now())
+
+	if _, err := con.ReadInputs([]uint16{0}); err != nil {
+		t.Fatal(err)
+	}
+	_ = con.WriteCoil(8888, true)
+	st := con.Stats()
+	if st.Requests != 2 || st.Errors != 1 || st.Exceptions != 1 || st.Timeouts != 0 {
+		t.Errorf("stats: %+v", st)
+	}
+	if fc := st.Functions[modbus.FuncCodeWriteSingleCoil]; fc.Requests != 1 || fc.Exceptions != 1 {
+		t.Errorf("per function: %+v", st.Functions)
+	}
+	if st.P99 <= 0 {
+		t.Errorf("latency should be measured: %+v", st)
+	}
+}
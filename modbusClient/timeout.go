@@ -0,0 +1,52 @@
+package modbusClient
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptiveTimeout estima el timeout de respuesta a partir de la latencia
+// observada, como el RTO de TCP: promedio + 4 desvíos, entre min y max.
+// Tras un timeout se duplica hasta la próxima respuesta.
+type adaptiveTimeout struct {
+	mu       sync.Mutex
+	min, max time.Duration
+	srtt     time.Duration
+	rttvar   time.Duration
+	backoff  time.Duration
+}
+
+func (a *adaptiveTimeout) timeout() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.srtt == 0 {
+		return a.max
+	}
+	t := max(a.srtt+4*a.rttvar, a.min, a.backoff)
+	return min(t, a.max)
+}
+
+// observe registra la latencia de una respuesta
+func (a *adaptiveTimeout) observe(rtt time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.backoff = 0
+	if a.srtt == 0 {
+		a.srtt = rtt
+		a.rttvar = rtt / 2
+		return
+	}
+	diff := a.srtt - rtt
+	if diff < 0 {
+		diff = -diff
+	}
+	a.rttvar = (3*a.rttvar + diff) / 4
+	a.srtt = (7*a.srtt + rtt) / 8
+}
+
+// expired registra un timeout con el valor t
+func (a *adaptiveTimeout) expired(t time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.backoff = min(2*t, a.max)
+}
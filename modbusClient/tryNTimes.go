@@ -1,25 +1,75 @@
 package modbusClient
 
-import "time"
+import (
+	"context"
+	"fmt"
+	"mt-plc-control/clock"
+	"time"
+)
 
 type tryFuncT func() ([]byte, error)
 
 type closeFuncT func() error
 type failFuncT func()
 
-func tryNTimes(tryFunc tryFuncT, closeFunc closeFuncT, failFunc failFuncT, n int) ([]byte, error) {
+// tryNTimes reintenta tryFunc hasta n veces con espera creciente, según lo que
+// policy indique para cada error. Si se cancela ctx deja de esperar, tanto en la
+// espera como durante la petición en curso. Los errores de tryFunc se devuelven
+// como *Error.
+func tryNTimes(ctx context.Context, tryFunc tryFuncT, closeFunc closeFuncT, failFunc failFuncT, n int, policy *RetryPolicy, clk clock.Clock) ([]byte, error) {
 	tries := 1
 	for {
-		b, err := tryFunc()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b, err := tryCtx(ctx, tryFunc)
 		if err == nil {
 			return b, err
 		}
-		if tries == n {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("%w (last error: %v)", ctxErr, err)
+		}
+		mbErr := classify(err)
+		mbErr.Attempts = tries
+		action := policy.action(mbErr)
+		if action == Reconnect {
+			// también al rendirse, para que la próxima petición no use un socket muerto
+			_ = closeFunc()
+		}
+		if tries == n || action == Fail {
+			return nil, mbErr
+		}
+		if err := clk.SleepContext(ctx, time.Millisecond*70*time.Duration(tries*tries-tries+1)); err != nil {
 			return nil, err
 		}
-		_ = closeFunc()
-		time.Sleep(time.Millisecond * 70 * time.Duration(tries*tries-tries+1))
 		tries = tries + 1
-		failFunc()
+		if action == Reconnect {
+			failFunc()
+		}
+	}
+}
+
+// tryCtx corre tryFunc y vuelve al cancelarse ctx. La librería Modbus no acepta
+// contexto: la petición abandonada termina sola por el Timeout del handler y
+// tryFunc debe cerrar la conexión antes de soltar el bus, para que su respuesta
+// no se lea como de otra.
+func tryCtx(ctx context.Context, tryFunc tryFuncT) ([]byte, error) {
+	if ctx.Done() == nil {
+		return tryFunc()
+	}
+	type result struct {
+		b   []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		b, err := tryFunc()
+		done <- result{b, err}
+	}()
+	select {
+	case r := <-done:
+		return r.b, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
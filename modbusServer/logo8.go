@@ -0,0 +1,26 @@
+package modbusServer
+
+// Mapa de memoria de un Siemens LOGO! 8 visto por Modbus TCP
+const (
+	LogoInputs   = 0    // I1..I24 -> entradas discretas 0..23
+	LogoOutputs  = 8192 // Q1..Q20 -> coils 8192..8211
+	LogoFlags    = 8256 // M1..M64 -> coils 8256..8319
+	LogoAnalogIn = 0    // AI1..AI8 -> registros de entrada 0..7
+	LogoVM       = 0    // VW0..VW848 -> registros holding 0..424
+	LogoAnalogQ  = 512  // AQ1..AQ8 -> registros holding 512..519
+	LogoAnalogM  = 528  // AM1..AM64 -> registros holding 528..591
+)
+
+// NewLogo8 crea un servidor con las direcciones válidas de un LOGO! 8;
+// el resto responde IllegalDataAddress como el equipo real.
+func NewLogo8() *Server {
+	s := NewServer()
+	s.AddRange(DiscreteInputs, LogoInputs, 24)
+	s.AddRange(Coils, LogoOutputs, 20)
+	s.AddRange(Coils, LogoFlags, 64)
+	s.AddRange(InputRegisters, LogoAnalogIn, 8)
+	s.AddRange(HoldingRegisters, LogoVM, 425)
+	s.AddRange(HoldingRegisters, LogoAnalogQ, 8)
+	s.AddRange(HoldingRegisters, LogoAnalogM, 64)
+	return s
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"mt-plc-control/modbusServer"
+	"os"
+	"os/signal"
+)
+
+// Simulador LOGO! 8 para pruebas manuales del gateway
+func main() {
+	addr := flag.String("addr", "0.0.0.0:5020", "Dirección de escucha")
+	flag.Parse()
+
+	s := modbusServer.NewLogo8()
+	// I2, I3 activas y valores en AI2..AI5, como el simulador anterior
+	s.SetBit(modbusServer.DiscreteInputs, 1, true)
+	s.SetBit(modbusServer.DiscreteInputs, 2, true)
+	for i, v := range []uint16{0, 111, 222, 333, 444, 1, 0, 1} {
+		s.SetWord(modbusServer.InputRegisters, uint16(i), v)
+	}
+	s.SetWord(modbusServer.HoldingRegisters, 0, 112)
+	s.SetWord(modbusServer.HoldingRegisters, 424, 250)
+
+	if err := s.Start(*addr); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Mock LOGO! 8 server on %s", s.Addr())
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+	s.Close()
+}
@@ -0,0 +1,62 @@
+package modbusServer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ServeRTU simula un bus RTU en rw: cada trama la atiende el servidor de su
+// unit y las de units ausentes o con CRC errado quedan sin respuesta, como en
+// un RS-485 real. Vuelve cuando falla la lectura (rw cerrado).
+func ServeRTU(rw io.ReadWriter, units map[byte]*Server) error {
+	for {
+		req := make([]byte, 8, 256)
+		if _, err := io.ReadFull(rw, req[:2]); err != nil {
+			return err
+		}
+		switch fc := req[1]; fc {
+		case fcReadCoils, fcReadDiscreteInputs, fcReadHoldingRegisters, fcReadInputRegisters,
+			fcWriteSingleCoil, fcWriteSingleRegister:
+			if _, err := io.ReadFull(rw, req[2:8]); err != nil {
+				return err
+			}
+		case fcWriteMultipleCoils, fcWriteMultipleRegisters:
+			if _, err := io.ReadFull(rw, req[2:7]); err != nil {
+				return err
+			}
+			req = req[:7+int(req[6])+2]
+			if _, err := io.ReadFull(rw, req[7:]); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("rtu: cannot frame function %d", fc)
+		}
+		n := len(req)
+		s, ok := units[req[0]]
+		if !ok || crc16(req[:n-2]) != binary.LittleEndian.Uint16(req[n-2:]) {
+			continue
+		}
+		res := append([]byte{req[0]}, s.Handle(req[0], req[1:n-2])...)
+		res = binary.LittleEndian.AppendUint16(res, crc16(res))
+		if _, err := rw.Write(res); err != nil {
+			return err
+		}
+	}
+}
+
+// crc16 es el CRC de Modbus RTU (polinomio 0xA001)
+func crc16(b []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, v := range b {
+		crc ^= uint16(v)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,505 @@
+package modbusServer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type Table int
+
+const (
+	DiscreteInputs Table = iota
+	Coils
+	InputRegisters
+	HoldingRegisters
+)
+
+// Códigos de excepción Modbus
+const (
+	IllegalFunction        byte = 0x01
+	IllegalDataAddress     byte = 0x02
+	IllegalDataValue       byte = 0x03
+	ServerDeviceFailure    byte = 0x04
+	ServerDeviceBusy       byte = 0x06
+	GatewayPathUnavailable byte = 0x0A
+	GatewayTargetFailed    byte = 0x0B // el equipo detrás del gateway no respondió
+)
+
+const (
+	fcReadCoils              byte = 0x01
+	fcReadDiscreteInputs     byte = 0x02
+	fcReadHoldingRegisters   byte = 0x03
+	fcReadInputRegisters     byte = 0x04
+	fcWriteSingleCoil        byte = 0x05
+	fcWriteSingleRegister    byte = 0x06
+	fcWriteMultipleCoils     byte = 0x0F
+	fcWriteMultipleRegisters byte = 0x10
+)
+
+type addrRange struct {
+	start uint16
+	count int
+}
+
+// Request es una petición recibida por el servidor
+type Request struct {
+	Unit     byte
+	Function byte
+	Address  uint16
+	Quantity uint16
+	At       time.Time
+}
+
+type exceptionKey struct {
+	function byte
+	address  uint16
+}
+
+// WriteFunc recibe una escritura válida antes de aplicarla: las coils llegan
+// como 0 o 1. Si devuelve error no se aplica y se responde con una excepción:
+// la de Exception o, si no, ServerDeviceFailure.
+type WriteFunc func(t Table, address uint16, values []uint16) error
+
+// Server es un servidor Modbus TCP en memoria, usado como simulador de PLC en
+// pruebas y como imagen de registros del gateway
+type Server struct {
+	OnWrite WriteFunc // nil aplica las escrituras sin más
+	// Forward, si no es nil, responde todas las peticiones en lugar de las
+	// tablas: el servidor solo pone el frente Modbus TCP (ej. hacia un bus RTU)
+	Forward func(unit byte, pdu []byte) []byte
+
+	mu         sync.Mutex
+	bits       [2][]bool
+	words      [2][]uint16
+	ranges     [4][]addrRange
+	exceptions map[exceptionKey]byte
+	delay      time.Duration
+	dropNext   int
+	offline    bool
+	requests   []Request
+
+	ln    net.Listener
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+// NewServer crea un servidor con las 65536 direcciones de cada tabla válidas
+func NewServer() *Server {
+	return &Server{
+		bits:       [2][]bool{make([]bool, 1<<16), make([]bool, 1<<16)},
+		words:      [2][]uint16{make([]uint16, 1<<16), make([]uint16, 1<<16)},
+		exceptions: make(map[exceptionKey]byte),
+		conns:      make(map[net.Conn]bool),
+	}
+}
+
+// AddRange restringe las direcciones válidas de la tabla; fuera de los rangos
+// agregados se responde IllegalDataAddress. Un rango vacío deja la tabla sin
+// direcciones válidas.
+func (s *Server) AddRange(t Table, start uint16, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ranges[t] = append(s.ranges[t], addrRange{start, count})
+}
+
+func (s *Server) SetBit(t Table, address uint16, value bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bits[t][address] = value
+}
+
+func (s *Server) Bit(t Table, address uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bits[t][address]
+}
+
+func (s *Server) SetWord(t Table, address uint16, value uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.words[t-InputRegisters][address] = value
+}
+
+func (s *Server) Word(t Table, address uint16) uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.words[t-InputRegisters][address]
+}
+
+// SetException hace que las peticiones con esa función y dirección inicial
+// respondan con la excepción indicada (0 la quita).
+func (s *Server) SetException(function byte, address uint16, code byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code == 0 {
+		delete(s.exceptions, exceptionKey{function, address})
+		return
+	}
+	s.exceptions[exceptionKey{function, address}] = code
+}
+
+// SetDelay retrasa cada respuesta
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// DropNext cierra la conexión al recibir las próximas n peticiones, sin responder
+func (s *Server) DropNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropNext += n
+}
+
+// SetOffline simula un PLC caído: mientras dure, cada petición cierra la conexión
+func (s *Server) SetOffline(offline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offline = offline
+}
+
+// DropConnections cierra las conexiones abiertas
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+func (s *Server) Start(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("modbus server listen: %w", err)
+	}
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go s.acceptLoop(ln)
+	return nil
+}
+
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return ""
+	}
+	return s.ln.Addr().String()
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.ln != nil {
+		_ = s.ln.Close()
+	}
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) acceptLoop(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		_ = c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	header := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(c, header); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:6])
+		if length < 2 || length > 254 {
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(c, pdu); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.record(header[6], pdu)
+		drop := s.offline || s.dropNext > 0
+		if drop && !s.offline {
+			s.dropNext--
+		}
+		delay := s.delay
+		s.mu.Unlock()
+		if drop {
+			return
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		res := s.Handle(header[6], pdu)
+		adu := make([]byte, 7+len(res))
+		copy(adu, header[:4])
+		binary.BigEndian.PutUint16(adu[4:6], uint16(len(res)+1))
+		adu[6] = header[6]
+		copy(adu[7:], res)
+		if _, err := c.Write(adu); err != nil {
+			return
+		}
+	}
+}
+
+// Handle procesa un PDU y devuelve el PDU de respuesta
+func (s *Server) Handle(unit byte, pdu []byte) []byte {
+	if len(pdu) == 0 {
+		return []byte{0x80, IllegalFunction}
+	}
+	if s.Forward != nil {
+		return s.Forward(unit, pdu)
+	}
+	fc := pdu[0]
+	if err := s.beforeWrite(fc, pdu[1:]); err != nil {
+		var exc exception
+		if !errors.As(err, &exc) {
+			exc = exception(ServerDeviceFailure)
+		}
+		return []byte{fc | 0x80, byte(exc)}
+	}
+	res, err := s.handle(unit, fc, pdu[1:])
+	var exc exception
+	if errors.As(err, &exc) {
+		return []byte{fc | 0x80, byte(exc)}
+	}
+	return append([]byte{fc}, res...)
+}
+
+type exception byte
+
+func (e exception) Error() string {
+	return fmt.Sprintf("modbus exception %d", byte(e))
+}
+
+// Exception es el error con el que un WriteFunc elige el código de excepción
+func Exception(code byte) error {
+	return exception(code)
+}
+
+// beforeWrite pasa a OnWrite las escrituras bien formadas y con direcciones
+// válidas; las demás las rechaza handle. Se llama sin s.mu: OnWrite puede tardar.
+func (s *Server) beforeWrite(fc byte, data []byte) error {
+	if s.OnWrite == nil || len(data) < 4 {
+		return nil
+	}
+	address := binary.BigEndian.Uint16(data[0:2])
+	value := binary.BigEndian.Uint16(data[2:4])
+	var t Table
+	var values []uint16
+	switch fc {
+	case fcWriteSingleCoil:
+		if value != 0xFF00 && value != 0x0000 {
+			return nil
+		}
+		t, values = Coils, []uint16{value >> 15}
+	case fcWriteSingleRegister:
+		t, values = HoldingRegisters, []uint16{value}
+	case fcWriteMultipleCoils:
+		if len(data) < 5 || value == 0 || value > 1968 || int(data[4]) != (int(value)+7)/8 || len(data) < 5+int(data[4]) {
+			return nil
+		}
+		t, values = Coils, make([]uint16, value)
+		for i := range values {
+			if data[5+i/8]&(1<<(i%8)) != 0 {
+				values[i] = 1
+			}
+		}
+	case fcWriteMultipleRegisters:
+		if len(data) < 5 || value == 0 || value > 123 || int(data[4]) != 2*int(value) || len(data) < 5+int(data[4]) {
+			return nil
+		}
+		t, values = HoldingRegisters, make([]uint16, value)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(data[5+2*i:])
+		}
+	default:
+		return nil
+	}
+	s.mu.Lock()
+	valid := s.valid(t, address, uint16(len(values)))
+	s.mu.Unlock()
+	if !valid {
+		return nil
+	}
+	return s.OnWrite(t, address, values)
+}
+
+func (s *Server) handle(unit byte, fc byte, data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, exception(IllegalDataValue)
+	}
+	address := binary.BigEndian.Uint16(data[0:2])
+	value := binary.BigEndian.Uint16(data[2:4])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quantity := value
+	if fc == fcWriteSingleCoil || fc == fcWriteSingleRegister {
+		quantity = 1
+	}
+	if code, ok := s.exceptions[exceptionKey{fc, address}]; ok {
+		return nil, exception(code)
+	}
+
+	switch fc {
+	case fcReadCoils, fcReadDiscreteInputs:
+		t := Coils
+		if fc == fcReadDiscreteInputs {
+			t = DiscreteInputs
+		}
+		if quantity == 0 || quantity > 2000 {
+			return nil, exception(IllegalDataValue)
+		}
+		if !s.valid(t, address, quantity) {
+			return nil, exception(IllegalDataAddress)
+		}
+		res := make([]byte, 1+(int(quantity)+7)/8)
+		res[0] = byte(len(res) - 1)
+		for i := 0; i < int(quantity); i++ {
+			if s.bits[t][int(address)+i] {
+				res[1+i/8] |= 1 << (i % 8)
+			}
+		}
+		return res, nil
+
+	case fcReadHoldingRegisters, fcReadInputRegisters:
+		t := HoldingRegisters
+		if fc == fcReadInputRegisters {
+			t = InputRegisters
+		}
+		if quantity == 0 || quantity > 125 {
+			return nil, exception(IllegalDataValue)
+		}
+		if !s.valid(t, address, quantity) {
+			return nil, exception(IllegalDataAddress)
+		}
+		res := make([]byte, 1+2*int(quantity))
+		res[0] = byte(2 * quantity)
+		for i := 0; i < int(quantity); i++ {
+			binary.BigEndian.PutUint16(res[1+2*i:], s.words[t-InputRegisters][int(address)+i])
+		}
+		return res, nil
+
+	case fcWriteSingleCoil:
+		if value != 0xFF00 && value != 0x0000 {
+			return nil, exception(IllegalDataValue)
+		}
+		if !s.valid(Coils, address, 1) {
+			return nil, exception(IllegalDataAddress)
+		}
+		s.bits[Coils][address] = value == 0xFF00
+		return data[:4], nil
+
+	case fcWriteSingleRegister:
+		if !s.valid(HoldingRegisters, address, 1) {
+			return nil, exception(IllegalDataAddress)
+		}
+		s.words[HoldingRegisters-InputRegisters][address] = value
+		return data[:4], nil
+
+	case fcWriteMultipleCoils:
+		if len(data) < 5 || quantity == 0 || quantity > 1968 || int(data[4]) != (int(quantity)+7)/8 || len(data) < 5+int(data[4]) {
+			return nil, exception(IllegalDataValue)
+		}
+		if !s.valid(Coils, address, quantity) {
+			return nil, exception(IllegalDataAddress)
+		}
+		for i := 0; i < int(quantity); i++ {
+			s.bits[Coils][int(address)+i] = data[5+i/8]&(1<<(i%8)) != 0
+		}
+		return data[:4], nil
+
+	case fcWriteMultipleRegisters:
+		if len(data) < 5 || quantity == 0 || quantity > 123 || int(data[4]) != 2*int(quantity) || len(data) < 5+int(data[4]) {
+			return nil, exception(IllegalDataValue)
+		}
+		if !s.valid(HoldingRegisters, address, quantity) {
+			return nil, exception(IllegalDataAddress)
+		}
+		for i := 0; i < int(quantity); i++ {
+			s.words[HoldingRegisters-InputRegisters][int(address)+i] = binary.BigEndian.Uint16(data[5+2*i:])
+		}
+		return data[:4], nil
+	}
+	return nil, exception(IllegalFunction)
+}
+
+// record guarda la petición recibida, aunque luego se descarte; se llama con s.mu tomado
+func (s *Server) record(unit byte, pdu []byte) {
+	r := Request{Unit: unit, At: time.Now()}
+	if len(pdu) > 0 {
+		r.Function = pdu[0]
+	}
+	if len(pdu) >= 5 {
+		r.Address = binary.BigEndian.Uint16(pdu[1:3])
+		r.Quantity = binary.BigEndian.Uint16(pdu[3:5])
+		if r.Function == fcWriteSingleCoil || r.Function == fcWriteSingleRegister {
+			r.Quantity = 1
+		}
+	}
+	s.requests = append(s.requests, r)
+}
+
+// valid indica si todo el bloque [address, address+quantity) cae en los
+// rangos, aunque abarque varios contiguos; se llama con s.mu tomado
+func (s *Server) valid(t Table, address uint16, quantity uint16) bool {
+	end := int(address) + int(quantity)
+	if end > 1<<16 {
+		return false
+	}
+	if len(s.ranges[t]) == 0 {
+		return true
+	}
+	for a := int(address); a < end; {
+		next := a
+		for _, r := range s.ranges[t] {
+			if a >= int(r.start) && a < int(r.start)+r.count {
+				next = int(r.start) + r.count
+				break
+			}
+		}
+		if next == a {
+			return false
+		}
+		a = next
+	}
+	return true
+}
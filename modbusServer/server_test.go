@@ -0,0 +1,136 @@
+package modbusServer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func startServer(t *testing.T, s *Server, timeout time.Duration) modbus.Client {
+	t.Helper()
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	h := modbus.NewTCPClientHandler(s.Addr())
+	h.Timeout = timeout
+	h.SlaveId = 1
+	t.Cleanup(func() {
+		_ = h.Close()
+	})
+	return modbus.NewClient(h)
+}
+
+func exceptionCode(err error) byte {
+	var mbErr *modbus.ModbusError
+	if errors.As(err, &mbErr) {
+		return mbErr.ExceptionCode
+	}
+	return 0
+}
+
+func TestServer_ReadWrite(t *testing.T) {
+	s := NewLogo8()
+	s.SetBit(DiscreteInputs, 2, true)
+	s.SetWord(InputRegisters, 1, 111)
+	c := startServer(t, s, time.Second)
+
+	if b, err := c.ReadDiscreteInputs(0, 4); err != nil || b[0] != 0b0100 {
+		t.Errorf("read inputs: got %v %v", b, err)
+	}
+	if b, err := c.ReadInputRegisters(0, 2); err != nil || b[3] != 111 {
+		t.Errorf("read input registers: got %v %v", b, err)
+	}
+	if _, err := c.WriteMultipleCoils(LogoOutputs, 3, []byte{0b101}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Bit(Coils, LogoOutputs) || s.Bit(Coils, LogoOutputs+1) || !s.Bit(Coils, LogoOutputs+2) {
+		t.Errorf("write multiple coils not applied")
+	}
+	if _, err := c.WriteMultipleRegisters(LogoVM, 2, []byte{0x01, 0xFE, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Word(HoldingRegisters, LogoVM) != 0x01FE {
+		t.Errorf("write multiple registers not applied")
+	}
+}
+
+func TestServer_Exceptions(t *testing.T) {
+	s := NewLogo8()
+	c := startServer(t, s, time.Second)
+
+	if _, err := c.WriteSingleCoil(8888, 0xFF00); exceptionCode(err) != IllegalDataAddress {
+		t.Errorf("coil outside LOGO! map should be illegal address, got %v", err)
+	}
+	if _, err := c.ReadCoils(LogoOutputs+18, 4); exceptionCode(err) != IllegalDataAddress {
+		t.Errorf("read crossing the end of Q20 should fail, got %v", err)
+	}
+	s.SetException(fcReadInputRegisters, 0, ServerDeviceFailure)
+	if _, err := c.ReadInputRegisters(0, 1); exceptionCode(err) != ServerDeviceFailure {
+		t.Errorf("scripted exception not returned, got %v", err)
+	}
+	s.SetException(fcReadInputRegisters, 0, 0)
+	if _, err := c.ReadInputRegisters(0, 1); err != nil {
+		t.Errorf("exception should be cleared, got %v", err)
+	}
+}
+
+func TestServer_DelayAndDrop(t *testing.T) {
+	s := NewLogo8()
+	c := startServer(t, s, 200*time.Millisecond)
+
+	s.SetDelay(400 * time.Millisecond)
+	if _, err := c.ReadCoils(LogoOutputs, 1); err == nil {
+		t.Errorf("delayed response should time out")
+	}
+	s.SetDelay(0)
+
+	s.DropNext(1)
+	if _, err := c.ReadCoils(LogoOutputs, 1); err == nil {
+		t.Errorf("dropped connection should fail")
+	}
+	if len(s.Requests()) == 0 {
+		t.Errorf("requests should be recorded")
+	}
+}
+
+func TestServer_OnWrite(t *testing.T) {
+	s := NewServer()
+	s.AddRange(Coils, 0, 2)
+	s.AddRange(Coils, 2, 2)
+	s.AddRange(HoldingRegisters, 0, 0)
+	var got []uint16
+	s.OnWrite = func(tb Table, address uint16, values []uint16) error {
+		if address == 3 {
+			return Exception(IllegalDataAddress)
+		}
+		if address == 2 {
+			return errors.New("plc down")
+		}
+		got = values
+		return nil
+	}
+	c := startServer(t, s, time.Second)
+
+	// bloque que cruza dos rangos contiguos
+	if _, err := c.WriteMultipleCoils(0, 2, []byte{0b10}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 0 || got[1] != 1 || !s.Bit(Coils, 1) {
+		t.Errorf("hook should see the coils and the write be applied: %v", got)
+	}
+	if b, err := c.ReadCoils(0, 4); err != nil || b[0] != 0b10 {
+		t.Errorf("read across adjacent ranges: %v %v", b, err)
+	}
+	if _, err := c.WriteSingleCoil(3, 0xFF00); exceptionCode(err) != IllegalDataAddress || s.Bit(Coils, 3) {
+		t.Errorf("hook exception should be returned and the write dropped, got %v", err)
+	}
+	if _, err := c.WriteSingleCoil(2, 0xFF00); exceptionCode(err) != ServerDeviceFailure || s.Bit(Coils, 2) {
+		t.Errorf("hook error should be a device failure, got %v", err)
+	}
+	if _, err := c.ReadHoldingRegisters(0, 1); exceptionCode(err) != IllegalDataAddress {
+		t.Errorf("empty range should leave the table without addresses, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"mt-plc-control/clock"
+	"mt-plc-control/mqttClient"
+	"mt-plc-control/uplink"
+	"path"
+	"slices"
+	"time"
+)
+
+// Modos de publicación de MQTT_MODE
+const (
+	mqttModeTags      = "tags"      // un topic retenido por tag
+	mqttModeSnapshot  = "snapshot"  // un JSON con todos los tags por envío
+	mqttModeSparkplug = "sparkplug" // Sparkplug B: el gateway es un edge node y el PLC su device
+)
+
+// mqttRetry es cada cuánto se reintenta la conexión si no hay nada que publicar
+const mqttRetry = 30 * time.Second
+
+// mqttUplink publica en MQTT los mismos envíos que van a Wialon y recibe
+// comandos en <prefix>/cmd/<tipo> (W, RH o GS, con el mismo mensaje que #M#).
+// Publica desde su propia goroutine: un broker caído no demora el escaneo.
+type mqttUplink struct {
+	client   *mqttClient.Client
+	prefix   string
+	snapshot bool
+
+	latest   chan mqttScan // solo el último envío pendiente
+	commands chan mqttClient.Message
+	clock    clock.Clock // nil usa el reloj real
+}
+
+type mqttTag struct {
+	Value   float64   `json:"value"`
+	Quality string    `json:"quality"`
+	Time    time.Time `json:"ts"`
+}
+
+type mqttScan struct {
+	Time time.Time          `json:"ts"`
+	Tags map[string]mqttTag `json:"tags"`
+}
+
+func newMQTTUplink(opts mqttClient.Options, prefix, mode string, clk clock.Clock) *mqttUplink {
+	u := &mqttUplink{
+		prefix:   prefix,
+		snapshot: mode == mqttModeSnapshot,
+		latest:   make(chan mqttScan, 1),
+		commands: make(chan mqttClient.Message, 8),
+		clock:    clk,
+	}
+	// el estado queda retenido: online al conectar, offline (will) si se corta
+	opts.Will = &mqttClient.Message{Topic: u.topic("status"), Payload: []byte("offline"), QoS: 1, Retain: true}
+	opts.OnConnect = func() {
+		err := u.client.Publish(context.Background(),
+			mqttClient.Message{Topic: u.topic("status"), Payload: []byte("online"), QoS: 1, Retain: true})
+		if err != nil {
+			log.Printf("mqtt: %v", err)
+		}
+	}
+	u.client = mqttClient.NewClient(opts)
+	return u
+}
+
+func (u *mqttUplink) topic(parts ...string) string {
+	return path.Join(append([]string{u.prefix}, parts...)...)
+}
+
+func (u *mqttUplink) Name() string {
+	return "mqtt"
+}
+
+// Publish deja los envíos para la goroutine de Run; si había otro pendiente
+// lo reemplaza, porque los tags retenidos solo guardan el último valor
+func (u *mqttUplink) Publish(_ context.Context, b uplink.Batch) error {
+	if !b.Upload {
+		return nil
+	}
+	scan := mqttScan{Time: b.Time, Tags: make(map[string]mqttTag, len(b.Samples))}
+	for _, s := range b.Samples {
+		scan.Tags[s.Name] = mqttTag{Value: s.Value, Quality: s.Quality.String(), Time: s.Time}
+	}
+	select {
+	case <-u.latest:
+	default:
+	}
+	select {
+	case u.latest <- scan:
+	default:
+	}
+	return nil
+}
+
+// Run publica los envíos y pasa los comandos recibidos a exec hasta que se
+// cancele ctx; los errores del broker solo se registran.
+func (u *mqttUplink) Run(ctx context.Context, exec func(uplink.Command) error) {
+	defer u.client.Close()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-u.commands:
+				cmd, err := uplink.ParseCommand(path.Base(m.Topic), string(m.Payload))
+				if err != nil {
+					log.Printf("mqtt %s: %v", m.Topic, err)
+					if len(cmd.Writes) == 0 {
+						continue
+					}
+				}
+				if err := exec(cmd); err != nil {
+					log.Printf("mqtt %s: %v", m.Topic, err)
+				}
+			}
+		}
+	}()
+
+	err := u.client.Subscribe(ctx, u.topic("cmd", "+"), 1, func(m mqttClient.Message) {
+		select {
+		case u.commands <- m:
+		default:
+			log.Printf("mqtt: command queue full, dropping %s", m.Topic)
+		}
+	})
+	if err != nil {
+		log.Printf("mqtt: %v", err)
+	}
+
+	retry := clock.Or(u.clock).NewTicker(mqttRetry)
+	defer retry.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-retry.Chan():
+			if !u.client.Connected() {
+				if err := u.client.Connect(ctx); err != nil {
+					log.Printf("mqtt: %v", err)
+				}
+			}
+		case scan := <-u.latest:
+			if err := u.send(ctx, scan); err != nil && ctx.Err() == nil {
+				log.Printf("mqtt: %v", err)
+			}
+		}
+	}
+}
+
+func (u *mqttUplink) send(ctx context.Context, scan mqttScan) error {
+	if u.snapshot {
+		payload, err := json.Marshal(scan)
+		if err != nil {
+			return err
+		}
+		return u.client.Publish(ctx, mqttClient.Message{Topic: u.topic("snapshot"), Payload: payload, QoS: 1, Retain: true})
+	}
+	for _, name := range slices.Sorted(maps.Keys(scan.Tags)) {
+		payload, err := json.Marshal(scan.Tags[name])
+		if err != nil {
+			return err
+		}
+		m := mqttClient.Message{Topic: u.topic("tags", name), Payload: payload, QoS: 1, Retain: true}
+		if err := u.client.Publish(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
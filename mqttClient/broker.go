@@ -0,0 +1,335 @@
+package mqttClient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Received es un PUBLISH que llegó al Broker
+type Received struct {
+	Message
+	ClientID string
+	At       time.Time
+}
+
+// Broker es un broker MQTT 3.1.1 mínimo en proceso, para pruebas y corridas
+// locales: QoS 0 y 1, mensajes retenidos, comodines y will. Guarda todo lo
+// que publican los clientes.
+type Broker struct {
+	Logf func(format string, args ...any)
+
+	mu       sync.Mutex
+	ln       net.Listener
+	clients  map[*brokerConn]bool
+	retained map[string]Message
+	received []Received
+	offline  bool
+	notify   chan struct{}
+	wg       sync.WaitGroup
+}
+
+type brokerConn struct {
+	conn     net.Conn
+	clientID string
+	will     *Message
+	subs     map[string]byte
+	writeMu  sync.Mutex
+	nextID   uint16
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		clients:  make(map[*brokerConn]bool),
+		retained: make(map[string]Message),
+		notify:   make(chan struct{}),
+	}
+}
+
+// Start escucha en address ("127.0.0.1:0" para un puerto libre)
+func (b *Broker) Start(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("mqtt broker listen: %w", err)
+	}
+	b.mu.Lock()
+	b.ln = ln
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go b.acceptLoop(ln)
+	return nil
+}
+
+func (b *Broker) Addr() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ln == nil {
+		return ""
+	}
+	return b.ln.Addr().String()
+}
+
+func (b *Broker) Close() {
+	b.mu.Lock()
+	if b.ln != nil {
+		_ = b.ln.Close()
+	}
+	for c := range b.clients {
+		_ = c.conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// SetOffline simula un corte: cierra las conexiones y rechaza las nuevas mientras dure
+func (b *Broker) SetOffline(offline bool) {
+	b.mu.Lock()
+	b.offline = offline
+	b.mu.Unlock()
+	if offline {
+		b.DropConnections()
+	}
+}
+
+// DropConnections corta las conexiones sin DISCONNECT: se publican los will
+func (b *Broker) DropConnections() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		_ = c.conn.Close()
+	}
+}
+
+// Publish publica m a los suscriptores como si viniera de otro cliente
+func (b *Broker) Publish(m Message) {
+	if m.Retain {
+		b.mu.Lock()
+		b.retained[m.Topic] = m
+		b.mu.Unlock()
+	}
+	b.route(m)
+}
+
+// Subscribed indica si algún cliente está suscrito exactamente a filter
+func (b *Broker) Subscribed(filter string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		if _, ok := c.subs[filter]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Retained devuelve el último mensaje retenido en topic
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.retained[topic]
+	return m, ok
+}
+
+func (b *Broker) Received() []Received {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Received{}, b.received...)
+}
+
+// WaitMessage espera hasta timeout un mensaje publicado por un cliente en un
+// topic que cumpla filter, con índice >= from
+func (b *Broker) WaitMessage(filter string, from int, timeout time.Duration) (Received, int, bool) {
+	deadline := time.After(timeout)
+	for {
+		b.mu.Lock()
+		for i := from; i < len(b.received); i++ {
+			if match(filter, b.received[i].Topic) {
+				m := b.received[i]
+				b.mu.Unlock()
+				return m, i, true
+			}
+		}
+		notify := b.notify
+		b.mu.Unlock()
+		select {
+		case <-notify:
+		case <-deadline:
+			return Received{}, -1, false
+		}
+	}
+}
+
+func (b *Broker) acceptLoop(ln net.Listener) {
+	defer b.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		b.wg.Add(1)
+		go b.serve(conn)
+	}
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	defer b.wg.Done()
+	c := &brokerConn{conn: conn, subs: make(map[string]byte)}
+	r := bufio.NewReader(conn)
+	clean := false
+	defer func() {
+		_ = conn.Close()
+		b.mu.Lock()
+		delete(b.clients, c)
+		b.mu.Unlock()
+		if !clean && c.will != nil {
+			b.logf("mqtt broker: %s lost, publishing will on %s", c.clientID, c.will.Topic)
+			b.store(*c.will, c.clientID)
+			b.route(*c.will)
+		}
+	}()
+
+	p, err := readPacket(r)
+	if err != nil || p.kind != typeConnect {
+		return
+	}
+	hello, err := parseConnect(p)
+	if err != nil {
+		b.logf("mqtt broker: %v", err)
+		return
+	}
+	b.mu.Lock()
+	offline := b.offline
+	if !offline {
+		c.clientID, c.will = hello.clientID, hello.will
+		b.clients[c] = true
+	}
+	b.mu.Unlock()
+	if offline {
+		return
+	}
+	if c.write(packet{kind: typeConnack, body: []byte{0, 0}}) != nil {
+		return
+	}
+
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch p.kind {
+		case typePublish:
+			m, id, err := parsePublish(p)
+			if err != nil {
+				b.logf("mqtt broker: %v", err)
+				return
+			}
+			m.Payload = append([]byte{}, m.Payload...)
+			b.store(m, c.clientID)
+			if m.QoS == 1 && c.write(idPacket(typePuback, id)) != nil {
+				return
+			}
+			b.route(m)
+		case typeSubscribe:
+			rd := &reader{b: p.body}
+			id := rd.uint16()
+			codes := make([]byte, 0, 1)
+			filters := make([]string, 0, 1)
+			for len(rd.b) > 0 && rd.err == nil {
+				filter, qos := rd.string(), rd.byte()
+				filters = append(filters, filter)
+				codes = append(codes, min(qos, 1))
+			}
+			if rd.err != nil {
+				return
+			}
+			b.mu.Lock()
+			for i, f := range filters {
+				c.subs[f] = codes[i]
+			}
+			retained := make([]Message, 0)
+			for topic, m := range b.retained {
+				for _, f := range filters {
+					if match(f, topic) {
+						retained = append(retained, m)
+						break
+					}
+				}
+			}
+			b.mu.Unlock()
+			body := append(binary.BigEndian.AppendUint16(nil, id), codes...)
+			if c.write(packet{kind: typeSuback, body: body}) != nil {
+				return
+			}
+			for _, m := range retained {
+				b.deliver(c, m)
+			}
+		case typePingreq:
+			if c.write(packet{kind: typePingresp}) != nil {
+				return
+			}
+		case typeDisconnect:
+			clean = true
+			return
+		}
+	}
+}
+
+// store guarda un mensaje recibido y actualiza el retenido
+func (b *Broker) store(m Message, clientID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.received = append(b.received, Received{m, clientID, time.Now()})
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// route entrega m a los suscriptores
+func (b *Broker) route(m Message) {
+	b.mu.Lock()
+	targets := make([]*brokerConn, 0)
+	for c := range b.clients {
+		for f := range c.subs {
+			if match(f, m.Topic) {
+				targets = append(targets, c)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	m.Retain = false
+	for _, c := range targets {
+		b.deliver(c, m)
+	}
+}
+
+func (b *Broker) deliver(c *brokerConn, m Message) {
+	c.writeMu.Lock()
+	c.nextID = max(c.nextID+1, 1)
+	id := c.nextID
+	c.writeMu.Unlock()
+	_ = c.write(publishPacket(m, id))
+}
+
+func (c *brokerConn) write(p packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(p.encode())
+	return err
+}
+
+func (b *Broker) logf(format string, args ...any) {
+	if b.Logf != nil {
+		b.Logf(format, args...)
+	}
+}
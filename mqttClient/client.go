@@ -0,0 +1,380 @@
+package mqttClient
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeepAlive = 30 * time.Second
+	defaultTimeout   = 5 * time.Second
+)
+
+var (
+	ErrClosed       = errors.New("mqtt: client closed")
+	errDisconnected = errors.New("mqtt: connection lost")
+)
+
+// Options configura un Client
+type Options struct {
+	Addr      string // host:puerto del broker
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration // 0 usa 30 s
+	Timeout   time.Duration // espera de CONNACK, PUBACK y SUBACK; 0 usa 5 s
+	Will      *Message      // lo publica el broker si la conexión se corta sin DISCONNECT
+
+	// OnConnect, si no es nil, se llama tras cada conexión nueva (ya con las
+	// suscripciones renovadas); puede publicar.
+	OnConnect func()
+}
+
+// Client es un cliente MQTT 3.1.1 (QoS 0 y 1, clean session). Se conecta al
+// primer uso y, si la conexión se cae, en el siguiente Publish; al reconectar
+// renueva las suscripciones.
+type Client struct {
+	opts Options
+
+	connMu  sync.Mutex // serializa las conexiones
+	writeMu sync.Mutex // serializa las escrituras al socket
+
+	mu      sync.Mutex
+	conn    net.Conn
+	stop    chan struct{} // se cierra al caer conn
+	pending map[uint16]chan error
+	subs    map[string]subscription
+	nextID  uint16
+	closed  bool
+}
+
+type subscription struct {
+	qos     byte
+	handler func(Message)
+}
+
+func NewClient(opts Options) *Client {
+	opts.KeepAlive = cmp.Or(opts.KeepAlive, defaultKeepAlive)
+	opts.Timeout = cmp.Or(opts.Timeout, defaultTimeout)
+	return &Client{opts: opts, pending: make(map[uint16]chan error), subs: make(map[string]subscription)}
+}
+
+// Connected indica si hay una conexión abierta con el broker
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Connect abre la conexión si no está abierta
+func (c *Client) Connect(ctx context.Context) error {
+	_, err := c.connect(ctx)
+	return err
+}
+
+// connect devuelve fresh=true si abrió una conexión nueva (y ya renovó las suscripciones)
+func (c *Client) connect(ctx context.Context) (fresh bool, err error) {
+	fresh, err = c.dial(ctx)
+	if fresh && c.opts.OnConnect != nil {
+		c.opts.OnConnect()
+	}
+	return fresh, err
+}
+
+func (c *Client) dial(ctx context.Context) (fresh bool, err error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.mu.Lock()
+	conn, closed := c.conn, c.closed
+	c.mu.Unlock()
+	if closed {
+		return false, ErrClosed
+	}
+	if conn != nil {
+		return false, nil
+	}
+
+	c.mu.Lock()
+	will := c.opts.Will
+	c.mu.Unlock()
+	d := net.Dialer{Timeout: c.opts.Timeout}
+	conn, err = d.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return false, fmt.Errorf("mqtt: %w", err)
+	}
+	r := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	hello := connect{
+		clientID:  c.opts.ClientID,
+		username:  c.opts.Username,
+		password:  c.opts.Password,
+		keepAlive: uint16(c.opts.KeepAlive / time.Second),
+		will:      will,
+	}
+	if _, err := conn.Write(hello.packet().encode()); err != nil {
+		_ = conn.Close()
+		return false, fmt.Errorf("mqtt connect: %w", err)
+	}
+	ack, err := readPacket(r)
+	if err == nil && (ack.kind != typeConnack || len(ack.body) != 2) {
+		err = errMalformed
+	}
+	if err == nil && ack.body[1] != 0 {
+		err = fmt.Errorf("refused with code %d", ack.body[1])
+	}
+	if err != nil {
+		_ = conn.Close()
+		return false, fmt.Errorf("mqtt connect: %w", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	stop := make(chan struct{})
+	c.mu.Lock()
+	c.conn, c.stop = conn, stop
+	subs := make(map[string]byte, len(c.subs))
+	for filter, s := range c.subs {
+		subs[filter] = s.qos
+	}
+	c.mu.Unlock()
+	go c.readLoop(conn, r)
+	go c.keepAlive(conn, stop)
+
+	for filter, qos := range subs {
+		if err := c.subscribe(ctx, conn, filter, qos); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// SetWill cambia el will de las próximas conexiones; la actual conserva el suyo
+func (c *Client) SetWill(m *Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts.Will = m
+}
+
+// Publish envía m; con QoS 1 espera el PUBACK. Si falla, la conexión se
+// descarta y el próximo Publish reconecta.
+func (c *Client) Publish(ctx context.Context, m Message) error {
+	if m.QoS > 1 {
+		return fmt.Errorf("mqtt: QoS %d not supported", m.QoS)
+	}
+	if _, err := c.connect(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return errDisconnected
+	}
+	if m.QoS == 0 {
+		return c.write(conn, publishPacket(m, 0))
+	}
+	id, done := c.track()
+	if err := c.write(conn, publishPacket(m, id)); err != nil {
+		c.untrack(id)
+		return err
+	}
+	if err := c.wait(ctx, conn, id, done); err != nil {
+		return fmt.Errorf("mqtt publish %s: %w", m.Topic, err)
+	}
+	return nil
+}
+
+// Subscribe se suscribe a filter; handler se llama desde el lector de la
+// conexión, así que no debe bloquearse. La suscripción se renueva al reconectar.
+func (c *Client) Subscribe(ctx context.Context, filter string, qos byte, handler func(Message)) error {
+	c.mu.Lock()
+	c.subs[filter] = subscription{qos, handler}
+	c.mu.Unlock()
+	fresh, err := c.connect(ctx)
+	if err != nil || fresh {
+		return err
+	}
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return errDisconnected
+	}
+	return c.subscribe(ctx, conn, filter, qos)
+}
+
+func (c *Client) subscribe(ctx context.Context, conn net.Conn, filter string, qos byte) error {
+	id, done := c.track()
+	if err := c.write(conn, subscribePacket(id, filter, qos)); err != nil {
+		c.untrack(id)
+		return err
+	}
+	if err := c.wait(ctx, conn, id, done); err != nil {
+		return fmt.Errorf("mqtt subscribe %s: %w", filter, err)
+	}
+	return nil
+}
+
+// Close envía DISCONNECT (el broker no publica el will) y cierra el cliente
+func (c *Client) Close() {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.mu.Lock()
+	conn := c.conn
+	c.closed = true
+	c.mu.Unlock()
+	if conn != nil {
+		_ = c.write(conn, packet{kind: typeDisconnect})
+		c.drop(conn, ErrClosed)
+	}
+}
+
+func (c *Client) write(conn net.Conn, p packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	if _, err := conn.Write(p.encode()); err != nil {
+		c.drop(conn, err)
+		return fmt.Errorf("mqtt: %w", err)
+	}
+	return nil
+}
+
+// track reserva un packet id y el canal por el que llega su confirmación
+func (c *Client) track() (uint16, chan error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		c.nextID++
+		if _, used := c.pending[c.nextID]; c.nextID != 0 && !used {
+			break
+		}
+	}
+	done := make(chan error, 1)
+	c.pending[c.nextID] = done
+	return c.nextID, done
+}
+
+func (c *Client) untrack(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+// wait espera la confirmación de id; si no llega a tiempo descarta la conexión
+func (c *Client) wait(ctx context.Context, conn net.Conn, id uint16, done chan error) error {
+	timer := time.NewTimer(c.opts.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		c.untrack(id)
+		c.drop(conn, errDisconnected)
+		return errors.New("no ack from broker")
+	case <-ctx.Done():
+		c.untrack(id)
+		return ctx.Err()
+	}
+}
+
+// ack entrega la confirmación de id a quien la espera
+func (c *Client) ack(id uint16, err error) {
+	c.mu.Lock()
+	done, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if ok {
+		done <- err
+	}
+}
+
+// drop descarta conn si sigue siendo la conexión actual
+func (c *Client) drop(conn net.Conn, err error) {
+	c.mu.Lock()
+	if c.conn != conn {
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	close(c.stop)
+	pending := c.pending
+	c.pending = make(map[uint16]chan error)
+	c.mu.Unlock()
+	_ = conn.Close()
+	for _, done := range pending {
+		done <- err
+	}
+}
+
+func (c *Client) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		// el broker responde cada PINGREQ: sin nada en 1,5 keep-alive la conexión está muerta
+		_ = conn.SetReadDeadline(time.Now().Add(c.opts.KeepAlive * 3 / 2))
+		p, err := readPacket(r)
+		if err != nil {
+			c.drop(conn, errDisconnected)
+			return
+		}
+		switch p.kind {
+		case typePuback:
+			rd := &reader{b: p.body}
+			if id := rd.uint16(); rd.err == nil {
+				c.ack(id, nil)
+			}
+		case typeSuback:
+			rd := &reader{b: p.body}
+			id, code := rd.uint16(), rd.byte()
+			if rd.err != nil {
+				break
+			}
+			if code == 0x80 {
+				c.ack(id, errors.New("subscription refused"))
+			} else {
+				c.ack(id, nil)
+			}
+		case typePublish:
+			m, id, err := parsePublish(p)
+			if err != nil {
+				c.drop(conn, err)
+				return
+			}
+			if m.QoS == 1 {
+				if err := c.write(conn, idPacket(typePuback, id)); err != nil {
+					return
+				}
+			}
+			c.mu.Lock()
+			handlers := make([]func(Message), 0, 1)
+			for filter, s := range c.subs {
+				if match(filter, m.Topic) {
+					handlers = append(handlers, s.handler)
+				}
+			}
+			c.mu.Unlock()
+			for _, h := range handlers {
+				h(m)
+			}
+		}
+	}
+}
+
+func (c *Client) keepAlive(conn net.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(c.opts.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if c.write(conn, packet{kind: typePingreq}) != nil {
+				return
+			}
+		}
+	}
+}
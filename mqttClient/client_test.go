@@ -0,0 +1,133 @@
+package mqttClient
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func startBroker(t *testing.T) *Broker {
+	t.Helper()
+	b := NewBroker()
+	if err := b.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return b
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"#", "a/b", true},
+		{"a/b/c", "a/b", false},
+	} {
+		if got := match(c.filter, c.topic); got != c.want {
+			t.Errorf("match(%q, %q) = %v", c.filter, c.topic, got)
+		}
+	}
+}
+
+func TestClient_PublishSubscribe(t *testing.T) {
+	b := startBroker(t)
+	ctx := context.Background()
+	pub := NewClient(Options{Addr: b.Addr(), ClientID: "pub", Username: "u", Password: "p"})
+	defer pub.Close()
+	if err := pub.Publish(ctx, Message{Topic: "plc/1/q1", Payload: []byte("1"), QoS: 1, Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := b.Retained("plc/1/q1"); !ok || string(m.Payload) != "1" {
+		t.Fatalf("retained message: %+v", m)
+	}
+
+	got := make(chan Message, 4)
+	sub := NewClient(Options{Addr: b.Addr(), ClientID: "sub"})
+	defer sub.Close()
+	if err := sub.Subscribe(ctx, "plc/+/q1", 1, func(m Message) { got <- m }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if !m.Retain || string(m.Payload) != "1" {
+			t.Errorf("subscribing should deliver the retained value: %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("retained message not delivered")
+	}
+
+	if err := pub.Publish(ctx, Message{Topic: "plc/1/q1", Payload: []byte("0"), QoS: 1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if m.Retain || string(m.Payload) != "0" {
+			t.Errorf("live message: %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("live message not delivered")
+	}
+}
+
+func TestClient_ReconnectAndWill(t *testing.T) {
+	b := startBroker(t)
+	ctx := context.Background()
+	var c *Client
+	c = NewClient(Options{
+		Addr:     b.Addr(),
+		ClientID: "gw",
+		Timeout:  time.Second,
+		Will:     &Message{Topic: "plc/1/status", Payload: []byte("offline"), QoS: 1, Retain: true},
+		OnConnect: func() {
+			_ = c.Publish(context.Background(), Message{Topic: "plc/1/status", Payload: []byte("online"), QoS: 1, Retain: true})
+		},
+	})
+	defer c.Close()
+	got := make(chan Message, 4)
+	if err := c.Subscribe(ctx, "plc/1/cmd/#", 1, func(m Message) { got <- m }); err != nil {
+		t.Fatal(err)
+	}
+
+	b.SetOffline(true)
+	if _, _, ok := b.WaitMessage("plc/1/status", 0, 2*time.Second); !ok {
+		t.Fatal("will should be published when the connection drops")
+	}
+	if err := c.Publish(ctx, Message{Topic: "plc/1/q1", Payload: []byte("1"), QoS: 1}); err == nil {
+		t.Fatal("publish should fail while the broker is offline")
+	}
+
+	b.SetOffline(false)
+	if err := c.Publish(ctx, Message{Topic: "plc/1/q1", Payload: []byte("1"), QoS: 1}); err != nil {
+		t.Fatalf("publish should reconnect: %v", err)
+	}
+	if !b.Subscribed("plc/1/cmd/#") {
+		t.Fatal("subscriptions should be renewed on reconnect")
+	}
+	if m, _ := b.Retained("plc/1/status"); string(m.Payload) != "online" {
+		t.Errorf("OnConnect should run on reconnect, status %q", m.Payload)
+	}
+	b.Publish(Message{Topic: "plc/1/cmd/W", Payload: []byte("q1=1"), QoS: 1})
+	select {
+	case m := <-got:
+		if m.Topic != "plc/1/cmd/W" || string(m.Payload) != "q1=1" {
+			t.Errorf("command: %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("command not delivered after reconnect")
+	}
+
+	c.Close()
+	n := len(b.Received())
+	time.Sleep(50 * time.Millisecond)
+	if len(b.Received()) != n {
+		t.Errorf("a clean disconnect should not publish the will")
+	}
+}
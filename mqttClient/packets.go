@@ -0,0 +1,262 @@
+package mqttClient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Tipos de paquete de MQTT 3.1.1 (4 bits altos del primer byte)
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typePuback     = 4
+	typeSubscribe  = 8
+	typeSuback     = 9
+	typePingreq    = 12
+	typePingresp   = 13
+	typeDisconnect = 14
+)
+
+// maxPacket limita lo que se acepta del otro lado
+const maxPacket = 1 << 20
+
+var errMalformed = errors.New("mqtt: malformed packet")
+
+// Message es un PUBLISH: lo que se publica, lo que llega de una suscripción
+// y el testamento (will) de la conexión.
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte // 0 o 1; QoS 2 no está soportado
+	Retain  bool
+}
+
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	length, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return packet{}, errMalformed
+		}
+		mult *= 128
+	}
+	if length > maxPacket {
+		return packet{}, fmt.Errorf("mqtt: packet of %d bytes too large", length)
+	}
+	p := packet{kind: header >> 4, flags: header & 0x0f, body: make([]byte, length)}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return packet{}, err
+	}
+	return p, nil
+}
+
+func (p packet) encode() []byte {
+	b := []byte{p.kind<<4 | p.flags}
+	n := len(p.body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, p.body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// reader recorre el cuerpo de un paquete; el primer error queda en err
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.b) < 2 {
+		r.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.err = errMalformed
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := int(r.uint16())
+	if r.err != nil || len(r.b) < n {
+		r.err = errMalformed
+		return nil
+	}
+	v := r.b[:n:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+// connect es el contenido de un CONNECT
+type connect struct {
+	clientID           string
+	username, password string
+	keepAlive          uint16 // segundos
+	will               *Message
+}
+
+func (c connect) packet() packet {
+	flags := byte(0x02) // clean session
+	if c.will != nil {
+		flags |= 0x04 | c.will.QoS<<3
+		if c.will.Retain {
+			flags |= 0x20
+		}
+	}
+	if c.username != "" {
+		flags |= 0x80
+	}
+	if c.password != "" {
+		flags |= 0x40
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, c.keepAlive)
+	body = appendString(body, c.clientID)
+	if c.will != nil {
+		body = appendString(body, c.will.Topic)
+		body = appendBytes(body, c.will.Payload)
+	}
+	if c.username != "" {
+		body = appendString(body, c.username)
+	}
+	if c.password != "" {
+		body = appendString(body, c.password)
+	}
+	return packet{kind: typeConnect, body: body}
+}
+
+func parseConnect(p packet) (connect, error) {
+	r := &reader{b: p.body}
+	if proto := r.string(); r.err == nil && proto != "MQTT" {
+		return connect{}, fmt.Errorf("mqtt: unsupported protocol %q", proto)
+	}
+	if level := r.byte(); r.err == nil && level != 4 {
+		return connect{}, fmt.Errorf("mqtt: unsupported protocol level %d", level)
+	}
+	flags := r.byte()
+	c := connect{keepAlive: r.uint16(), clientID: r.string()}
+	if flags&0x04 != 0 {
+		c.will = &Message{Topic: r.string(), Payload: r.bytes(), QoS: flags >> 3 & 0x03, Retain: flags&0x20 != 0}
+	}
+	if flags&0x80 != 0 {
+		c.username = r.string()
+	}
+	if flags&0x40 != 0 {
+		c.password = r.string()
+	}
+	return c, r.err
+}
+
+func publishPacket(m Message, id uint16) packet {
+	flags := m.QoS << 1
+	if m.Retain {
+		flags |= 0x01
+	}
+	body := appendString(nil, m.Topic)
+	if m.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	return packet{kind: typePublish, flags: flags, body: append(body, m.Payload...)}
+}
+
+func parsePublish(p packet) (m Message, id uint16, err error) {
+	r := &reader{b: p.body}
+	m = Message{Topic: r.string(), QoS: p.flags >> 1 & 0x03, Retain: p.flags&0x01 != 0}
+	if m.QoS > 0 {
+		id = r.uint16()
+	}
+	if r.err != nil {
+		return Message{}, 0, r.err
+	}
+	if m.QoS > 1 {
+		return Message{}, 0, fmt.Errorf("mqtt: QoS %d not supported", m.QoS)
+	}
+	m.Payload = r.b
+	return m, id, nil
+}
+
+// idPacket arma los paquetes que solo llevan un packet id (PUBACK)
+func idPacket(kind byte, id uint16) packet {
+	return packet{kind: kind, body: binary.BigEndian.AppendUint16(nil, id)}
+}
+
+func subscribePacket(id uint16, filter string, qos byte) packet {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = appendString(body, filter)
+	return packet{kind: typeSubscribe, flags: 0x02, body: append(body, qos)}
+}
+
+// match indica si topic cumple el filtro de suscripción (con + y #)
+func match(filter, topic string) bool {
+	for {
+		f, fRest, fMore := strings.Cut(filter, "/")
+		if f == "#" {
+			return true
+		}
+		t, tRest, tMore := strings.Cut(topic, "/")
+		if f != "+" && f != t {
+			return false
+		}
+		switch {
+		case fMore && tMore:
+			filter, topic = fRest, tRest
+		case !fMore && !tMore:
+			return true
+		default:
+			return fMore && fRest == "#" // "a/#" también cubre "a"
+		}
+	}
+}
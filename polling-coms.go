@@ -2,54 +2,117 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
+	"mt-plc-control/alarms"
+	"mt-plc-control/clock"
+	"mt-plc-control/counters"
+	"mt-plc-control/history"
 	"mt-plc-control/modbusClient"
-	"os"
-	"strings"
+	"mt-plc-control/quality"
+	"mt-plc-control/runHours"
+	"mt-plc-control/uplink"
+	"mt-plc-control/wailonServer"
+	"slices"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
-type IDataIO interface {
-	OpenSocket() error
-	SendPing() error
-	CloseSocket()
-	SendData(params string) error
-	ReadCommand() (string, string, error)
-}
-
 const (
 	InitModbusFails = 6
 	InitWailonFails = 5
 )
 
+var errTooManyFailures = errors.New("too many consecutive failures")
+
 type comFailures int
 
-func comFail(f *comFailures) {
+func comFail(f *comFailures) error {
 	if f == nil {
-		return
+		return nil
 	}
 	*f = *f - 1
 	if *f <= 0 {
-		os.Exit(1)
+		return errTooManyFailures
 	}
+	return nil
+}
+
+// gateway reúne lo que usa el poll loop
+type gateway struct {
+	plcConn        *modbusClient.ModbusConn
+	uplinks        *uplink.Registry
+	addrRead       *AddrMap
+	addrWrite      *AddrMap
+	addrAnalog     *AddrMap
+	cnt            *counters.Store
+	rh             *runHours.Tracker
+	hist           *history.Store
+	quality        *quality.Tracker
+	bf             *wailonServer.Backfill // para el estado de la API local
+	alarms         *alarms.Table
+	gensetCommands bool
+	pollPeriod     time.Duration
+	uploadPeriod   time.Duration
+
+	clock clock.Clock // nil usa el reloj real
+
+	// refresh pide al poll loop un escaneo y envío inmediato; nil lo crea pollLoop
+	refresh chan struct{}
+
+	scanned func(d time.Duration) // si no es nil, recibe la duración de cada escaneo
+
+	// para pruebas: se llama al terminar cada escaneo periódico
+	afterTick func()
 }
 
-func pollLoop(ctx context.Context, plcConn *modbusClient.ModbusConn, wConn IDataIO, addrRead *AddrMap, addrWrite *AddrMap, addrAnalog *AddrMap, pollPeriod time.Duration, uploadPeriod time.Duration) {
-	_ = godotenv.Load()
-	ticker := time.NewTicker(pollPeriod)
+// pollLoop corre hasta que se cancele ctx, se agoten los reintentos con el PLC
+// o un uplink no pueda seguir
+func pollLoop(ctx context.Context, g *gateway) error {
+	plcConn := g.plcConn
+	addrRead, addrAnalog := g.addrRead, g.addrAnalog
+	cnt, rh, hist := g.cnt, g.rh, g.hist
+	qt := g.quality
+	if qt == nil {
+		qt = quality.NewTracker(nil, nil)
+	}
+
+	clk := clock.Or(g.clock)
+	ticker := clk.NewTicker(g.pollPeriod)
 	defer ticker.Stop()
+	maintainTicker := clk.NewTicker(time.Hour)
+	defer maintainTicker.Stop()
 
-	wFails := comFailures(InitWailonFails)
 	plcFails := comFailures(InitModbusFails)
+	plcOk := true
 
-	uploadedAt := time.Now()
+	uploadedAt := clk.Now()
 	readMemory := newReading(len(addrRead.logo), len(addrAnalog.logo))
 
-	sendData := func(sendNow bool) {
+	// plcStatus arma el estado del PLC: plc_comm y, si hay breaker, plc_breaker
+	sentBreaker := modbusClient.BreakerClosed
+	plcStatus := func(scanTime time.Time) []uplink.Sample {
+		comm := 0.0
+		if plcOk {
+			comm = 1
+		}
+		status := []uplink.Sample{{Name: "plc_comm", Type: uplink.Int, Time: scanTime, Tag: quality.Tag{Value: comm}}}
+		if plcConn.Breaker != nil {
+			sentBreaker = plcConn.Breaker.State()
+			breaker := uplink.Sample{Name: "plc_breaker", Type: uplink.Int, Time: scanTime, Tag: quality.Tag{Value: float64(sentBreaker)}}
+			status = append([]uplink.Sample{breaker}, status...)
+		}
+		return status
+	}
+
+	// breakerChanged indica si el breaker cambió desde el último estado subido
+	breakerChanged := func() bool {
+		return plcConn.Breaker != nil && plcConn.Breaker.State() != sentBreaker
+	}
+
+	var lastAnag []float32 // últimas lecturas analógicas buenas, para comparar cambios
+	sendData := func(sendNow bool) error {
 		//log.Print("Tick")
 		inputAddrs := make([]uint16, 0)
 		coilAddrs := make([]uint16, 0)
@@ -64,153 +127,336 @@ func pollLoop(ctx context.Context, plcConn *modbusClient.ModbusConn, wConn IData
 			}
 		}
 
-		inputVals, err := plcConn.ReadInputs(inputAddrs)
-		if err != nil {
-			log.Printf("Error reading inputs: %v", err)
-			comFail(&plcFails)
-			return
-		}
-		time.Sleep(time.Millisecond * 50)
-		coilVals, err := plcConn.ReadCoils(coilAddrs)
-		if err != nil {
-			log.Printf("Error reading coils: %v", err)
-			comFail(&plcFails)
-			return
-		}
-		time.Sleep(time.Millisecond * 50)
-		anagVals, err := plcConn.ReadAnalog(addrAnalog.addr)
-		if err != nil {
-			log.Printf("Error reading analog inputs: %v", err)
-			log.Print(addrAnalog.addr)
-			comFail(&plcFails)
-			return
-		}
-		time.Sleep(time.Millisecond * 50)
-		plcFails = InitModbusFails
-		if !sendNow && !readMemory.HaveChanged(coilVals, anagVals) &&
-			!uploadedAt.Add(uploadPeriod).Before(time.Now()) {
-			//log.Print("No change in registers")
-			if err := wConn.SendPing(); err != nil {
-				log.Printf("Error sending ping: %v", err)
-				comFail(&wFails)
-				return
+		// tras un error de comunicación no se intenta el resto del escaneo; una
+		// excepción Modbus solo afecta a su grupo
+		var commErr error
+		read := func(what string, f func() error) error {
+			if commErr != nil {
+				return commErr
+			}
+			err := f()
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error reading %s: %v", what, err)
+				if class, ok := modbusClient.ClassOf(err); !ok || class != modbusClient.ClassException {
+					commErr = err
+				}
 			}
-			wFails = InitWailonFails
-			return
+			return err
+		}
+		var inputVals, coilVals []bool
+		var anagVals []float32
+		inputErr := read("inputs", func() (err error) {
+			inputVals, err = plcConn.ReadInputsCtx(ctx, inputAddrs)
+			return err
+		})
+		coilErr := read("coils", func() (err error) {
+			coilVals, err = plcConn.ReadCoilsCtx(ctx, coilAddrs)
+			return err
+		})
+		anagErr := read("analog inputs", func() (err error) {
+			anagVals, err = plcConn.ReadAnalogCtx(ctx, addrAnalog.addr)
+			return err
+		})
+		// al cancelarse ctx el escaneo se abandona sin contarlo como falla del PLC
+		if ctx.Err() != nil {
+			return nil
 		}
-		uploadedAt = time.Now()
-		readMemory.UpdateLastValues(coilVals, anagVals)
 
+		// el PLC se da por caído si no responde; una excepción Modbus solo afecta
+		// la calidad de su grupo. Se avisa una vez por corte y cada vez que
+		// cambia el estado del breaker
+		if commErr != nil {
+			// con el breaker abierto no se llegó al PLC: no cuenta como falla
+			if !errors.Is(commErr, modbusClient.ErrBreakerOpen) {
+				if err := comFail(&plcFails); err != nil {
+					return fmt.Errorf("plc: %w, last error: %v", err, commErr)
+				}
+			}
+			if plcOk || breakerChanged() {
+				plcOk = false
+				sendNow = true
+			}
+		} else {
+			plcFails = InitModbusFails
+			if !plcOk || breakerChanged() {
+				plcOk = true
+				sendNow = true
+			}
+		}
+
+		// calidad de cada valor: lo que no se pudo leer queda con el último valor
+		// bueno (o el de reemplazo) y su calidad lo indica
+		scanTime := clk.Now()
+		if inputErr != nil {
+			inputVals = make([]bool, len(inputAddrs))
+		}
+		if coilErr != nil {
+			coilVals = make([]bool, len(coilAddrs))
+		}
 		regReadings := append(inputVals, coilVals...)
-		dataStr := ""
+		regTags := make([]quality.Tag, len(regReadings))
 		for i, v := range regReadings {
-			name := addrRead.name[i]
-			value := 0
-			if v {
-				value = 1
+			readErr := inputErr
+			if i >= len(inputVals) {
+				readErr = coilErr
 			}
-			if dataStr == "" {
-				dataStr = fmt.Sprintf("%s:1:%d", name, value)
+			var changed bool
+			if readErr != nil {
+				regTags[i], changed = qt.Failed(addrRead.name[i], errors.Is(readErr, modbusClient.ErrBreakerOpen))
+				regReadings[i] = regTags[i].Value != 0
 			} else {
-				dataStr = fmt.Sprintf("%s:1:%d,%s", name, value, dataStr)
+				value := 0.0
+				if v {
+					value = 1
+				}
+				regTags[i], changed = qt.Read(addrRead.name[i], value, scanTime)
 			}
+			sendNow = sendNow || changed
 		}
-		bigWord := uint32(0)
-		for j, val := range anagVals {
-			name := addrAnalog.name[j]
-			if addrAnalog.logo[j] == "0" {
-				v := bigWord<<16 | uint32(val)
-				bigWord = uint32(0)
-				dataStr = fmt.Sprintf("%s:1:%d,%s", name, v, dataStr)
+		coilVals = regReadings[len(inputVals):]
+
+		if anagErr != nil {
+			anagVals = make([]float32, len(addrAnalog.addr))
+			copy(anagVals, lastAnag)
+		} else {
+			lastAnag = anagVals
+		}
+		analogs := joinWords(addrAnalog, anagVals)
+		analogTags := make([]quality.Tag, len(analogs))
+		for j, a := range analogs {
+			var changed bool
+			if anagErr != nil {
+				analogTags[j], changed = qt.Failed(a.name, errors.Is(anagErr, modbusClient.ErrBreakerOpen))
+				analogs[j].value = uint32(analogTags[j].Value)
 			} else {
-				bigWord = uint32(val)
+				analogTags[j], changed = qt.Read(a.name, float64(a.value), scanTime)
+			}
+			// los contadores solo acumulan lecturas buenas
+			if analogTags[j].Quality == quality.Good {
+				cnt.Observe(a.name, a.value, a.width)
 			}
+			sendNow = sendNow || changed
 		}
-		err = wConn.SendData(dataStr)
-		if err != nil {
-			log.Printf("Error: %v", err)
+
+		g.alarms.Set("plc_comm", !plcOk, "PLC not responding", scanTime)
+		g.alarms.Set("plc_breaker", plcConn.Breaker != nil && plcConn.Breaker.State() != modbusClient.BreakerClosed,
+			"PLC circuit breaker open", scanTime)
+		for i, tag := range regTags {
+			tagAlarm(g.alarms, addrRead.name[i], tag, scanTime)
+		}
+		for j, tag := range analogTags {
+			tagAlarm(g.alarms, analogs[j].name, tag, scanTime)
 		}
 
-	}
+		for i, v := range regReadings {
+			if regTags[i].Quality.Read() {
+				rh.Observe(addrRead.name[i], v, scanTime)
+			}
+		}
+		if hist != nil {
+			points := make([]history.Point, 0, len(regReadings)+len(analogs))
+			for i, v := range regReadings {
+				if !regTags[i].Quality.Read() {
+					continue
+				}
+				value := 0.0
+				if v {
+					value = 1
+				}
+				points = append(points, history.Point{Time: scanTime, Tag: addrRead.name[i], Value: value})
+			}
+			for j, a := range analogs {
+				if !analogTags[j].Quality.Read() {
+					continue
+				}
+				points = append(points, history.Point{Time: scanTime, Tag: a.name, Value: float64(a.value)})
+			}
+			if err := hist.Append(points); err != nil {
+				log.Printf("Error saving history: %v", err)
+			}
+		}
+		batch := uplink.Batch{
+			Time:    scanTime,
+			Online:  plcOk,
+			Samples: make([]uplink.Sample, 0, len(regTags)+len(analogTags)),
+		}
+		for i, tag := range regTags {
+			batch.Samples = append(batch.Samples, uplink.Sample{Name: addrRead.name[i], Type: uplink.Bool, Time: scanTime, Tag: tag})
+		}
+		for j, tag := range analogTags {
+			batch.Samples = append(batch.Samples, uplink.Sample{Name: analogs[j].name, Type: uplink.Int, Time: scanTime, Tag: tag})
+		}
+		batch.Upload = sendNow || readMemory.HaveChanged(coilVals, anagVals) ||
+			uploadedAt.Add(g.uploadPeriod).Before(scanTime)
+		if !batch.Upload {
+			return g.uplinks.Publish(ctx, batch)
+		}
+		uploadedAt = scanTime
+		readMemory.UpdateLastValues(coilVals, anagVals)
 
-	cmdChan := make(chan string)
-	go func() {
-		for {
-			cmd, message, err := wConn.ReadCommand()
-			if err != nil {
-				log.Printf("Error: %v", err)
+		// los valores derivados (consumo, horas de marcha) solo se suben con su origen bueno
+		derived := func(name string, value int64) {
+			batch.Derived = append(batch.Derived,
+				uplink.Sample{Name: name, Type: uplink.Int, Time: scanTime, Tag: quality.Tag{Value: float64(value)}})
+		}
+		// el consumo queda pendiente hasta que Wialon confirme el envío
+		deltas := make(map[string]uint64)
+		for j, a := range analogs {
+			if analogTags[j].Quality != quality.Good {
 				continue
 			}
-			if strings.ToUpper(cmd) == "TIMEOUT" {
+			if _, delta, ok := cnt.Peek(a.name); ok {
+				derived(a.name+"_delta", int64(delta))
+				deltas[a.name] = delta
+			}
+		}
+		batch.Delivered = func() {
+			for name, delta := range deltas {
+				cnt.Commit(name, delta)
+			}
+		}
+		for _, p := range rh.Params(scanTime) {
+			if tag, ok := qt.Get(p.Tag); ok && tag.Quality != quality.Good {
 				continue
 			}
-			cmdChan <- fmt.Sprintf("%s|%s", cmd, message)
+			derived(p.Name, p.Value)
 		}
+		batch.Derived = append(batch.Derived, plcStatus(scanTime)...)
+		return g.uplinks.Publish(ctx, batch)
+	}
 
-	}()
+	scan := func(sendNow bool) error {
+		start := clk.Now()
+		err := sendData(sendNow)
+		if g.scanned != nil {
+			g.scanned(clk.Now().Sub(start))
+		}
+		return err
+	}
+
+	// los comandos se ejecutan fuera del loop: sus escrituras pasan delante de
+	// las lecturas del escaneo en curso y al terminar se pide un envío inmediato
+	if g.refresh == nil {
+		g.refresh = make(chan struct{}, 1)
+	}
+	cmdCtx := modbusClient.WithPriority(ctx, modbusClient.PriorityCommand)
+	go g.uplinks.Run(ctx, func(cmd uplink.Command) error {
+		err := g.execute(cmdCtx, clk, cmd)
+		g.afterCommand(ctx, clk)
+		return err
+	})
 
 	for {
 		select {
 		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			sendData(false)
-		case cmd := <-cmdChan:
-			cmdParts := strings.Split(cmd, "|")
-			code, message := cmdParts[0], cmdParts[1]
-			switch strings.ToUpper(code) {
-			case "TIMEOUT":
-				continue
-			case "W":
-				for line := range strings.SplitSeq(message, ";") {
-					parts := strings.Split(line, "=")
-					if len(parts) != 2 {
-						log.Printf("malformed command: %s", cmd)
-						continue
-					}
-					varName := strings.Trim(parts[0], " ")
-					set := false
-					if strings.Trim(parts[1], " \r\n") == "1" {
-						set = true
-					}
-					log.Printf("Comand %s=%t", varName, set)
-
-					notFound := true
-					for i, regName := range addrWrite.name {
-						if varName == regName {
-							notFound = false
-							if err := plcConn.WriteCoil(addrWrite.addr[i], set); err != nil {
-								log.Printf("error at %s=%t: %s", varName, set, err)
-							}
-							time.Sleep(time.Millisecond * 50)
-						}
-					}
-					if notFound {
-						log.Printf("not found variable: %s", varName)
-					}
-
-				}
-			case "GS":
-				en := os.Getenv("GENSET_COMMANDS")
-				if en != "true" {
-					continue
-				}
-				if message == "START" {
-					if err := modbusClient.GenSetON(plcConn); err != nil {
-						log.Printf("Error prendiendo gen %v", err)
-						continue
-					}
-				} else if message == "STOP" {
-					if err := modbusClient.GenSetOFF(plcConn); err != nil {
-						log.Printf("Error apagando gen %v", err)
-						continue
-					}
+			return nil
+		case <-ticker.Chan():
+			err := scan(false)
+			if g.afterTick != nil {
+				g.afterTick()
+			}
+			if err != nil {
+				return err
+			}
+		case t := <-maintainTicker.Chan():
+			if hist != nil {
+				if err := hist.Maintain(t); err != nil {
+					log.Printf("Error maintaining history: %v", err)
 				}
 			}
-			time.Sleep(time.Millisecond * 500)
-			sendData(true)
+		case <-g.refresh:
+			if err := scan(true); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+var (
+	errUnknownOutput  = errors.New("not found variable")
+	errGensetDisabled = errors.New("genset commands disabled")
+)
+
+// execute ejecuta un comando de cualquier uplink o de la API local. Las
+// escrituras y reinicios se aplican uno por uno; los errores de todos se
+// devuelven juntos.
+func (g *gateway) execute(ctx context.Context, clk clock.Clock, cmd uplink.Command) error {
+	log.Printf("Command %s", cmd)
+	errs := make([]error, 0)
+	switch cmd.Kind {
+	case uplink.Write:
+		for _, w := range cmd.Writes {
+			i := slices.Index(g.addrWrite.name, w.Tag)
+			if i < 0 {
+				errs = append(errs, fmt.Errorf("%w: %s", errUnknownOutput, w.Tag))
+				continue
+			}
+			if err := g.plcConn.WriteCoilCtx(ctx, g.addrWrite.addr[i], w.Value); err != nil {
+				errs = append(errs, fmt.Errorf("error at %s=%t: %w", w.Tag, w.Value, err))
+			}
+		}
+	case uplink.ResetRuntime:
+		for _, name := range cmd.Tags {
+			if !g.rh.Reset(name, clk.Now()) {
+				errs = append(errs, fmt.Errorf("not found runtime output: %s", name))
+				continue
+			}
+			log.Printf("Reset runtime %s", name)
+		}
+	case uplink.Genset:
+		if !g.gensetCommands {
+			return errGensetDisabled
+		}
+		if cmd.Start {
+			if err := modbusClient.GenSetON(ctx, g.plcConn); err != nil {
+				return fmt.Errorf("prendiendo gen %w", err)
+			}
+		} else if err := modbusClient.GenSetOFF(ctx, g.plcConn); err != nil {
+			return fmt.Errorf("apagando gen %w", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// afterCommand espera a que el PLC aplique un comando y pide un envío inmediato
+func (g *gateway) afterCommand(ctx context.Context, clk clock.Clock) {
+	if clk.SleepContext(ctx, time.Millisecond*500) != nil {
+		return
+	}
+	select {
+	case g.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// tagAlarm activa la alarma de un tag leído fuera de su rango
+func tagAlarm(t *alarms.Table, name string, tag quality.Tag, now time.Time) {
+	t.Set("tag_"+name, tag.Quality == quality.OutOfRange, fmt.Sprintf("%s out of range: %g", name, tag.Value), now)
+}
+
+type analogValue struct {
+	name  string
+	value uint32
+	width uint
+}
+
+// joinWords arma los valores de 32 bits: la línea con logo "1" es la palabra alta
+// y la siguiente con logo "0" la baja.
+func joinWords(addrAnalog *AddrMap, anagVals []float32) []analogValue {
+	values := make([]analogValue, 0, len(anagVals))
+	bigWord := uint32(0)
+	width := uint(16)
+	for j, val := range anagVals {
+		if addrAnalog.logo[j] == "0" {
+			values = append(values, analogValue{addrAnalog.name[j], bigWord<<16 | uint32(val), width})
+			bigWord = uint32(0)
+			width = 16
+		} else {
+			bigWord = uint32(val)
+			width = 32
 		}
 	}
+	return values
 }
 
 type Reading struct {
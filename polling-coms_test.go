@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"mt-plc-control/counters"
+	"mt-plc-control/modbusClient"
+	"mt-plc-control/modbusServer"
+	"mt-plc-control/runHours"
+	"mt-plc-control/uplink"
+	"mt-plc-control/wailonServer"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollLoop_EndToEnd(t *testing.T) {
+	sim := modbusServer.NewLogo8()
+	sim.SetBit(modbusServer.DiscreteInputs, 0, true)
+	sim.SetBit(modbusServer.Coils, modbusServer.LogoOutputs, true)
+	sim.SetWord(modbusServer.InputRegisters, 0, 321)
+	if err := sim.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	ips := wailonServer.NewIPSServer()
+	if err := ips.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer ips.Close()
+
+	plcConn, err := modbusClient.NewModbusConn(sim.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = plcConn.Close()
+	}()
+	host, port, _ := net.SplitHostPort(ips.Addr())
+	wConn := &wailonServer.WailonConnection{Imei: "1", Url: host, Port: port}
+	if err := wConn.OpenSocket(); err != nil {
+		t.Fatal(err)
+	}
+	defer wConn.CloseSocket()
+
+	addrRead := ParseAddrMap("I,i1,0\nQ,q1,8192")
+	addrWrite := ParseAddrMap("Q,q1,8192")
+	addrAnalog := ParseAddrMap("0,a1,0")
+
+	uplinks := &uplink.Registry{}
+	uplinks.Add(newWialonUplink(wConn, nil, nil, nil, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = pollLoop(ctx, &gateway{
+			plcConn:      plcConn,
+			uplinks:      uplinks,
+			addrRead:     addrRead,
+			addrWrite:    addrWrite,
+			addrAnalog:   addrAnalog,
+			cnt:          counters.NewStore("", nil),
+			rh:           runHours.NewTracker("", nil),
+			pollPeriod:   50 * time.Millisecond,
+			uploadPeriod: time.Hour,
+		})
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	p, next, ok := ips.WaitPacket("D", 0, 2*time.Second)
+	if !ok {
+		t.Fatalf("no data packet received")
+	}
+	for _, want := range []string{"i1:1:1", "q1:1:1", "a1:1:321"} {
+		if !strings.Contains(p.Body, want) {
+			t.Errorf("data packet %q should contain %s", p.Body, want)
+		}
+	}
+
+	if err := ips.SendCommand("W", "q1=0"); err != nil {
+		t.Fatal(err)
+	}
+	p, _, ok = ips.WaitPacket("D", next+1, 3*time.Second)
+	if !ok {
+		t.Fatalf("no data packet after command")
+	}
+	if sim.Bit(modbusServer.Coils, modbusServer.LogoOutputs) {
+		t.Errorf("command should have cleared Q1")
+	}
+	if !strings.Contains(p.Body, "q1:1:0") {
+		t.Errorf("data packet after command should report q1=0: %q", p.Body)
+	}
+}
@@ -0,0 +1,191 @@
+package quality
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quality es la calidad de un valor, al estilo OPC. El valor numérico es el
+// que se sube a Wialon en el param <tag>_q.
+type Quality int
+
+const (
+	Good        Quality = iota // leído en este escaneo
+	Stale                      // no se leyó (breaker abierto): último valor bueno
+	CommFailure                // la lectura falló: último valor bueno
+	OutOfRange                 // leído, pero fuera de los límites configurados
+	Substituted                // la lectura falló y se usa el valor de reemplazo configurado
+)
+
+func (q Quality) String() string {
+	switch q {
+	case Stale:
+		return "stale"
+	case CommFailure:
+		return "comm-failure"
+	case OutOfRange:
+		return "out-of-range"
+	case Substituted:
+		return "substituted"
+	}
+	return "good"
+}
+
+// Read indica si el valor viene de una lectura del PLC en este escaneo
+func (q Quality) Read() bool {
+	return q == Good || q == OutOfRange
+}
+
+// Limits es el rango válido de un tag
+type Limits struct {
+	Min, Max float64
+}
+
+// Tag es el valor de un tag con su calidad
+type Tag struct {
+	Value    float64
+	Quality  Quality
+	LastGood time.Time // última lectura con calidad Good
+	ReadAt   time.Time // última lectura del PLC, aunque esté fuera de rango
+}
+
+// Params arma los params de Wialon que acompañan al tag: <name>_q siempre y,
+// si el valor no es bueno, <name>_ts con la hora (unix) de la última lectura buena.
+func (t Tag) Params(name string) string {
+	params := fmt.Sprintf("%s_q:1:%d", name, t.Quality)
+	if t.Quality != Good && !t.LastGood.IsZero() {
+		params = fmt.Sprintf("%s,%s_ts:1:%d", params, name, t.LastGood.Unix())
+	}
+	return params
+}
+
+type Tracker struct {
+	mu          sync.Mutex
+	limits      map[string]Limits
+	substitutes map[string]float64
+	tags        map[string]*Tag
+}
+
+func NewTracker(limits map[string]Limits, substitutes map[string]float64) *Tracker {
+	return &Tracker{limits: limits, substitutes: substitutes, tags: make(map[string]*Tag)}
+}
+
+// tag devuelve el tag y su calidad previa; -1 si es nuevo, para que cuente como cambio
+func (t *Tracker) tag(name string) (*Tag, Quality) {
+	tag, ok := t.tags[name]
+	if !ok {
+		tag = &Tag{}
+		t.tags[name] = tag
+		return tag, -1
+	}
+	return tag, tag.Quality
+}
+
+// Read registra una lectura; changed indica si cambió la calidad
+func (t *Tracker) Read(name string, value float64, now time.Time) (tag Tag, changed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tg, prev := t.tag(name)
+	tg.Value = value
+	tg.Quality = Good
+	tg.ReadAt = now
+	if l, ok := t.limits[name]; ok && (value < l.Min || value > l.Max) {
+		tg.Quality = OutOfRange
+	} else {
+		tg.LastGood = now
+	}
+	return *tg, tg.Quality != prev
+}
+
+// Failed registra que el tag no se pudo leer; skipped indica que la lectura ni
+// se intentó. Queda el último valor bueno, o el de reemplazo si hay uno.
+func (t *Tracker) Failed(name string, skipped bool) (tag Tag, changed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tg, prev := t.tag(name)
+	switch v, ok := t.substitutes[name]; {
+	case ok:
+		tg.Value = v
+		tg.Quality = Substituted
+	case skipped:
+		tg.Quality = Stale
+	default:
+		tg.Quality = CommFailure
+	}
+	return *tg, tg.Quality != prev
+}
+
+func (t *Tracker) Get(name string) (Tag, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tg, ok := t.tags[name]
+	if !ok {
+		return Tag{}, false
+	}
+	return *tg, true
+}
+
+// Tags devuelve una copia de todos los tags registrados
+func (t *Tracker) Tags() map[string]Tag {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tags := make(map[string]Tag, len(t.tags))
+	for name, tg := range t.tags {
+		tags[name] = *tg
+	}
+	return tags
+}
+
+// ParseLimits lee QUALITY_LIMITS: líneas o campos separados por coma "tag:min:max"
+func ParseLimits(list string) (map[string]Limits, error) {
+	limits := make(map[string]Limits)
+	for _, field := range splitFields(list) {
+		parts := strings.Split(field, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("quality limit %q: want tag:min:max", field)
+		}
+		lo, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("quality limit %q: %w", field, err)
+		}
+		hi, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("quality limit %q: %w", field, err)
+		}
+		limits[strings.TrimSpace(parts[0])] = Limits{lo, hi}
+	}
+	return limits, nil
+}
+
+// ParseSubstitutes lee QUALITY_SUBSTITUTES: "tag=valor" separados por coma o salto de línea
+func ParseSubstitutes(list string) (map[string]float64, error) {
+	substitutes := make(map[string]float64)
+	for _, field := range splitFields(list) {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("quality substitute %q: want tag=value", field)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("quality substitute %q: %w", field, err)
+		}
+		substitutes[strings.TrimSpace(name)] = v
+	}
+	return substitutes, nil
+}
+
+func splitFields(list string) []string {
+	fields := make([]string, 0)
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,70 @@
+package quality
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracker_Transitions(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	tr := NewTracker(map[string]Limits{"nivel": {Min: 0, Max: 100}}, map[string]float64{"caudal": -1})
+
+	if tag, changed := tr.Failed("nivel", false); tag.Quality != CommFailure || !changed {
+		t.Errorf("never read: got %v changed=%t", tag.Quality, changed)
+	}
+	if tag, changed := tr.Read("nivel", 40, t0); tag.Quality != Good || !changed || !tag.LastGood.Equal(t0) {
+		t.Errorf("good read: got %+v changed=%t", tag, changed)
+	}
+	if _, changed := tr.Read("nivel", 41, t0.Add(time.Minute)); changed {
+		t.Errorf("a second good read is not a quality change")
+	}
+	tag, _ := tr.Read("nivel", 140, t0.Add(2*time.Minute))
+	if tag.Quality != OutOfRange || tag.Value != 140 || !tag.LastGood.Equal(t0.Add(time.Minute)) {
+		t.Errorf("out of range: got %+v", tag)
+	}
+	tag, _ = tr.Failed("nivel", true)
+	if tag.Quality != Stale || tag.Value != 140 {
+		t.Errorf("skipped read: got %+v", tag)
+	}
+
+	tr.Read("caudal", 12, t0)
+	if tag, _ := tr.Failed("caudal", false); tag.Quality != Substituted || tag.Value != -1 {
+		t.Errorf("substitute: got %+v", tag)
+	}
+}
+
+func TestTag_Params(t *testing.T) {
+	t0 := time.Unix(1772359230, 0)
+	cases := map[string]struct {
+		tag  Tag
+		want string
+	}{
+		"good":         {Tag{Quality: Good, LastGood: t0}, "q1_q:1:0"},
+		"comm failure": {Tag{Quality: CommFailure, LastGood: t0}, "q1_q:1:2,q1_ts:1:1772359230"},
+		"never read":   {Tag{Quality: CommFailure}, "q1_q:1:2"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.tag.Params("q1"); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	limits, err := ParseLimits("nivel:0:100\npresion:-1:10.5")
+	if err != nil || limits["presion"] != (Limits{-1, 10.5}) || len(limits) != 2 {
+		t.Errorf("limits: got %v %v", limits, err)
+	}
+	if _, err := ParseLimits("nivel:0"); err == nil {
+		t.Errorf("a limit without max should fail")
+	}
+	subs, err := ParseSubstitutes("caudal=0, nivel = -1")
+	if err != nil || subs["nivel"] != -1 || len(subs) != 2 {
+		t.Errorf("substitutes: got %v %v", subs, err)
+	}
+	if _, err := ParseSubstitutes("caudal"); err == nil {
+		t.Errorf("a substitute without value should fail")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mt-plc-control/modbusClient"
+	"mt-plc-control/modbusServer"
+	"net"
+)
+
+// rtuGateway atiende Modbus TCP y reenvía cada petición al bus RTU, al esclavo
+// del unit ID del MBAP. Las peticiones esperan en la cola del bus junto con
+// las del polling, así que nunca se cruzan dos tramas.
+type rtuGateway struct {
+	ctx    context.Context
+	bus    *modbusClient.ModbusConn
+	server *modbusServer.Server
+}
+
+// startRTUGateway escucha en addr hasta que se cancele ctx
+func startRTUGateway(ctx context.Context, addr string, bus *modbusClient.ModbusConn) (*rtuGateway, error) {
+	g := &rtuGateway{ctx: ctx, bus: bus, server: modbusServer.NewServer()}
+	g.server.Forward = g.forward
+	if err := g.server.Start(addr); err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		g.server.Close()
+	}()
+	return g, nil
+}
+
+func (g *rtuGateway) Addr() string {
+	return g.server.Addr()
+}
+
+// forward responde con lo que contestó el esclavo o, si no se le pudo
+// preguntar, con la excepción de gateway que corresponda
+func (g *rtuGateway) forward(unit byte, pdu []byte) []byte {
+	// 0 es broadcast, sin respuesta que devolver; 248-255 están reservados
+	if unit == 0 || unit > 247 {
+		return []byte{pdu[0] | 0x80, modbusServer.GatewayPathUnavailable}
+	}
+	res, err := g.bus.Transact(g.ctx, unit, pdu)
+	if err == nil {
+		return res
+	}
+	log.Printf("rtu gateway unit %d: %v", unit, err)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return []byte{pdu[0] | 0x80, modbusServer.GatewayTargetFailed}
+	}
+	return []byte{pdu[0] | 0x80, modbusServer.GatewayPathUnavailable}
+}
@@ -0,0 +1,212 @@
+package runHours
+
+import (
+	"encoding/gob"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxGap es el máximo intervalo entre lecturas que se cuenta como funcionamiento;
+// huecos mayores (reinicio del gateway, PLC caído) no se acreditan.
+const MaxGap = 5 * time.Minute
+
+const saveEvery = time.Minute
+
+// Output acumula los datos de mantenimiento de una salida (bomba, grupo)
+type Output struct {
+	RunTotal   time.Duration
+	Starts     int
+	LongestRun time.Duration
+	CurrentRun time.Duration // acreditado desde LastStart, sin los huecos mayores a MaxGap
+	LastStart  time.Time
+	Running    bool
+	LastSeen   time.Time
+}
+
+type Tracker struct {
+	mu       sync.Mutex
+	names    []string
+	outputs  map[string]*Output
+	diskPath string
+	savedAt  time.Time
+}
+
+func NewTracker(diskPath string, names []string) *Tracker {
+	t := &Tracker{
+		diskPath: diskPath,
+		names:    names,
+		outputs:  make(map[string]*Output),
+	}
+	t.LoadCache()
+	outputs := make(map[string]*Output, len(names))
+	for _, n := range names {
+		if o, ok := t.outputs[n]; ok {
+			outputs[n] = o
+		} else {
+			outputs[n] = &Output{}
+		}
+	}
+	t.outputs = outputs
+	return t
+}
+
+// ParseNames lee la lista RUNTIME_TAGS (separada por coma o salto de línea)
+func ParseNames(list string) []string {
+	names := make([]string, 0)
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			names = append(names, field)
+		}
+	}
+	return names
+}
+
+// SaveCache escribe a un archivo temporal y lo renombra para no dejar el archivo
+// a medias si se corta la energía de la Pi.
+func (t *Tracker) SaveCache() {
+	if t.diskPath == "" {
+		return
+	}
+	tmp := t.diskPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	if err := gob.NewEncoder(f).Encode(t.outputs); err != nil {
+		_ = f.Close()
+		return
+	}
+	_ = f.Sync()
+	if err := f.Close(); err != nil {
+		return
+	}
+	_ = os.Rename(tmp, t.diskPath)
+}
+
+func (t *Tracker) LoadCache() {
+	f, err := os.Open(t.diskPath)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var data map[string]*Output
+	if err := gob.NewDecoder(f).Decode(&data); err != nil || data == nil {
+		return
+	}
+	t.outputs = data
+}
+
+func (t *Tracker) Tracks(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.outputs[name]
+	return ok
+}
+
+// Observe registra el estado de la salida leído en `now`
+func (t *Tracker) Observe(name string, on bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	o, ok := t.outputs[name]
+	if !ok {
+		return
+	}
+	changed := false
+	if o.Running && !o.LastSeen.IsZero() {
+		if gap := now.Sub(o.LastSeen); gap > 0 && gap <= MaxGap {
+			o.RunTotal += gap
+			o.CurrentRun += gap
+			o.LongestRun = max(o.LongestRun, o.CurrentRun)
+		}
+	}
+	switch {
+	case on && !o.Running:
+		// al arrancar el gateway no se sabe si la salida acaba de encenderse
+		if !o.LastSeen.IsZero() {
+			o.Starts++
+		}
+		o.LastStart = now
+		o.CurrentRun = 0
+		o.Running = true
+		changed = true
+	case !on && o.Running:
+		o.Running = false
+		changed = true
+	}
+	o.LastSeen = now
+
+	if changed || now.Sub(t.savedAt) >= saveEvery {
+		t.SaveCache()
+		t.savedAt = now
+	}
+}
+
+// Reset pone a cero los datos de mantenimiento de las salidas indicadas ("ALL" para todas)
+func (t *Tracker) Reset(name string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	found := false
+	for _, n := range t.names {
+		if !strings.EqualFold(name, "ALL") && n != name {
+			continue
+		}
+		o := t.outputs[n]
+		found = true
+		*o = Output{Running: o.Running, LastSeen: o.LastSeen}
+		if o.Running {
+			o.LastStart = now
+		}
+	}
+	if found {
+		t.SaveCache()
+		t.savedAt = now
+	}
+	return found
+}
+
+type Param struct {
+	Name  string
+	Value int64
+	Tag   string // salida de la que se deriva
+}
+
+// Params devuelve los parámetros a subir: segundos acumulados, arranques,
+// marcha más larga y segundos desde el último arranque.
+func (t *Tracker) Params(now time.Time) []Param {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	params := make([]Param, 0, 4*len(t.names))
+	for _, n := range t.names {
+		o := t.outputs[n]
+		params = append(params,
+			Param{n + "_run_s", int64(o.RunTotal / time.Second), n},
+			Param{n + "_starts", int64(o.Starts), n},
+			Param{n + "_max_run_s", int64(o.LongestRun / time.Second), n},
+		)
+		if !o.LastStart.IsZero() {
+			params = append(params, Param{n + "_since_start_s", int64(now.Sub(o.LastStart) / time.Second), n})
+		}
+	}
+	return params
+}
+
+func (t *Tracker) Get(name string) (Output, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o, ok := t.outputs[name]
+	if !ok {
+		return Output{}, false
+	}
+	return *o, true
+}
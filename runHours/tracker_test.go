@@ -0,0 +1,125 @@
+package runHours
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+
+func TestObserve_RunTimeAndStarts(t *testing.T) {
+	tr := NewTracker("", []string{"q1"})
+
+	tr.Observe("q1", false, t0)
+	tr.Observe("q1", true, t0.Add(30*time.Second))
+	tr.Observe("q1", true, t0.Add(60*time.Second))
+	tr.Observe("q1", true, t0.Add(90*time.Second))
+	tr.Observe("q1", false, t0.Add(120*time.Second))
+	tr.Observe("q1", true, t0.Add(150*time.Second))
+	tr.Observe("q1", true, t0.Add(180*time.Second))
+
+	o, _ := tr.Get("q1")
+	if o.Starts != 2 {
+		t.Errorf("starts: got %d want 2", o.Starts)
+	}
+	if o.RunTotal != 120*time.Second {
+		t.Errorf("run total: got %v want 2m", o.RunTotal)
+	}
+	if o.LongestRun != 90*time.Second {
+		t.Errorf("longest run: got %v want 1m30s", o.LongestRun)
+	}
+	if !o.LastStart.Equal(t0.Add(150 * time.Second)) {
+		t.Errorf("last start: got %v", o.LastStart)
+	}
+}
+
+func TestObserve_FirstReadingIsNotAStart(t *testing.T) {
+	tr := NewTracker("", []string{"q1"})
+	tr.Observe("q1", true, t0)
+	if o, _ := tr.Get("q1"); o.Starts != 0 || !o.Running {
+		t.Fatalf("got starts=%d running=%t, want 0 and true", o.Starts, o.Running)
+	}
+}
+
+func TestObserve_GapNotCredited(t *testing.T) {
+	tr := NewTracker("", []string{"q1"})
+	tr.Observe("q1", true, t0)
+	tr.Observe("q1", true, t0.Add(MaxGap+time.Second))
+	if o, _ := tr.Get("q1"); o.RunTotal != 0 {
+		t.Fatalf("gap longer than MaxGap should not count, got %v", o.RunTotal)
+	}
+}
+
+func TestObserve_GapNotInLongestRun(t *testing.T) {
+	tr := NewTracker("", []string{"q1"})
+	tr.Observe("q1", false, t0)
+	tr.Observe("q1", true, t0.Add(time.Minute))
+	tr.Observe("q1", true, t0.Add(2*time.Minute))
+	// corte de comunicación con la salida encendida
+	tr.Observe("q1", true, t0.Add(time.Hour))
+	tr.Observe("q1", true, t0.Add(time.Hour+time.Minute))
+
+	o, _ := tr.Get("q1")
+	if o.RunTotal != 2*time.Minute || o.LongestRun != o.RunTotal {
+		t.Fatalf("longest run should skip the gap like the run total: run %v, longest %v", o.RunTotal, o.LongestRun)
+	}
+}
+
+func TestResetAndPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runtime.gob")
+
+	tr := NewTracker(path, []string{"q1", "q2"})
+	tr.Observe("q1", false, t0)
+	tr.Observe("q1", true, t0.Add(time.Minute))
+	tr.Observe("q1", true, t0.Add(2*time.Minute))
+	tr.Observe("q2", false, t0)
+	tr.Observe("q2", true, t0.Add(time.Minute))
+
+	tr2 := NewTracker(path, []string{"q1", "q2"})
+	if o, _ := tr2.Get("q1"); o.Starts != 1 || o.RunTotal != time.Minute {
+		t.Fatalf("not persisted: starts=%d run=%v", o.Starts, o.RunTotal)
+	}
+
+	if !tr2.Reset("q1", t0.Add(3*time.Minute)) {
+		t.Fatalf("reset q1 should succeed")
+	}
+	if tr2.Reset("q9", t0) {
+		t.Fatalf("reset of unknown output should fail")
+	}
+	if o, _ := tr2.Get("q1"); o.Starts != 0 || o.RunTotal != 0 || !o.Running {
+		t.Fatalf("q1 not reset: %+v", o)
+	}
+	if o, _ := tr2.Get("q2"); o.Starts != 1 {
+		t.Fatalf("q2 should keep its starts, got %d", o.Starts)
+	}
+
+	tr2.Reset("all", t0.Add(3*time.Minute))
+	tr3 := NewTracker(path, []string{"q1", "q2"})
+	if o, _ := tr3.Get("q2"); o.Starts != 0 {
+		t.Fatalf("reset ALL not persisted, q2 starts=%d", o.Starts)
+	}
+}
+
+func TestParams(t *testing.T) {
+	tr := NewTracker("", []string{"q1"})
+	tr.Observe("q1", false, t0)
+	tr.Observe("q1", true, t0.Add(time.Minute))
+	tr.Observe("q1", true, t0.Add(2*time.Minute))
+
+	got := make(map[string]int64)
+	for _, p := range tr.Params(t0.Add(3 * time.Minute)) {
+		got[p.Name] = p.Value
+	}
+	want := map[string]int64{
+		"q1_run_s":         60,
+		"q1_starts":        1,
+		"q1_max_run_s":     60,
+		"q1_since_start_s": 120,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %d want %d", k, got[k], v)
+		}
+	}
+}
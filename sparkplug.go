@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mt-plc-control/clock"
+	"mt-plc-control/mqttClient"
+	"mt-plc-control/quality"
+	"mt-plc-control/sparkplug"
+	"mt-plc-control/uplink"
+	"time"
+)
+
+// sparkplugQuality es la propiedad Quality de cada métrica, con los códigos de OPC
+var sparkplugQuality = map[quality.Quality]int32{
+	quality.Good:        192,
+	quality.Stale:       500,
+	quality.CommFailure: 24,
+	quality.OutOfRange:  84,
+	quality.Substituted: 216,
+}
+
+type sparkplugTag struct {
+	name  string
+	alias uint64
+	kind  sparkplug.DataType
+}
+
+// sparkplugNode publica el gateway como edge node de Sparkplug B y el PLC
+// como su device. Todo lo publica la goroutine de run, que lleva el seq;
+// el handler de la suscripción solo encola.
+type sparkplugNode struct {
+	client              *mqttClient.Client
+	group, node, device string
+	tags                []sparkplugTag
+	aliases             map[uint64]string
+
+	latest   chan sparkplugScan
+	commands chan mqttClient.Message
+	rebirth  chan struct{}
+	clock    clock.Clock // nil usa el reloj real
+
+	// estado de la goroutine de run
+	bdSeq     uint64 // el de la conexión actual, que está en su NDEATH
+	nextBdSeq uint64
+	seq       uint64
+	needBirth bool                        // conexión nueva: faltan los NBIRTH/DBIRTH
+	born      bool                        // DBIRTH publicado y el PLC respondiendo
+	current   *sparkplugScan              // último escaneo recibido
+	sent      map[string]sparkplug.Metric // últimos valores publicados, para DDATA por excepción
+}
+
+type sparkplugScan struct {
+	time  time.Time
+	tags  map[string]quality.Tag
+	plcOk bool
+}
+
+func (n *sparkplugNode) Name() string {
+	return "sparkplug"
+}
+
+// newSparkplugNode arma el nodo; tags son los tags del PLC con su tipo, en el
+// orden en que reciben los alias
+func newSparkplugNode(opts mqttClient.Options, group, node, device string, tags []sparkplugTag, clk clock.Clock) *sparkplugNode {
+	n := &sparkplugNode{
+		clock:    clk,
+		group:    group,
+		node:     node,
+		device:   device,
+		tags:     tags,
+		aliases:  make(map[uint64]string, len(tags)),
+		latest:   make(chan sparkplugScan, 1),
+		commands: make(chan mqttClient.Message, 8),
+		rebirth:  make(chan struct{}, 1),
+	}
+	for i := range n.tags {
+		n.tags[i].alias = uint64(i + 1)
+		n.aliases[n.tags[i].alias] = n.tags[i].name
+	}
+	opts.Will = n.death(0)
+	opts.OnConnect = n.connected
+	n.client = mqttClient.NewClient(opts)
+	return n
+}
+
+// sparkplugTags arma la lista de tags del PLC: las entradas y salidas son
+// Boolean y los analógicos (ya unidas las palabras de 32 bits) UInt32
+func sparkplugTags(addrRead, addrAnalog *AddrMap) []sparkplugTag {
+	tags := make([]sparkplugTag, 0, len(addrRead.name)+len(addrAnalog.name))
+	seen := make(map[string]bool)
+	add := func(name string, kind sparkplug.DataType) {
+		if !seen[name] {
+			seen[name] = true
+			tags = append(tags, sparkplugTag{name: name, kind: kind})
+		}
+	}
+	for _, name := range addrRead.name {
+		add(name, sparkplug.Boolean)
+	}
+	for j, name := range addrAnalog.name {
+		if addrAnalog.logo[j] == "0" {
+			add(name, sparkplug.UInt32)
+		}
+	}
+	return tags
+}
+
+// death es el NDEATH con bdSeq, que queda como will de la conexión
+func (n *sparkplugNode) death(bdSeq uint64) *mqttClient.Message {
+	payload, _ := sparkplug.Payload{
+		Seq:     -1,
+		Metrics: []sparkplug.Metric{{Name: sparkplug.BdSeq, Type: sparkplug.UInt64, Value: bdSeq}},
+	}.Encode()
+	return &mqttClient.Message{Topic: sparkplug.Topic(n.group, sparkplug.NDeath, n.node, ""), Payload: payload, QoS: 1}
+}
+
+// connected corre tras cada conexión nueva, dentro de una llamada de run al
+// cliente: la próxima conexión llevará el bdSeq siguiente
+func (n *sparkplugNode) connected() {
+	n.bdSeq = n.nextBdSeq
+	n.nextBdSeq++
+	n.client.SetWill(n.death(n.nextBdSeq))
+	n.needBirth = true
+}
+
+// Publish deja los envíos para la goroutine de Run; DDATA solo lleva cambios,
+// así que basta con el último
+func (n *sparkplugNode) Publish(_ context.Context, b uplink.Batch) error {
+	if !b.Upload {
+		return nil
+	}
+	scan := sparkplugScan{time: b.Time, tags: make(map[string]quality.Tag, len(b.Samples)), plcOk: b.Online}
+	for _, s := range b.Samples {
+		scan.tags[s.Name] = s.Tag
+	}
+	select {
+	case <-n.latest:
+	default:
+	}
+	select {
+	case n.latest <- scan:
+	default:
+	}
+	return nil
+}
+
+func (n *sparkplugNode) Run(ctx context.Context, exec func(uplink.Command) error) {
+	defer n.client.Close()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-n.commands:
+				n.command(m, exec)
+			}
+		}
+	}()
+
+	enqueue := func(m mqttClient.Message) {
+		select {
+		case n.commands <- m:
+		default:
+			log.Printf("sparkplug: command queue full, dropping %s", m.Topic)
+		}
+	}
+	for _, filter := range []string{
+		sparkplug.Topic(n.group, sparkplug.NCmd, n.node, ""),
+		sparkplug.Topic(n.group, sparkplug.DCmd, n.node, "+"),
+	} {
+		if err := n.client.Subscribe(ctx, filter, 1, enqueue); err != nil {
+			log.Printf("sparkplug: %v", err)
+		}
+	}
+	n.check(ctx, nil)
+
+	retry := clock.Or(n.clock).NewTicker(mqttRetry)
+	defer retry.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-retry.Chan():
+			if !n.client.Connected() {
+				n.check(ctx, n.client.Connect(ctx))
+			}
+		case <-n.rebirth:
+			n.needBirth = true
+			n.check(ctx, nil)
+		case scan := <-n.latest:
+			n.current = &scan
+			n.check(ctx, n.update(ctx, scan))
+		}
+	}
+}
+
+// check publica los nacimientos pendientes y registra err
+func (n *sparkplugNode) check(ctx context.Context, err error) {
+	if err == nil && n.needBirth {
+		err = n.births(ctx)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("sparkplug: %v", err)
+	}
+}
+
+// births publica NBIRTH y, si el PLC responde, DBIRTH con todos los tags
+func (n *sparkplugNode) births(ctx context.Context) error {
+	n.needBirth, n.born = false, false
+	n.seq = 0
+	now := clock.Or(n.clock).Now()
+	if n.current != nil {
+		now = n.current.time
+	}
+	err := n.send(ctx, sparkplug.NBirth, "", sparkplug.Payload{Timestamp: now, Metrics: []sparkplug.Metric{
+		{Name: sparkplug.BdSeq, Timestamp: now, Type: sparkplug.UInt64, Value: n.bdSeq},
+		{Name: sparkplug.Rebirth, Timestamp: now, Type: sparkplug.Boolean, Value: false},
+	}})
+	if err != nil || n.current == nil || !n.current.plcOk {
+		return err
+	}
+	return n.deviceBirth(ctx, *n.current)
+}
+
+func (n *sparkplugNode) deviceBirth(ctx context.Context, scan sparkplugScan) error {
+	n.sent = make(map[string]sparkplug.Metric, len(n.tags))
+	metrics := make([]sparkplug.Metric, 0, len(n.tags))
+	for _, t := range n.tags {
+		m, ok := n.metric(t, scan)
+		if !ok {
+			continue
+		}
+		n.sent[t.name] = m
+		m.Name = t.name
+		metrics = append(metrics, m)
+	}
+	if err := n.send(ctx, sparkplug.DBirth, n.device, sparkplug.Payload{Timestamp: scan.time, Metrics: metrics}); err != nil {
+		return err
+	}
+	n.born = true
+	return nil
+}
+
+// update publica un escaneo: DDEATH si el PLC dejó de responder, DBIRTH si
+// volvió y si no DDATA con los tags que cambiaron
+func (n *sparkplugNode) update(ctx context.Context, scan sparkplugScan) error {
+	if !n.client.Connected() {
+		if err := n.client.Connect(ctx); err != nil {
+			return err
+		}
+	}
+	switch {
+	case n.needBirth:
+		return nil // check publica los nacimientos con este escaneo
+	case !scan.plcOk:
+		if !n.born {
+			return nil
+		}
+		n.born = false
+		return n.send(ctx, sparkplug.DDeath, n.device, sparkplug.Payload{Timestamp: scan.time})
+	case !n.born:
+		return n.deviceBirth(ctx, scan)
+	}
+	metrics := make([]sparkplug.Metric, 0)
+	for _, t := range n.tags {
+		m, ok := n.metric(t, scan)
+		if !ok {
+			continue
+		}
+		if last, ok := n.sent[t.name]; ok && last.Value == m.Value &&
+			last.Properties["Quality"] == m.Properties["Quality"] {
+			continue
+		}
+		n.sent[t.name] = m
+		metrics = append(metrics, m)
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return n.send(ctx, sparkplug.DData, n.device, sparkplug.Payload{Timestamp: scan.time, Metrics: metrics})
+}
+
+// metric arma la métrica de un tag, solo con alias
+func (n *sparkplugNode) metric(t sparkplugTag, scan sparkplugScan) (sparkplug.Metric, bool) {
+	tag, ok := scan.tags[t.name]
+	if !ok {
+		return sparkplug.Metric{}, false
+	}
+	m := sparkplug.Metric{
+		Alias:      t.alias,
+		Timestamp:  scan.time,
+		Type:       t.kind,
+		Properties: map[string]any{"Quality": sparkplugQuality[tag.Quality]},
+	}
+	if t.kind == sparkplug.Boolean {
+		m.Value = tag.Value != 0
+	} else {
+		m.Value = uint32(tag.Value)
+	}
+	return m, true
+}
+
+// send publica con el seq siguiente (QoS 0, como pide la especificación)
+func (n *sparkplugNode) send(ctx context.Context, kind, device string, p sparkplug.Payload) error {
+	p.Seq = int64(n.seq)
+	n.seq = (n.seq + 1) % 256
+	payload, err := p.Encode()
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", kind, err)
+	}
+	return n.client.Publish(ctx, mqttClient.Message{Topic: sparkplug.Topic(n.group, kind, n.node, device), Payload: payload})
+}
+
+// command atiende un NCMD (Rebirth) o un DCMD: las métricas Boolean del
+// device se escriben en las salidas
+func (n *sparkplugNode) command(m mqttClient.Message, exec func(uplink.Command) error) {
+	_, kind, _, device, ok := sparkplug.ParseTopic(m.Topic)
+	if !ok {
+		return
+	}
+	p, err := sparkplug.Decode(m.Payload)
+	if err != nil {
+		log.Printf("sparkplug %s: %v", kind, err)
+		return
+	}
+	switch kind {
+	case sparkplug.NCmd:
+		for _, metric := range p.Metrics {
+			if metric.Name == sparkplug.Rebirth && metric.Value == true {
+				select {
+				case n.rebirth <- struct{}{}:
+				default:
+				}
+			}
+		}
+	case sparkplug.DCmd:
+		if device != n.device {
+			log.Printf("sparkplug: DCMD for unknown device %s", device)
+			return
+		}
+		cmd := uplink.Command{Kind: uplink.Write}
+		for _, metric := range p.Metrics {
+			name := metric.Name
+			if name == "" {
+				name = n.aliases[metric.Alias]
+			}
+			set, ok := metric.Value.(bool)
+			if name == "" || !ok {
+				log.Printf("sparkplug: DCMD ignored for metric %q (alias %d)", name, metric.Alias)
+				continue
+			}
+			cmd.Writes = append(cmd.Writes, uplink.OutputWrite{Tag: name, Value: set})
+		}
+		if len(cmd.Writes) == 0 {
+			return
+		}
+		if err := exec(cmd); err != nil {
+			log.Printf("sparkplug DCMD: %v", err)
+		}
+	}
+}
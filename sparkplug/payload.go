@@ -0,0 +1,343 @@
+// Package sparkplug arma y lee los payloads de Sparkplug B (protobuf, a mano:
+// solo los campos que usa el gateway) y sus topics.
+package sparkplug
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Namespace es el primer nivel de los topics de Sparkplug B
+const Namespace = "spBv1.0"
+
+// Tipos de mensaje
+const (
+	NBirth = "NBIRTH"
+	NDeath = "NDEATH"
+	NData  = "NDATA"
+	NCmd   = "NCMD"
+	DBirth = "DBIRTH"
+	DDeath = "DDEATH"
+	DData  = "DDATA"
+	DCmd   = "DCMD"
+)
+
+// Métricas de nodo que define la especificación
+const (
+	BdSeq   = "bdSeq"
+	Rebirth = "Node Control/Rebirth"
+)
+
+// DataType es el tipo de una métrica
+type DataType uint32
+
+const (
+	Int32   DataType = 3
+	Int64   DataType = 4
+	UInt32  DataType = 7
+	UInt64  DataType = 8
+	Double  DataType = 10
+	Boolean DataType = 11
+	String  DataType = 12
+)
+
+var ErrMalformed = errors.New("sparkplug: malformed payload")
+
+// Metric es una métrica del payload. Value es bool, uint32, uint64, int32,
+// int64, float64 o string según Type; nil con IsNull.
+type Metric struct {
+	Name       string // vacío si solo se identifica por Alias
+	Alias      uint64 // 0: sin alias
+	Timestamp  time.Time
+	Type       DataType
+	Value      any
+	IsNull     bool
+	Properties map[string]any // propiedades simples (int32, int64, string, bool)
+}
+
+// Payload es el mensaje de Sparkplug B
+type Payload struct {
+	Timestamp time.Time
+	Seq       int64 // -1: sin seq (NDEATH)
+	Metrics   []Metric
+}
+
+// Topic arma spBv1.0/<group>/<kind>/<node>[/<device>]
+func Topic(group, kind, node, device string) string {
+	topic := strings.Join([]string{Namespace, group, kind, node}, "/")
+	if device != "" {
+		topic += "/" + device
+	}
+	return topic
+}
+
+// ParseTopic separa un topic de Sparkplug B; device queda vacío en los de nodo
+func ParseTopic(topic string) (group, kind, node, device string, ok bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || len(parts) > 5 || parts[0] != Namespace {
+		return "", "", "", "", false
+	}
+	if len(parts) == 5 {
+		device = parts[4]
+	}
+	return parts[1], parts[2], parts[3], device, true
+}
+
+// Tipos de campo de protobuf
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendTag(b []byte, field int, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendVarint(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendBool(b []byte, field int, v bool) []byte {
+	n := uint64(0)
+	if v {
+		n = 1
+	}
+	return appendVarint(b, field, n)
+}
+
+func millis(t time.Time) uint64 {
+	return uint64(t.UnixMilli())
+}
+
+// Encode serializa el payload
+func (p Payload) Encode() ([]byte, error) {
+	var b []byte
+	if !p.Timestamp.IsZero() {
+		b = appendVarint(b, 1, millis(p.Timestamp))
+	}
+	for _, m := range p.Metrics {
+		mb, err := m.encode()
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, 2, mb)
+	}
+	if p.Seq >= 0 {
+		b = appendVarint(b, 3, uint64(p.Seq))
+	}
+	return b, nil
+}
+
+func (m Metric) encode() ([]byte, error) {
+	var b []byte
+	if m.Name != "" {
+		b = appendBytes(b, 1, []byte(m.Name))
+	}
+	if m.Alias != 0 {
+		b = appendVarint(b, 2, m.Alias)
+	}
+	if !m.Timestamp.IsZero() {
+		b = appendVarint(b, 3, millis(m.Timestamp))
+	}
+	b = appendVarint(b, 4, uint64(m.Type))
+	if m.IsNull {
+		b = appendBool(b, 7, true)
+	}
+	if len(m.Properties) > 0 {
+		var keys, values []byte
+		for _, k := range slices.Sorted(maps.Keys(m.Properties)) {
+			v, err := encodeValue(nil, 3, m.Properties[k])
+			if err != nil {
+				return nil, fmt.Errorf("property %s: %w", k, err)
+			}
+			keys = appendBytes(keys, 1, []byte(k))
+			values = appendBytes(values, 2, v)
+		}
+		b = appendBytes(b, 9, append(keys, values...))
+	}
+	if m.IsNull {
+		return b, nil
+	}
+	if m.Value == nil {
+		return nil, fmt.Errorf("metric %s without value", m.Name)
+	}
+	b, err := encodeValue(b, 10, m.Value)
+	if err != nil {
+		return nil, fmt.Errorf("metric %s: %w", m.Name, err)
+	}
+	return b, nil
+}
+
+// encodeValue agrega el valor según su tipo Go. Los campos del oneof de
+// Metric empiezan en 10 y los de PropertyValue en 3 (con el tipo en el 1),
+// ambos en el orden int, long, float, double, boolean, string.
+func encodeValue(b []byte, first int, v any) ([]byte, error) {
+	prop := first == 3
+	typed := func(t DataType) {
+		if prop {
+			b = appendVarint(b, 1, uint64(t))
+		}
+	}
+	switch v := v.(type) {
+	case bool:
+		typed(Boolean)
+		b = appendBool(b, first+4, v)
+	case int32:
+		typed(Int32)
+		b = appendVarint(b, first, uint64(uint32(v)))
+	case uint32:
+		typed(UInt32)
+		b = appendVarint(b, first, uint64(v))
+	case int64:
+		typed(Int64)
+		b = appendVarint(b, first+1, uint64(v))
+	case uint64:
+		typed(UInt64)
+		b = appendVarint(b, first+1, v)
+	case float64:
+		typed(Double)
+		b = binary.LittleEndian.AppendUint64(appendTag(b, first+3, wireFixed64), math.Float64bits(v))
+	case string:
+		typed(String)
+		b = appendBytes(b, first+5, []byte(v))
+	default:
+		return nil, fmt.Errorf("unsupported value %T", v)
+	}
+	return b, nil
+}
+
+// field es un campo leído del mensaje
+type field struct {
+	num  int
+	wire int
+	n    uint64 // varint y fixed
+	b    []byte // bytes
+}
+
+func readFields(b []byte, f func(field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrMalformed
+		}
+		b = b[n:]
+		fl := field{num: int(key >> 3), wire: int(key & 7)}
+		switch fl.wire {
+		case wireVarint:
+			fl.n, n = binary.Uvarint(b)
+			if n <= 0 {
+				return ErrMalformed
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return ErrMalformed
+			}
+			fl.n, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return ErrMalformed
+			}
+			fl.n, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return ErrMalformed
+			}
+			fl.b, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return ErrMalformed
+		}
+		if err := f(fl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode lee un payload. Los valores se devuelven con el tipo Go que
+// corresponde a su DataType; los campos que el gateway no usa se ignoran.
+func Decode(b []byte) (Payload, error) {
+	p := Payload{Seq: -1}
+	err := readFields(b, func(f field) error {
+		switch {
+		case f.num == 1 && f.wire == wireVarint:
+			p.Timestamp = time.UnixMilli(int64(f.n))
+		case f.num == 2 && f.wire == wireBytes:
+			m, err := decodeMetric(f.b)
+			if err != nil {
+				return err
+			}
+			p.Metrics = append(p.Metrics, m)
+		case f.num == 3 && f.wire == wireVarint:
+			p.Seq = int64(f.n)
+		}
+		return nil
+	})
+	if err != nil {
+		return Payload{}, err
+	}
+	return p, nil
+}
+
+func decodeMetric(b []byte) (Metric, error) {
+	var m Metric
+	var raw *field
+	err := readFields(b, func(f field) error {
+		switch {
+		case f.num == 1 && f.wire == wireBytes:
+			m.Name = string(f.b)
+		case f.num == 2 && f.wire == wireVarint:
+			m.Alias = f.n
+		case f.num == 3 && f.wire == wireVarint:
+			m.Timestamp = time.UnixMilli(int64(f.n))
+		case f.num == 4 && f.wire == wireVarint:
+			m.Type = DataType(f.n)
+		case f.num == 7 && f.wire == wireVarint:
+			m.IsNull = f.n != 0
+		case f.num >= 10 && f.num <= 15:
+			raw = &f
+		}
+		return nil
+	})
+	if err != nil {
+		return Metric{}, err
+	}
+	if raw != nil && !m.IsNull {
+		m.Value = decodeValue(m.Type, *raw)
+	}
+	return m, nil
+}
+
+func decodeValue(t DataType, f field) any {
+	switch t {
+	case Boolean:
+		return f.n != 0
+	case Int32:
+		return int32(uint32(f.n))
+	case UInt32:
+		return uint32(f.n)
+	case Int64:
+		return int64(f.n)
+	case UInt64:
+		return f.n
+	case Double:
+		return math.Float64frombits(f.n)
+	case String:
+		return string(f.b)
+	}
+	return nil
+}
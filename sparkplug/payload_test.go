@@ -0,0 +1,92 @@
+package sparkplug
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPayload_WireFormat(t *testing.T) {
+	p := Payload{
+		Timestamp: time.UnixMilli(1),
+		Seq:       0,
+		Metrics:   []Metric{{Name: "a", Alias: 1, Type: Boolean, Value: true}},
+	}
+	got, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x08, 0x01, // timestamp
+		0x12, 0x09, // metric
+		0x0a, 0x01, 'a', // name
+		0x10, 0x01, // alias
+		0x20, 0x0b, // datatype Boolean
+		0x70, 0x01, // boolean_value
+		0x18, 0x00, // seq
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encode:\n got % x\nwant % x", got, want)
+	}
+}
+
+func TestPayload_RoundTrip(t *testing.T) {
+	now := time.UnixMilli(1772359200123)
+	p := Payload{
+		Timestamp: now,
+		Seq:       255,
+		Metrics: []Metric{
+			{Name: BdSeq, Type: UInt64, Value: uint64(3)},
+			{Name: "q1", Alias: 2, Timestamp: now, Type: Boolean, Value: false,
+				Properties: map[string]any{"Quality": int32(192)}},
+			{Alias: 3, Timestamp: now, Type: UInt32, Value: uint32(70000)},
+			{Name: "temp", Type: Double, Value: -1.5},
+			{Name: "id", Type: String, Value: "gw"},
+			{Name: "offset", Type: Int32, Value: int32(-2)},
+			{Name: "lost", Alias: 4, Type: UInt32, IsNull: true},
+		},
+	}
+	b, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Timestamp.Equal(now) || got.Seq != 255 || len(got.Metrics) != len(p.Metrics) {
+		t.Fatalf("decode: %+v", got)
+	}
+	for i, m := range got.Metrics {
+		w := p.Metrics[i]
+		if m.Name != w.Name || m.Alias != w.Alias || m.Type != w.Type || m.Value != w.Value ||
+			m.IsNull != w.IsNull || !m.Timestamp.Equal(w.Timestamp) {
+			t.Errorf("metric %d: got %+v, want %+v", i, m, w)
+		}
+	}
+
+	death, _ := Payload{Seq: -1, Metrics: []Metric{{Name: BdSeq, Type: UInt64, Value: uint64(1)}}}.Encode()
+	if got, _ := Decode(death); got.Seq != -1 {
+		t.Errorf("NDEATH carries no seq, got %d", got.Seq)
+	}
+	if _, err := Decode([]byte{0x12, 0x09, 0x0a}); err == nil {
+		t.Errorf("truncated payload should fail")
+	}
+	if _, err := (Payload{Metrics: []Metric{{Name: "x", Type: UInt32, Value: 1}}}).Encode(); err == nil {
+		t.Errorf("untyped int value should fail")
+	}
+}
+
+func TestTopic(t *testing.T) {
+	topic := Topic("plants", DData, "gw1", "plc")
+	if topic != "spBv1.0/plants/DDATA/gw1/plc" {
+		t.Errorf("topic: %s", topic)
+	}
+	group, kind, node, device, ok := ParseTopic("spBv1.0/plants/NCMD/gw1")
+	if !ok || group != "plants" || kind != NCmd || node != "gw1" || device != "" {
+		t.Errorf("parse: %s %s %s %s %v", group, kind, node, device, ok)
+	}
+	if _, _, _, _, ok := ParseTopic("plc/gw1/cmd/W"); ok {
+		t.Errorf("non sparkplug topic accepted")
+	}
+}
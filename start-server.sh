@@ -1,6 +0,0 @@
-#!/bin/bash
-
-cd ./modbus-slave || exit
-source .venv/bin/activate
-python main.py &
-sleep 0.5
\ No newline at end of file
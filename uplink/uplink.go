@@ -0,0 +1,187 @@
+// Package uplink define lo que el gateway entrega a los enlaces con la
+// plataforma (Wialon, MQTT, ...) y lo que recibe de ellos, sin atarse a
+// ningún protocolo: cada uplink arma sus mensajes y maneja sus fallas.
+package uplink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mt-plc-control/quality"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type es el tipo de un valor
+type Type int
+
+const (
+	Bool Type = iota // 0 o 1
+	Int              // entero (lecturas analógicas, contadores)
+	Float
+	Text // el valor está en Sample.Text
+)
+
+// Sample es el valor de un tag, o de un valor derivado, en un escaneo
+type Sample struct {
+	Name string
+	Type Type
+	Time time.Time
+	quality.Tag
+	Text string
+}
+
+// Batch es un escaneo. Upload indica si toca subir los datos (hubo cambios
+// o venció el período); si no, el uplink solo mantiene vivo el enlace.
+type Batch struct {
+	Time    time.Time
+	Upload  bool
+	Online  bool     // el PLC respondió
+	Samples []Sample // tags del PLC, con su calidad
+	Derived []Sample // solo con Upload: estado del PLC, consumos y horas de marcha
+	// Delivered, si no es nil, lo llama Wialon cuando la plataforma aceptó los
+	// datos: recién ahí se descuentan los consumos subidos
+	Delivered func()
+}
+
+// CommandKind es el tipo de un comando
+type CommandKind int
+
+const (
+	Write        CommandKind = iota // escribir salidas del PLC
+	ResetRuntime                    // reiniciar las horas de marcha de unas salidas
+	Genset                          // arrancar o parar el grupo electrógeno
+)
+
+// OutputWrite es la escritura de una salida
+type OutputWrite struct {
+	Tag   string
+	Value bool
+}
+
+// Command es un comando recibido por un uplink
+type Command struct {
+	Kind   CommandKind
+	Writes []OutputWrite // Write
+	Tags   []string      // ResetRuntime
+	Start  bool          // Genset: true arranca, false para
+}
+
+func (c Command) String() string {
+	switch c.Kind {
+	case Write:
+		parts := make([]string, len(c.Writes))
+		for i, w := range c.Writes {
+			parts[i] = fmt.Sprintf("%s=%t", w.Tag, w.Value)
+		}
+		return "write " + strings.Join(parts, ";")
+	case ResetRuntime:
+		return "reset runtime " + strings.Join(c.Tags, ";")
+	case Genset:
+		if c.Start {
+			return "genset start"
+		}
+		return "genset stop"
+	}
+	return fmt.Sprintf("command %d", c.Kind)
+}
+
+var ErrMalformedCommand = errors.New("malformed command")
+
+// ParseCommand lee un comando en forma de texto, la de los #M# de Wialon:
+// W "q1=1;q2=0", RH "q1;q2" y GS "START" o "STOP". En W las líneas vacías se
+// saltan y las mal formadas vuelven como error junto con las escrituras
+// válidas, que se ejecutan igual.
+func ParseCommand(kind, message string) (Command, error) {
+	switch strings.ToUpper(kind) {
+	case "W":
+		cmd := Command{Kind: Write}
+		errs := make([]error, 0)
+		for line := range strings.SplitSeq(message, ";") {
+			if strings.Trim(line, " \r\n") == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(line, "=")
+			name = strings.TrimSpace(name)
+			if !ok || name == "" || strings.Contains(value, "=") {
+				errs = append(errs, fmt.Errorf("%w: W|%s", ErrMalformedCommand, line))
+				continue
+			}
+			cmd.Writes = append(cmd.Writes, OutputWrite{name, strings.Trim(value, " \r\n") == "1"})
+		}
+		if len(cmd.Writes) == 0 && len(errs) == 0 {
+			return Command{}, fmt.Errorf("%w: W|%s", ErrMalformedCommand, message)
+		}
+		return cmd, errors.Join(errs...)
+	case "RH":
+		cmd := Command{Kind: ResetRuntime}
+		for name := range strings.SplitSeq(message, ";") {
+			if name = strings.Trim(name, " \r\n"); name != "" {
+				cmd.Tags = append(cmd.Tags, name)
+			}
+		}
+		if len(cmd.Tags) == 0 {
+			return Command{}, fmt.Errorf("%w: RH|%s", ErrMalformedCommand, message)
+		}
+		return cmd, nil
+	case "GS":
+		switch strings.Trim(message, " \r\n") {
+		case "START":
+			return Command{Kind: Genset, Start: true}, nil
+		case "STOP":
+			return Command{Kind: Genset}, nil
+		}
+		return Command{}, fmt.Errorf("%w: GS|%s", ErrMalformedCommand, message)
+	}
+	return Command{}, fmt.Errorf("%w: unknown kind %s", ErrMalformedCommand, kind)
+}
+
+// Uplink es un enlace con una plataforma
+type Uplink interface {
+	Name() string
+	// Publish recibe cada escaneo. Un error detiene el gateway: el uplink
+	// maneja sus propias fallas y solo lo devuelve si no puede seguir.
+	Publish(ctx context.Context, b Batch) error
+	// Run atiende el enlace hasta que se cancele ctx y pasa a exec los
+	// comandos que recibe
+	Run(ctx context.Context, exec func(Command) error)
+}
+
+// Registry reparte los escaneos a varios uplinks, en el orden en que se
+// agregaron; un nil no tiene uplinks
+type Registry struct {
+	uplinks []Uplink
+}
+
+func (r *Registry) Add(u Uplink) {
+	r.uplinks = append(r.uplinks, u)
+}
+
+// Publish entrega b a todos los uplinks, aunque alguno falle
+func (r *Registry) Publish(ctx context.Context, b Batch) error {
+	if r == nil {
+		return nil
+	}
+	errs := make([]error, 0)
+	for _, u := range r.uplinks {
+		if err := u.Publish(ctx, b); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run corre todos los uplinks y vuelve cuando terminan
+func (r *Registry) Run(ctx context.Context, exec func(Command) error) {
+	if r == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, u := range r.uplinks {
+		wg.Go(func() {
+			u.Run(ctx, exec)
+		})
+	}
+	wg.Wait()
+}
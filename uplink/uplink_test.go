@@ -0,0 +1,120 @@
+package uplink
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	for _, c := range []struct {
+		kind, message string
+		want          Command
+	}{
+		{"W", "q1=1;q2 = 0", Command{Kind: Write, Writes: []OutputWrite{{"q1", true}, {"q2", false}}}},
+		{"w", "q1=1\r\n", Command{Kind: Write, Writes: []OutputWrite{{"q1", true}}}},
+		{"W", "q1=1;", Command{Kind: Write, Writes: []OutputWrite{{"q1", true}}}},
+		{"W", "q1=1;;q2=1", Command{Kind: Write, Writes: []OutputWrite{{"q1", true}, {"q2", true}}}},
+		{"RH", "q1; q2;", Command{Kind: ResetRuntime, Tags: []string{"q1", "q2"}}},
+		{"GS", "START", Command{Kind: Genset, Start: true}},
+		{"GS", "STOP", Command{Kind: Genset}},
+	} {
+		got, err := ParseCommand(c.kind, c.message)
+		if err != nil || got.Kind != c.want.Kind || got.Start != c.want.Start ||
+			!slices.Equal(got.Writes, c.want.Writes) || !slices.Equal(got.Tags, c.want.Tags) {
+			t.Errorf("ParseCommand(%q, %q) = %+v, %v", c.kind, c.message, got, err)
+		}
+	}
+	for _, c := range [][2]string{{"W", "q1"}, {"W", "q1=1=0"}, {"W", ";"}, {"RH", ";"}, {"GS", "REBOOT"}, {"X", "1"}} {
+		if _, err := ParseCommand(c[0], c[1]); !errors.Is(err, ErrMalformedCommand) {
+			t.Errorf("ParseCommand(%q, %q) should fail, got %v", c[0], c[1], err)
+		}
+	}
+
+	// una línea mal formada no descarta las escrituras válidas
+	got, err := ParseCommand("W", "q1=1;=0;q2=1=0;q3=0")
+	if !errors.Is(err, ErrMalformedCommand) || !strings.Contains(err.Error(), "W|=0") || !strings.Contains(err.Error(), "W|q2=1=0") {
+		t.Errorf("malformed lines should be reported: %v", err)
+	}
+	if want := []OutputWrite{{"q1", true}, {"q3", false}}; !slices.Equal(got.Writes, want) {
+		t.Errorf("valid writes = %v, want %v", got.Writes, want)
+	}
+}
+
+type fakeUplink struct {
+	name    string
+	err     error
+	cmd     *Command
+	mu      sync.Mutex
+	batches []Batch
+}
+
+func (f *fakeUplink) Name() string {
+	return f.name
+}
+
+func (f *fakeUplink) Publish(_ context.Context, b Batch) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, b)
+	return f.err
+}
+
+func (f *fakeUplink) Run(ctx context.Context, exec func(Command) error) {
+	if f.cmd != nil {
+		_ = exec(*f.cmd)
+	}
+	<-ctx.Done()
+}
+
+func TestRegistry_FanOut(t *testing.T) {
+	failing := &fakeUplink{name: "wialon", err: errors.New("link down"), cmd: &Command{Kind: Genset, Start: true}}
+	ok := &fakeUplink{name: "mqtt", cmd: &Command{Kind: Write, Writes: []OutputWrite{{"q1", true}}}}
+	r := &Registry{}
+	r.Add(failing)
+	r.Add(ok)
+
+	b := Batch{Time: time.Unix(0, 0), Upload: true, Samples: []Sample{{Name: "q1", Type: Bool}}}
+	err := r.Publish(context.Background(), b)
+	if err == nil || !strings.Contains(err.Error(), "wialon: link down") {
+		t.Errorf("failure should name the uplink: %v", err)
+	}
+	if len(failing.batches) != 1 || len(ok.batches) != 1 {
+		t.Errorf("every uplink should get the batch despite failures")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	got := make([]string, 0)
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx, func(cmd Command) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, cmd.String())
+			if len(got) == 2 {
+				cancel()
+			}
+			return nil
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run should return when ctx is cancelled")
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"genset start", "write q1=true"}) {
+		t.Errorf("commands: %v", got)
+	}
+
+	var none *Registry
+	if none.Publish(context.Background(), b) != nil {
+		t.Errorf("nil registry should accept batches")
+	}
+}
@@ -0,0 +1,220 @@
+package wailonServer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"mt-plc-control/clock"
+	"mt-plc-control/history"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BlackBoxSender interface {
+	SendBlackBox(records []BlackBoxRecord) (int, error)
+}
+
+type HistorySource interface {
+	Query(tags []string, from, to time.Time) ([]history.Point, error)
+}
+
+// Backfill repone en Wialon los datos del histórico local que no llegaron
+// durante un corte. La marca de agua de SentCache solo avanza con #AB# confirmado.
+type Backfill struct {
+	Imei      string
+	BatchSize int           // registros por paquete #B#
+	BatchGap  time.Duration // pausa entre paquetes
+	SaveEvery time.Duration // cada cuánto se persiste la marca con envíos en vivo
+	Clock     clock.Clock   // nil usa el reloj real
+
+	cache  *SentCache
+	hist   HistorySource
+	sender BlackBoxSender
+
+	mu       sync.Mutex
+	outage   bool
+	pending  bool
+	outbox   int // escaneos sin subir: los fallidos en vivo, o lo que queda de la reposición
+	gapEnd   time.Time
+	liveSent time.Time
+	trigger  chan struct{}
+}
+
+func NewBackfill(imei string, cache *SentCache, hist HistorySource, sender BlackBoxSender) *Backfill {
+	_, hasMark := cache.LastSent(imei)
+	return &Backfill{
+		Imei:      imei,
+		BatchSize: 50,
+		BatchGap:  2 * time.Second,
+		SaveEvery: time.Minute,
+		cache:     cache,
+		hist:      hist,
+		sender:    sender,
+		// con marca previa, lo ocurrido desde entonces puede no haberse enviado
+		outage:  hasMark,
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// LiveFailed se llama cuando falla un envío o ping en vivo
+func (b *Backfill) LiveFailed() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.outage = true
+	b.outbox++
+}
+
+// LiveSent se llama cuando Wialon confirmó un envío o ping en vivo en `t`
+func (b *Backfill) LiveSent(t time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.outage && !b.pending {
+		b.outage = false
+		b.pending = true
+		b.gapEnd = t
+		b.liveSent = t
+		select {
+		case b.trigger <- struct{}{}:
+		default:
+		}
+		return
+	}
+	b.outage = false
+	if b.pending {
+		b.liveSent = t
+		return
+	}
+	if last, ok := b.cache.LastSent(b.Imei); !ok || t.Sub(last) >= b.SaveEvery {
+		b.cache.UpdateSent(b.Imei, t)
+	}
+}
+
+// Outbox devuelve cuántos escaneos del histórico esperan ser repuestos en
+// Wialon. Durante un corte cuenta los envíos fallidos, sin leer el histórico;
+// la reposición lo corrige con los registros que encontró.
+func (b *Backfill) Outbox() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.outage && !b.pending {
+		return 0
+	}
+	return b.outbox
+}
+
+// setOutbox fija la cuenta de Outbox al empezar la reposición
+func (b *Backfill) setOutbox(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.outbox = n
+}
+
+// sent descuenta de Outbox los registros confirmados
+func (b *Backfill) sent(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.outbox -= min(n, b.outbox)
+}
+
+func (b *Backfill) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.trigger:
+		}
+		b.mu.Lock()
+		gapEnd := b.gapEnd
+		b.mu.Unlock()
+
+		err := b.replay(ctx, gapEnd)
+
+		b.mu.Lock()
+		b.pending = false
+		if err != nil {
+			log.Printf("backfill stopped: %v", err)
+			b.outage = true
+		} else if b.liveSent.After(gapEnd) {
+			b.cache.UpdateSent(b.Imei, b.liveSent)
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *Backfill) replay(ctx context.Context, gapEnd time.Time) error {
+	from, ok := b.cache.LastSent(b.Imei)
+	if !ok {
+		b.cache.UpdateSent(b.Imei, gapEnd)
+		return nil
+	}
+	points, err := b.hist.Query(nil, from, gapEnd)
+	if err != nil {
+		return fmt.Errorf("reading history: %w", err)
+	}
+	records := make([]BlackBoxRecord, 0)
+	for _, p := range groupByTime(points) {
+		if !p.Time.After(from) || b.cache.HasSent(b.Imei, p.Time) {
+			continue
+		}
+		records = append(records, p)
+	}
+	b.setOutbox(len(records))
+	if len(records) == 0 {
+		b.cache.UpdateSent(b.Imei, gapEnd)
+		return nil
+	}
+	log.Printf("backfill: %d records between %s and %s", len(records),
+		from.Format(time.RFC3339), gapEnd.Format(time.RFC3339))
+
+	for start := 0; start < len(records); start += b.BatchSize {
+		end := min(start+b.BatchSize, len(records))
+		batch := records[start:end]
+		n, err := b.sender.SendBlackBox(batch)
+		if n > 0 {
+			b.cache.UpdateSent(b.Imei, batch[min(n, len(batch))-1].Time)
+			b.sent(n)
+		}
+		if err != nil {
+			return err
+		}
+		if n < len(batch) {
+			return fmt.Errorf("server acknowledged %d of %d records", n, len(batch))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clock.Or(b.Clock).After(b.BatchGap):
+		}
+	}
+	b.cache.UpdateSent(b.Imei, gapEnd)
+	return nil
+}
+
+// groupByTime junta los puntos de un mismo escaneo en un registro param:tipo:valor
+func groupByTime(points []history.Point) []BlackBoxRecord {
+	records := make([]BlackBoxRecord, 0)
+	for _, p := range points {
+		param := fmt.Sprintf("%s:2:%s", p.Tag, strconv.FormatFloat(p.Value, 'f', -1, 64))
+		if p.Value == math.Trunc(p.Value) {
+			param = fmt.Sprintf("%s:1:%d", p.Tag, int64(p.Value))
+		}
+		if n := len(records); n > 0 && records[n-1].Time.Equal(p.Time) {
+			records[n-1].Params = strings.Join([]string{records[n-1].Params, param}, ",")
+			continue
+		}
+		records = append(records, BlackBoxRecord{p.Time, param})
+	}
+	return records
+}
@@ -0,0 +1,180 @@
+package wailonServer
+
+import (
+	"context"
+	"errors"
+	"mt-plc-control/history"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	mu      sync.Mutex
+	batches [][]BlackBoxRecord
+	ack     func(batch []BlackBoxRecord) (int, error)
+}
+
+func (s *fakeSender) SendBlackBox(records []BlackBoxRecord) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, records)
+	if s.ack != nil {
+		return s.ack(records)
+	}
+	return len(records), nil
+}
+
+var bfT0 = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func newBackfillHistory(t *testing.T, scans int) *history.Store {
+	t.Helper()
+	hist, err := history.Open(t.TempDir(), history.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = hist.Close()
+	})
+	points := make([]history.Point, 0)
+	for i := 0; i < scans; i++ {
+		ts := bfT0.Add(time.Duration(i) * 30 * time.Second)
+		points = append(points,
+			history.Point{Time: ts, Tag: "q1", Value: float64(i % 2)},
+			history.Point{Time: ts, Tag: "presion", Value: 4.5})
+	}
+	if err := hist.Append(points); err != nil {
+		t.Fatal(err)
+	}
+	return hist
+}
+
+func runBackfill(t *testing.T, bf *Backfill, gapEnd time.Time) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bf.Run(ctx)
+		close(done)
+	}()
+	bf.LiveFailed()
+	bf.LiveSent(gapEnd)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		bf.mu.Lock()
+		pending := bf.pending
+		bf.mu.Unlock()
+		if !pending || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestBackfill_ReplaysGapInBatches(t *testing.T) {
+	hist := newBackfillHistory(t, 10)
+	cache := NewSentCache(tempFilePath(t))
+	cache.UpdateSent("imei", bfT0.Add(30*time.Second))
+
+	sender := &fakeSender{}
+	bf := NewBackfill("imei", cache, hist, sender)
+	bf.BatchSize = 3
+	bf.BatchGap = 0
+
+	gapEnd := bfT0.Add(8 * 30 * time.Second)
+	runBackfill(t, bf, gapEnd)
+
+	// escaneos 2..7 -> 6 registros en 2 paquetes
+	if len(sender.batches) != 2 {
+		t.Fatalf("expected 2 batches, got %d", len(sender.batches))
+	}
+	first := sender.batches[0][0]
+	if !first.Time.Equal(bfT0.Add(60*time.Second)) || first.Params != "q1:1:0,presion:2:4.5" {
+		t.Errorf("unexpected first record: %+v", first)
+	}
+	if last, _ := cache.LastSent("imei"); !last.Equal(gapEnd) {
+		t.Errorf("watermark should reach gap end, got %v", last)
+	}
+}
+
+func TestBackfill_WatermarkOnlyOnAck(t *testing.T) {
+	hist := newBackfillHistory(t, 10)
+	cache := NewSentCache(tempFilePath(t))
+	cache.UpdateSent("imei", bfT0)
+
+	calls := 0
+	sender := &fakeSender{ack: func(batch []BlackBoxRecord) (int, error) {
+		calls++
+		if calls == 1 {
+			return len(batch), nil
+		}
+		return 1, errors.New("connection reset")
+	}}
+	bf := NewBackfill("imei", cache, hist, sender)
+	bf.BatchSize = 4
+	bf.BatchGap = 0
+
+	runBackfill(t, bf, bfT0.Add(10*30*time.Second))
+
+	// primer paquete: escaneos 1..4, segundo: solo confirma el 5
+	if last, _ := cache.LastSent("imei"); !last.Equal(bfT0.Add(5 * 30 * time.Second)) {
+		t.Errorf("watermark should stop at last acknowledged record, got %v", last)
+	}
+	if !bf.outage {
+		t.Errorf("failed backfill should be retried on next live send")
+	}
+}
+
+func TestBackfill_LiveSentAdvancesWatermark(t *testing.T) {
+	cache := NewSentCache(tempFilePath(t))
+	bf := NewBackfill("imei", cache, newBackfillHistory(t, 0), &fakeSender{})
+	bf.SaveEvery = 0
+
+	bf.LiveSent(bfT0)
+	if last, ok := cache.LastSent("imei"); !ok || !last.Equal(bfT0) {
+		t.Fatalf("live send should advance watermark without outage, got %v", last)
+	}
+}
+
+func TestBackfill_Outbox(t *testing.T) {
+	hist := newBackfillHistory(t, 10)
+	cache := NewSentCache(tempFilePath(t))
+	cache.UpdateSent("imei", bfT0.Add(30*time.Second))
+	sender := &fakeSender{ack: func(batch []BlackBoxRecord) (int, error) {
+		return 2, errors.New("link dropped")
+	}}
+	bf := NewBackfill("imei", cache, hist, sender)
+	bf.outage = false
+	bf.BatchSize = 3
+
+	if n := bf.Outbox(); n != 0 {
+		t.Errorf("without an outage nothing waits, got %d", n)
+	}
+	for range 3 {
+		bf.LiveFailed()
+	}
+	if n := bf.Outbox(); n != 3 {
+		t.Errorf("outbox during an outage: got %d, want the 3 failed scans", n)
+	}
+
+	// la reposición cuenta los escaneos 2..9 del histórico y descuenta los confirmados
+	runBackfill(t, bf, bfT0.Add(10*30*time.Second))
+	if n := bf.Outbox(); n != 6 {
+		t.Errorf("outbox after a partial replay: got %d, want 6", n)
+	}
+	sender.mu.Lock()
+	sender.ack = nil
+	sender.mu.Unlock()
+	bf.BatchGap = 0
+	runBackfill(t, bf, bfT0.Add(10*30*time.Second))
+	if n := bf.Outbox(); n != 0 {
+		t.Errorf("outbox after the replay: got %d", n)
+	}
+
+	var nilBackfill *Backfill
+	if n := nilBackfill.Outbox(); n != 0 {
+		t.Errorf("no backfill: got %d", n)
+	}
+}
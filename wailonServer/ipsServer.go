@@ -0,0 +1,309 @@
+package wailonServer
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Packet es un paquete recibido por IPSServer
+type Packet struct {
+	Type     string // L, D, B, P...
+	Body     string // sin cabecera ni \r\n
+	CRCValid bool
+	Conn     int // número de conexión en que llegó
+	At       time.Time
+}
+
+// IPSServer es un servidor Wialon IPS 2.0 en proceso para pruebas y corridas
+// locales (MOCK=1). Valida CRC, responde #AL#/#AD#/#AP#/#AB# y guarda lo recibido.
+type IPSServer struct {
+	Logf func(format string, args ...any)
+
+	mu          sync.Mutex
+	ln          net.Listener
+	conns       map[int]net.Conn
+	lastConn    int
+	packets     []Packet
+	codes       map[string]string
+	blackBoxAck func(records int) int
+	latency     time.Duration
+	injected    []string
+	dropNext    int
+	offline     bool
+	notify      chan struct{}
+	wg          sync.WaitGroup
+}
+
+func NewIPSServer() *IPSServer {
+	return &IPSServer{
+		conns:  make(map[int]net.Conn),
+		codes:  map[string]string{"L": "1", "D": "1"},
+		notify: make(chan struct{}),
+	}
+}
+
+// Start escucha en address ("127.0.0.1:0" para un puerto libre)
+func (s *IPSServer) Start(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("ips server listen: %w", err)
+	}
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.acceptLoop(ln)
+	return nil
+}
+
+func (s *IPSServer) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return ""
+	}
+	return s.ln.Addr().String()
+}
+
+func (s *IPSServer) Close() {
+	s.mu.Lock()
+	if s.ln != nil {
+		_ = s.ln.Close()
+	}
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// SetCode cambia el código de respuesta para un tipo de paquete, ej. SetCode("L", "01").
+// En "B" reemplaza la cantidad de registros confirmados.
+func (s *IPSServer) SetCode(kind string, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[kind] = code
+}
+
+// SetBlackBoxAck decide cuántos registros de un #B# se confirman (nil = todos)
+func (s *IPSServer) SetBlackBoxAck(ack func(records int) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blackBoxAck = ack
+}
+
+func (s *IPSServer) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// InjectResponse reemplaza la próxima respuesta por raw (tal cual, para respuestas malformadas)
+func (s *IPSServer) InjectResponse(raw string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.injected = append(s.injected, raw)
+}
+
+// DropNext cierra la conexión al recibir los próximos n paquetes, sin responder
+func (s *IPSServer) DropNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropNext += n
+}
+
+// SetOffline simula un corte: mientras dure, cada paquete cierra la conexión sin respuesta
+func (s *IPSServer) SetOffline(offline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offline = offline
+}
+
+func (s *IPSServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, c := range s.conns {
+		_ = c.Close()
+		delete(s.conns, id)
+	}
+}
+
+// SendCommand envía un #M# a la última conexión abierta, en el formato que espera ReadCommand
+func (s *IPSServer) SendCommand(kind, value string) error {
+	s.mu.Lock()
+	c, ok := s.conns[s.lastConn]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no client connected")
+	}
+	_, err := c.Write([]byte(fmt.Sprintf("#M##%s#%s#\r\n", kind, value)))
+	return err
+}
+
+func (s *IPSServer) Packets() []Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Packet{}, s.packets...)
+}
+
+// WaitPacket espera hasta timeout un paquete del tipo indicado con índice >= from
+func (s *IPSServer) WaitPacket(kind string, from int, timeout time.Duration) (Packet, int, bool) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		for i := from; i < len(s.packets); i++ {
+			if s.packets[i].Type == kind {
+				p := s.packets[i]
+				s.mu.Unlock()
+				return p, i, true
+			}
+		}
+		notify := s.notify
+		s.mu.Unlock()
+		select {
+		case <-notify:
+		case <-deadline:
+			return Packet{}, -1, false
+		}
+	}
+}
+
+func (s *IPSServer) acceptLoop(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.lastConn++
+		id := s.lastConn
+		s.conns[id] = c
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(id, c)
+	}
+}
+
+func (s *IPSServer) serve(id int, c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		_ = c.Close()
+		s.mu.Lock()
+		delete(s.conns, id)
+		s.mu.Unlock()
+	}()
+
+	reader := bufio.NewReader(c)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		p := parsePacket(strings.TrimRight(line, "\r\n"))
+		p.Conn = id
+		p.At = time.Now()
+		s.logf("ips server: received %s", strings.TrimRight(line, "\r\n"))
+
+		s.mu.Lock()
+		s.packets = append(s.packets, p)
+		close(s.notify)
+		s.notify = make(chan struct{})
+		drop := s.offline || s.dropNext > 0
+		if drop && !s.offline {
+			s.dropNext--
+		}
+		latency := s.latency
+		res := s.response(p)
+		if len(s.injected) > 0 {
+			res = s.injected[0]
+			s.injected = s.injected[1:]
+		}
+		s.mu.Unlock()
+
+		if drop {
+			return
+		}
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+		if res == "" {
+			continue
+		}
+		if _, err := c.Write([]byte(res)); err != nil {
+			return
+		}
+	}
+}
+
+// response arma la respuesta; se llama con s.mu tomado
+func (s *IPSServer) response(p Packet) string {
+	switch p.Type {
+	case "L":
+		if !p.CRCValid {
+			return "#AL#10\r\n"
+		}
+		return fmt.Sprintf("#AL#%s\r\n", s.codes["L"])
+	case "D":
+		if !p.CRCValid {
+			return "#AD#10\r\n"
+		}
+		if len(strings.Split(p.Body, ";")) < 17 {
+			return "#AD#-1\r\n"
+		}
+		return fmt.Sprintf("#AD#%s\r\n", s.codes["D"])
+	case "P":
+		return fmt.Sprintf("#AP#%s\r\n", s.codes["P"])
+	case "B":
+		if !p.CRCValid {
+			return "#AB#0\r\n"
+		}
+		if code, ok := s.codes["B"]; ok {
+			return fmt.Sprintf("#AB#%s\r\n", code)
+		}
+		n := strings.Count(p.Body, "|")
+		if s.blackBoxAck != nil {
+			n = s.blackBoxAck(n)
+		}
+		return fmt.Sprintf("#AB#%d\r\n", n)
+	}
+	return ""
+}
+
+func (s *IPSServer) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// parsePacket separa "#T#body" y valida el CRC16 del final:
+// tras el último ';' para #L#/#D#, tras el último '|' para #B#.
+func parsePacket(line string) Packet {
+	parts := strings.SplitN(line, "#", 3)
+	if len(parts) != 3 || parts[0] != "" {
+		return Packet{Type: "?", Body: line}
+	}
+	p := Packet{Type: parts[1], Body: parts[2]}
+	sep := ";"
+	switch p.Type {
+	case "B":
+		sep = "|"
+	case "L", "D":
+	default:
+		p.CRCValid = true
+		return p
+	}
+	i := strings.LastIndex(p.Body, sep)
+	if i < 0 {
+		return p
+	}
+	message, crc := p.Body[:i+1], p.Body[i+1:]
+	p.CRCValid = strings.EqualFold(crc, crcChecksum([]byte(message)))
+	return p
+}
@@ -0,0 +1,187 @@
+package wailonServer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startIPSServer(t *testing.T) (*IPSServer, *WailonConnection) {
+	t.Helper()
+	srv := NewIPSServer()
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(srv.Addr())
+	conn := &WailonConnection{Imei: "864000000000001", Url: host, Port: port}
+	t.Cleanup(conn.CloseSocket)
+	return srv, conn
+}
+
+func TestIPSServer_LoginAndData(t *testing.T) {
+	srv, conn := startIPSServer(t)
+
+	if err := conn.OpenSocket(); err != nil {
+		t.Fatalf("login should succeed: %v", err)
+	}
+	if err := conn.SendData("q1:1:1,presion:1:45"); err != nil {
+		t.Fatalf("data should be acknowledged: %v", err)
+	}
+
+	p, _, ok := srv.WaitPacket("D", 0, time.Second)
+	if !ok {
+		t.Fatalf("data packet not recorded")
+	}
+	if !p.CRCValid || !strings.Contains(p.Body, "q1:1:1,presion:1:45") {
+		t.Errorf("unexpected packet: %+v", p)
+	}
+	login, _, _ := srv.WaitPacket("L", 0, time.Second)
+	if login.Body[:len("2.0;864000000000001;NA;")] != "2.0;864000000000001;NA;" || !login.CRCValid {
+		t.Errorf("unexpected login: %+v", login)
+	}
+}
+
+func TestIPSServer_ErrorCodes(t *testing.T) {
+	srv, conn := startIPSServer(t)
+
+	srv.SetCode("L", "01")
+	if err := conn.OpenSocket(); err == nil || !strings.Contains(err.Error(), "#AL#01") {
+		t.Errorf("login with password error should fail, got %v", err)
+	}
+
+	srv.SetCode("L", "1")
+	srv.SetCode("D", "0")
+	if err := conn.SendData("q1:1:1"); err == nil || !strings.Contains(err.Error(), "#AD#0") {
+		t.Errorf("data with time error should fail, got %v", err)
+	}
+}
+
+func TestIPSServer_BadCRC(t *testing.T) {
+	srv, _ := startIPSServer(t)
+
+	c, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	reader := bufio.NewReader(c)
+	res, err := writePacket("#L#2.0;123;NA;FFFF\r\n", c, reader, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "#AL#10\r\n" {
+		t.Errorf("bad CRC login should answer #AL#10, got %q", res)
+	}
+	res, _ = writePacket("#P#\r\n", c, reader, nil, time.Second)
+	if res != "#AP#\r\n" {
+		t.Errorf("ping should answer #AP#, got %q", res)
+	}
+
+	srv.SetCode("P", "1")
+	res, _ = writePacket("#P#\r\n", c, reader, nil, time.Second)
+	if res != "#AP#1\r\n" {
+		t.Errorf("ping should answer the configured code, got %q", res)
+	}
+}
+
+func TestIPSServer_BlackBox(t *testing.T) {
+	srv, conn := startIPSServer(t)
+	records := []BlackBoxRecord{
+		{time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC), "q1:1:1"},
+		{time.Date(2026, 3, 1, 10, 0, 30, 0, time.UTC), "q1:1:0"},
+		{time.Date(2026, 3, 1, 10, 1, 0, 0, time.UTC), "q1:1:1"},
+	}
+
+	n, err := conn.SendBlackBox(records)
+	if err != nil || n != 3 {
+		t.Fatalf("got n=%d err=%v, want 3 records acknowledged", n, err)
+	}
+	p, _, _ := srv.WaitPacket("B", 0, time.Second)
+	if !p.CRCValid || !strings.HasPrefix(p.Body, "010326;100000;") {
+		t.Errorf("unexpected black box packet: %+v", p)
+	}
+
+	srv.SetBlackBoxAck(func(records int) int { return 1 })
+	if n, _ := conn.SendBlackBox(records); n != 1 {
+		t.Errorf("partial ack: got %d want 1", n)
+	}
+
+	// el código fijo manda sobre la cantidad de registros
+	srv.SetCode("B", "2")
+	if n, _ := conn.SendBlackBox(records); n != 2 {
+		t.Errorf("configured ack: got %d want 2", n)
+	}
+}
+
+func TestIPSServer_CommandInjection(t *testing.T) {
+	srv, conn := startIPSServer(t)
+	if err := conn.OpenSocket(); err != nil {
+		t.Fatal(err)
+	}
+
+	if kind, _, err := conn.ReadCommand(); err != nil || kind != "Timeout" {
+		t.Fatalf("without command ReadCommand should time out, got %s %v", kind, err)
+	}
+	if err := srv.SendCommand("W", "W_Q1=1"); err != nil {
+		t.Fatal(err)
+	}
+	kind, value, err := conn.ReadCommand()
+	if err != nil || kind != "W" || value != "W_Q1=1" {
+		t.Fatalf("got %q %q %v, want W W_Q1=1", kind, value, err)
+	}
+}
+
+func TestIPSServer_FaultInjection(t *testing.T) {
+	srv, conn := startIPSServer(t)
+
+	srv.InjectResponse("#AL#garbage\r\n")
+	if err := conn.OpenSocket(); err == nil {
+		t.Errorf("malformed login response should fail")
+	}
+
+	srv.DropNext(1)
+	if err := conn.SendData("q1:1:1"); err == nil {
+		t.Errorf("dropped connection should fail the send")
+	}
+
+	srv.SetLatency(300 * time.Millisecond)
+	start := time.Now()
+	if err := conn.OpenSocket(); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 300*time.Millisecond {
+		t.Errorf("latency not applied")
+	}
+}
+
+func TestWailonConnection_Reconnects(t *testing.T) {
+	srv, conn := startIPSServer(t)
+	if err := conn.OpenSocket(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendData("q1:1:1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.Reconnects(); n != 0 {
+		t.Errorf("the login of each send is not a reconnect, got %d", n)
+	}
+
+	srv.DropNext(1)
+	if err := conn.SendData("q1:1:0"); err == nil {
+		t.Fatalf("dropped connection should fail the send")
+	}
+	if err := conn.SendData("q1:1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendData("q1:1:1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.Reconnects(); n != 1 {
+		t.Errorf("reconnects after a dropped send: got %d, want 1", n)
+	}
+}
@@ -3,10 +3,14 @@ package wailonServer
 import (
 	"encoding/gob"
 	"os"
+	"sync"
 	"time"
 )
 
+// SentCache es segura para uso concurrente: Backfill la escribe desde Run y
+// LiveSent y la lee desde Outbox
 type SentCache struct {
+	mu       sync.Mutex
 	sentMap  map[string]time.Time
 	diskPath string
 }
@@ -21,6 +25,8 @@ func NewSentCache(diskPath string) *SentCache {
 }
 
 func (c *SentCache) SaveCache() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	f, err := os.Create(c.diskPath)
 	if err != nil {
 		return
@@ -37,6 +43,8 @@ func (c *SentCache) SaveCache() {
 }
 
 func (c *SentCache) LoadCache() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	f, err := os.Open(c.diskPath)
 	if err != nil {
 		c.sentMap = make(map[string]time.Time)
@@ -57,17 +65,28 @@ func (c *SentCache) LoadCache() {
 }
 
 func (c *SentCache) HasSent(imei string, t time.Time) bool {
-    sent, ok := c.sentMap[imei]
-    if !ok {
-        return false
-    }
-    // Return true only when query time is strictly before the stored time.
-    // If equal or after, consider it not sent to avoid duplicates at the same timestamp.
-    return t.Before(sent)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sent, ok := c.sentMap[imei]
+	if !ok {
+		return false
+	}
+	// Return true only when query time is strictly before the stored time.
+	// If equal or after, consider it not sent to avoid duplicates at the same timestamp.
+	return t.Before(sent)
+}
+
+func (c *SentCache) LastSent(imei string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sent, ok := c.sentMap[imei]
+	return sent, ok
 }
 
 func (c *SentCache) UpdateSent(imei string, sent time.Time) bool {
+	c.mu.Lock()
 	c.sentMap[imei] = sent
+	c.mu.Unlock()
 	c.SaveCache()
 	return true
 }
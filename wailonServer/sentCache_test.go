@@ -3,6 +3,7 @@ package wailonServer
 import (
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -98,3 +99,23 @@ func TestSaveCreatesNonEmptyFile(t *testing.T) {
 		t.Fatalf("expected cache file to be non-empty, size=%d", fi.Size())
 	}
 }
+
+func TestSentCache_Concurrent(t *testing.T) {
+	c := NewSentCache(tempFilePath(t))
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			c.UpdateSent("A", t0.Add(time.Duration(i)*time.Second))
+		})
+		wg.Go(func() {
+			c.LastSent("A")
+			c.HasSent("A", t0)
+		})
+	}
+	wg.Wait()
+	if _, ok := c.LastSent("A"); !ok {
+		t.Fatalf("expected a stored time")
+	}
+}
@@ -3,33 +3,60 @@ package wailonServer
 import (
 	"bufio"
 	"net"
+	"strings"
 	"time"
 )
 
-func writePacket(packet string, con net.Conn) (string, error) {
-	_, err := con.Write([]byte(packet))
-	if err != nil {
-		return "", err
+// respuesta de Wialon por defecto; con un enlace medio abierto no se espera más
+const defaultResponseTimeout = 10 * time.Second
+
+// espera por defecto de cada lectura de comandos; mientras dura, la sesión
+// queda tomada y los envíos esperan
+const defaultCommandWait = 800 * time.Millisecond
+
+// writePacket escribe el paquete y devuelve la primera respuesta. Los #M# que
+// lleguen antes de la respuesta se pasan a onCommand para no perderlos.
+// Si en timeout no llega una línea completa devuelve error.
+func writePacket(packet string, con net.Conn, reader *bufio.Reader, onCommand func(string), timeout time.Duration) (string, error) {
+	// lo que quedó en el buffer (respuestas duplicadas o cortadas) no es
+	// respuesta a este paquete; solo se rescatan los comandos completos
+	if n := reader.Buffered(); n > 0 {
+		stale, _ := reader.Peek(n)
+		for _, line := range strings.SplitAfter(string(stale), "\n") {
+			if onCommand != nil && strings.HasPrefix(line, "#M#") && strings.HasSuffix(line, "\n") {
+				onCommand(line)
+			}
+		}
+		_, _ = reader.Discard(n)
 	}
 
-	/*_ = con.SetReadDeadline(time.Now().Add(2000 * time.Millisecond))
+	_ = con.SetDeadline(time.Now().Add(timeout))
 	defer func() {
-		_ = con.SetReadDeadline(time.Time{})
-	}()*/
-	reader := bufio.NewReader(con)
-	res, err := reader.ReadString('\n')
+		_ = con.SetDeadline(time.Time{})
+	}()
+	_, err := con.Write([]byte(packet))
 	if err != nil {
 		return "", err
 	}
-	return res, nil
+	for {
+		res, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if onCommand != nil && strings.HasPrefix(res, "#M#") {
+			onCommand(res)
+			continue
+		}
+		return res, nil
+	}
 }
 
-func readPacket(con net.Conn) (string, error) {
-	_ = con.SetReadDeadline(time.Now().Add(800 * time.Millisecond))
+// readPacket espera una línea hasta wait
+func readPacket(con net.Conn, reader *bufio.Reader, wait time.Duration) (string, error) {
+	_ = con.SetReadDeadline(time.Now().Add(wait))
 	defer func() {
 		_ = con.SetReadDeadline(time.Time{})
 	}()
-	reader := bufio.NewReader(con)
 	res, err := reader.ReadString('\n')
 	if err != nil {
 		return "", err
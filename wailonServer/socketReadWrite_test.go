@@ -0,0 +1,88 @@
+package wailonServer
+
+import (
+	"bufio"
+	"mt-plc-control/faultProxy"
+	"net"
+	"testing"
+	"time"
+)
+
+// startFaultyLink pone un proxy con fallas entre la conexión y el servidor IPS
+func startFaultyLink(t *testing.T, script string) (*IPSServer, *faultProxy.Proxy, *WailonConnection) {
+	t.Helper()
+	srv := NewIPSServer()
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	faults, err := faultProxy.ParseSchedule(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := faultProxy.New(srv.Addr())
+	p.Add(faults...)
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	host, port, _ := net.SplitHostPort(p.Addr())
+	conn := &WailonConnection{Imei: "864000000000001", Url: host, Port: port, Timeout: 300 * time.Millisecond}
+	t.Cleanup(conn.CloseSocket)
+	return srv, p, conn
+}
+
+func TestWritePacket_LinkFaults(t *testing.T) {
+	cases := map[string]string{
+		"truncated line":     "down truncate 3",
+		"reset mid-response": "down reset 3",
+		"half-open socket":   "up blackhole",
+		"response too late":  "down delay 600ms",
+	}
+	for name, script := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, p, conn := startFaultyLink(t, script)
+
+			start := time.Now()
+			if err := conn.OpenSocket(); err == nil {
+				t.Errorf("login should fail")
+			}
+			if time.Since(start) > 2*time.Second {
+				t.Errorf("login should give up after the response timeout, took %s", time.Since(start))
+			}
+			if p.Pending() != 0 {
+				t.Errorf("fault was not applied")
+			}
+			if err := conn.SendData("q1:1:1"); err != nil {
+				t.Errorf("a fresh connection should work again: %v", err)
+			}
+		})
+	}
+}
+
+func TestWritePacket_DiscardsDuplicatedResponse(t *testing.T) {
+	srv, _ := startIPSServer(t)
+	p := faultProxy.New(srv.Addr())
+	p.Add(faultProxy.Fault{Dir: faultProxy.Downstream, Kind: faultProxy.Duplicate})
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+
+	c, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	reader := bufio.NewReader(c)
+	if res, err := writePacket("#P#\r\n", c, reader, nil, time.Second); err != nil || res != "#AP#\r\n" {
+		t.Fatalf("ping: got %q %v", res, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	res, err := writePacket("#L#2.0;123;NA;FFFF\r\n", c, reader, nil, time.Second)
+	if err != nil || res != "#AL#10\r\n" {
+		t.Errorf("the duplicated #AP# should not be taken as the login answer, got %q %v", res, err)
+	}
+}
@@ -1,50 +1,128 @@
 package wailonServer
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"log"
+	"mt-plc-control/clock"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type WailonConnection struct {
-	Imei string
-	conn net.Conn
-	mu   sync.Mutex
-	Url  string
-	Port string
+	Imei     string
+	conn     net.Conn
+	reader   *bufio.Reader
+	commands []string // #M# recibidos mientras se esperaba otra respuesta
+	mu       sync.Mutex
+	connMu   sync.Mutex // solo para c.conn: CloseSocket no espera una lectura en curso
+	Url      string
+	Port     string
+	Clock    clock.Clock   // hora de los #D#; nil usa el reloj real
+	Timeout  time.Duration // espera de cada respuesta; 0 usa defaultResponseTimeout
+	ReadWait time.Duration // espera de cada lectura de ReadCommand; 0 usa defaultCommandWait
+
+	// OnResponse, si no es nil, recibe cuánto tardó cada respuesta del servidor (L, D o B)
+	OnResponse func(kind string, latency time.Duration, err error)
+
+	broken     bool // falló un envío o la conexión desde el último login
+	reconnects atomic.Int64
 }
 
-func (c *WailonConnection) OpenSocket() error {
+var errNotConnected = errors.New("not connected to wailon")
 
-	if c.conn != nil {
-		c.conn.Close()
+func (c *WailonConnection) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
 	}
+	return defaultResponseTimeout
+}
 
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", c.Url, c.Port))
-	c.conn = conn
+func (c *WailonConnection) readWait() time.Duration {
+	if c.ReadWait > 0 {
+		return c.ReadWait
+	}
+	return defaultCommandWait
+}
+
+// OpenSocket abre una conexión nueva y hace login
+func (c *WailonConnection) OpenSocket() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.openSocket()
+}
+
+// openSocket es OpenSocket con c.mu tomado
+func (c *WailonConnection) openSocket() error {
+	c.dropConn()
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.Url, c.Port), c.timeout())
 	if err != nil {
+		c.broken = true
 		return fmt.Errorf("opening socket, got: %w", err)
 	}
+	c.setConn(conn)
+	c.reader = bufio.NewReader(conn)
 
 	login := fmt.Sprintf("2.0;%s;NA;", c.Imei)
 	CRC := crcChecksum([]byte(login))
-	res, err := writePacket(fmt.Sprintf("#L#%s%s\r\n", login, CRC), c.conn)
+	res, err := c.exchange("L", fmt.Sprintf("#L#%s%s\r\n", login, CRC))
+	if err != nil {
+		c.broken = true
+		return fmt.Errorf("on login, got: %w", err)
+	}
 	if !strings.Contains(res, "#AL#1") {
+		c.broken = true
 		return fmt.Errorf("login unsuccessful, got: %s", res)
 	}
-	if err != nil {
-		return fmt.Errorf("on login, got: %w", err)
+	if c.broken {
+		c.broken = false
+		c.reconnects.Add(1)
 	}
-
 	return nil
 }
 
+// Reconnects cuenta los logins exitosos que siguieron a una falla de conexión o envío
+func (c *WailonConnection) Reconnects() int64 {
+	return c.reconnects.Load()
+}
+
+// exchange envía un paquete y espera su respuesta
+func (c *WailonConnection) exchange(kind, packet string) (string, error) {
+	start := time.Now()
+	res, err := writePacket(packet, c.conn, c.reader, c.queueCommand, c.timeout())
+	if c.OnResponse != nil {
+		c.OnResponse(kind, time.Since(start), err)
+	}
+	return res, err
+}
+
+func (c *WailonConnection) setConn(conn net.Conn) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.conn = conn
+}
+
+// dropConn cierra la conexión y descarta su reader; se llama con c.mu tomado
+func (c *WailonConnection) dropConn() {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+	c.conn = nil
+	c.reader = nil
+}
+
+// CloseSocket cierra la conexión; una lectura de ReadCommand en curso termina con error
 func (c *WailonConnection) CloseSocket() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
 	if c.conn != nil {
 		_ = c.conn.Close()
 	}
@@ -55,16 +133,15 @@ func (c *WailonConnection) SendData(params string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.OpenSocket()
-
-	t := time.Now()
-	date := t.In(time.UTC).Format("020106")
-	second := t.In(time.UTC).Format("150405")
+	if err := c.openSocket(); err != nil {
+		return err
+	}
 
-	message := fmt.Sprintf("%s;%s;NA;NA;NA;NA;NA;NA;NA;NA;NA;NA;NA;;NA;%s;", date, second, params)
+	message := dataMessage(clock.Or(c.Clock).Now(), params) + ";"
 	CRC := crcChecksum([]byte(message))
-	res, err := writePacket(fmt.Sprintf("#D#%s%s\r\n", message, CRC), c.conn)
+	res, err := c.exchange("D", fmt.Sprintf("#D#%s%s\r\n", message, CRC))
 	if err != nil {
+		c.broken = true
 		return fmt.Errorf("when writing to wailon, got: %w \nsent:%s", err, message)
 	}
 
@@ -75,18 +152,86 @@ func (c *WailonConnection) SendData(params string) error {
 	return nil
 }
 
+// dataMessage arma el cuerpo de un mensaje de datos (#D# o registro de #B#) sin CRC
+func dataMessage(t time.Time, params string) string {
+	date := t.In(time.UTC).Format("020106")
+	second := t.In(time.UTC).Format("150405")
+	return fmt.Sprintf("%s;%s;NA;NA;NA;NA;NA;NA;NA;NA;NA;NA;NA;;NA;%s", date, second, params)
+}
+
+type BlackBoxRecord struct {
+	Time   time.Time
+	Params string
+}
+
+// SendBlackBox envía registros con su hora original en un paquete #B#
+// y devuelve cuántos confirmó el servidor en #AB#.
+func (c *WailonConnection) SendBlackBox(records []BlackBoxRecord) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.openSocket(); err != nil {
+		return 0, err
+	}
+
+	var b strings.Builder
+	for _, r := range records {
+		b.WriteString(dataMessage(r.Time, r.Params))
+		b.WriteString("|")
+	}
+	message := b.String()
+	CRC := crcChecksum([]byte(message))
+	res, err := c.exchange("B", fmt.Sprintf("#B#%s%s\r\n", message, CRC))
+	if err != nil {
+		c.broken = true
+		return 0, fmt.Errorf("when writing black box to wailon, got: %w", err)
+	}
+	if !strings.HasPrefix(res, "#AB#") {
+		return 0, fmt.Errorf("black box response unsuccessful, got: %s", res)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(res, "#AB#")))
+	if err != nil {
+		return 0, fmt.Errorf("black box response unsuccessful, got: %s", res)
+	}
+	return n, nil
+}
+
 func (c *WailonConnection) ReadCommand() (kind string, value string, e error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	data, err := readPacket(c.conn)
-	if data == "" {
-		return "Timeout", "", nil
+	if len(c.commands) > 0 {
+		data := c.commands[0]
+		c.commands = c.commands[1:]
+		return parseCommand(data)
+	}
+	if c.conn == nil {
+		return "", "", errNotConnected
 	}
+	data, err := readPacket(c.conn, c.reader, c.readWait())
 	if err != nil {
-		return "", "", err
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return "Timeout", "", nil
+		}
+		// enlace caído (EOF, reset): Run espera y reconecta
+		c.broken = true
+		c.dropConn()
+		return "", "", fmt.Errorf("reading command, got: %w", err)
+	}
+	if strings.HasPrefix(data, "#A") {
+		// respuesta duplicada o tardía de un paquete anterior
+		log.Printf("ignoring stale response %q", data)
+		return "Timeout", "", nil
 	}
+	return parseCommand(data)
+}
+
+func (c *WailonConnection) queueCommand(data string) {
+	c.commands = append(c.commands, data)
+}
 
+func parseCommand(data string) (kind string, value string, e error) {
 	headerMessage := strings.Split(data, "#M#")
 	if len(headerMessage) != 2 || headerMessage[0] != "" {
 		return "", "", fmt.Errorf("should contain #M#: %s", data)
@@ -104,10 +249,17 @@ func (c *WailonConnection) ReadCommand() (kind string, value string, e error) {
 func (c *WailonConnection) SendPing() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.conn == nil {
+		return c.openSocket()
+	}
 	login := fmt.Sprintf("2.0;%s;NA;", c.Imei)
 	CRC := crcChecksum([]byte(login))
-	if res, err := writePacket(fmt.Sprintf("#L#%s%s\r\n", login, CRC), c.conn); err != nil {
-		return fmt.Errorf("writing to wailon, res: %s, got: %w", res, err)
+	if res, err := c.exchange("L", fmt.Sprintf("#L#%s%s\r\n", login, CRC)); err != nil {
+		// el socket quedó muerto tras un corte: reconectar (el login hace de ping)
+		c.broken = true
+		if errOpen := c.openSocket(); errOpen != nil {
+			return fmt.Errorf("writing to wailon, res: %s, got: %w", res, err)
+		}
 	}
 	//log.Printf("Ping, got %s", res)
 	return nil
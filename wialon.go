@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mt-plc-control/alarms"
+	"mt-plc-control/clock"
+	"mt-plc-control/diagnostics"
+	"mt-plc-control/uplink"
+	"mt-plc-control/wailonServer"
+	"strings"
+	"time"
+)
+
+// IDataIO es la conexión con el servidor Wialon IPS
+type IDataIO interface {
+	OpenSocket() error
+	SendPing() error
+	CloseSocket()
+	SendData(params string) error
+	ReadCommand() (string, string, error)
+}
+
+// wialonUplink sube los escaneos como #D# con params name:tipo:valor y lee
+// los #M#. Sin nada que subir manda un ping; tras InitWailonFails pings
+// fallidos seguidos detiene el gateway.
+type wialonUplink struct {
+	conn   IDataIO
+	bf     *wailonServer.Backfill
+	diag   *diagnostics.Collector
+	alarms *alarms.Table
+	fails  comFailures
+	clock  clock.Clock // nil usa el reloj real
+}
+
+func newWialonUplink(conn IDataIO, bf *wailonServer.Backfill, diag *diagnostics.Collector, t *alarms.Table, clk clock.Clock) *wialonUplink {
+	return &wialonUplink{conn: conn, bf: bf, diag: diag, alarms: t, fails: InitWailonFails, clock: clk}
+}
+
+func (w *wialonUplink) Name() string {
+	return "wialon"
+}
+
+func (w *wialonUplink) Publish(ctx context.Context, b uplink.Batch) error {
+	if !b.Upload {
+		if err := w.conn.SendPing(); err != nil {
+			log.Printf("Error sending ping: %v", err)
+			w.alarms.Set("wialon", true, "Wialon link down", b.Time)
+			w.bf.LiveFailed()
+			return comFail(&w.fails)
+		}
+		w.fails = InitWailonFails
+		w.alarms.Set("wialon", false, "", b.Time)
+		w.bf.LiveSent(b.Time)
+		return nil
+	}
+
+	params := wialonParams(b)
+	if diag := w.diag.Params(b.Time); diag != "" {
+		params = fmt.Sprintf("%s,%s", diag, params)
+	}
+	if err := w.conn.SendData(params); err != nil {
+		log.Printf("Error: %v", err)
+		w.alarms.Set("wialon", true, "Wialon link down", b.Time)
+		w.bf.LiveFailed()
+		return nil
+	}
+	w.alarms.Set("wialon", false, "", b.Time)
+	w.bf.LiveSent(b.Time)
+	if b.Delivered != nil {
+		b.Delivered()
+	}
+	return nil
+}
+
+// wialonParams arma los params de un escaneo: cada tag precedido de su
+// calidad (<tag>_q y <tag>_ts) y después los valores derivados
+func wialonParams(b uplink.Batch) string {
+	params := make([]string, 0, 2*len(b.Samples)+len(b.Derived))
+	for _, s := range b.Samples {
+		params = append(params, s.Params(s.Name), wialonParam(s))
+	}
+	for _, s := range b.Derived {
+		params = append(params, wialonParam(s))
+	}
+	return strings.Join(params, ",")
+}
+
+func wialonParam(s uplink.Sample) string {
+	switch s.Type {
+	case uplink.Float:
+		return fmt.Sprintf("%s:2:%g", s.Name, s.Value)
+	case uplink.Text:
+		return fmt.Sprintf("%s:3:%s", s.Name, s.Text)
+	}
+	return fmt.Sprintf("%s:1:%d", s.Name, int64(s.Value))
+}
+
+func (w *wialonUplink) Run(ctx context.Context, exec func(uplink.Command) error) {
+	for ctx.Err() == nil {
+		kind, message, err := w.conn.ReadCommand()
+		if err != nil {
+			log.Printf("Error: %v", err)
+			// sin conexión ReadCommand falla al instante: no girar en vacío
+			select {
+			case <-clock.Or(w.clock).After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			if err := w.conn.OpenSocket(); err != nil {
+				log.Printf("Error reconnecting to wialon: %v", err)
+			}
+			continue
+		}
+		if strings.ToUpper(kind) == "TIMEOUT" {
+			continue
+		}
+		cmd, err := uplink.ParseCommand(kind, message)
+		if err != nil {
+			log.Print(err)
+			if len(cmd.Writes) == 0 {
+				continue
+			}
+		}
+		if err := exec(cmd); err != nil {
+			log.Printf("wialon %s: %v", cmd, err)
+		}
+	}
+}
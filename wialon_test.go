@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"mt-plc-control/faultProxy"
+	"mt-plc-control/quality"
+	"mt-plc-control/uplink"
+	"mt-plc-control/wailonServer"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWialonParams(t *testing.T) {
+	now := time.Unix(1772359200, 0)
+	b := uplink.Batch{
+		Time:   now,
+		Upload: true,
+		Samples: []uplink.Sample{
+			{Name: "q1", Type: uplink.Bool, Tag: quality.Tag{Value: 1}},
+			{Name: "energia", Type: uplink.Int, Tag: quality.Tag{Value: 70000, Quality: quality.CommFailure, LastGood: now.Add(-time.Minute)}},
+		},
+		Derived: []uplink.Sample{
+			{Name: "energia_delta", Type: uplink.Int, Tag: quality.Tag{Value: 12}},
+			{Name: "temp", Type: uplink.Float, Tag: quality.Tag{Value: 21.5}},
+			{Name: "fw", Type: uplink.Text, Text: "v1"},
+		},
+	}
+	want := "q1_q:1:0,q1:1:1,energia_q:1:2,energia_ts:1:1772359140,energia:1:70000," +
+		"energia_delta:1:12,temp:2:21.5,fw:3:v1"
+	if got := wialonParams(b); got != want {
+		t.Errorf("params:\n got %s\nwant %s", got, want)
+	}
+}
+
+// countingIO cuenta las lecturas de comandos de una conexión Wialon
+type countingIO struct {
+	*wailonServer.WailonConnection
+	reads atomic.Int64
+}
+
+func (c *countingIO) ReadCommand() (string, string, error) {
+	c.reads.Add(1)
+	return c.WailonConnection.ReadCommand()
+}
+
+func TestWialonUplink_RunReconnectsAfterLinkDrop(t *testing.T) {
+	ips := wailonServer.NewIPSServer()
+	if err := ips.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ips.Close)
+	p := faultProxy.New(ips.Addr())
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	host, port, _ := net.SplitHostPort(p.Addr())
+	conn := &countingIO{WailonConnection: &wailonServer.WailonConnection{Imei: "1", Url: host, Port: port, Timeout: time.Second}}
+	if err := conn.OpenSocket(); err != nil {
+		t.Fatal(err)
+	}
+	_, i, _ := ips.WaitPacket("L", 0, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		newWialonUplink(conn, nil, nil, nil, nil).Run(ctx, func(uplink.Command) error { return nil })
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		conn.CloseSocket()
+		<-done
+	}()
+
+	// el enlace se corta sin que haya nada que enviar
+	time.Sleep(100 * time.Millisecond)
+	p.ResetConnections()
+	reads := conn.reads.Load()
+	if _, _, ok := ips.WaitPacket("L", i+1, 3*time.Second); !ok {
+		t.Fatalf("Run should log in again after the link drops")
+	}
+	// una lectura por cada espera de 800 ms y la del corte, no un giro en vacío
+	if n := conn.reads.Load() - reads; n > 5 {
+		t.Errorf("Run spun on the dead socket: %d reads", n)
+	}
+	// el login se cuenta al leer su respuesta
+	for deadline := time.Now().Add(time.Second); conn.Reconnects() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conn.Reconnects() != 1 {
+		t.Errorf("reconnects: got %d, want 1", conn.Reconnects())
+	}
+}